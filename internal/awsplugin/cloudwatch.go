@@ -0,0 +1,192 @@
+// Package awsplugin provides the functions shared by the aws-* plugins.
+package awsplugin
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// GetMetricData accepts up to 500 queries in a request
+const maxQueriesPerRequest = 500
+
+const (
+	defaultPeriod     = 60
+	defaultRange      = 3 * time.Minute // to fetch at least 1 data-point, since CloudWatch datapoints delay about 2 mins
+	defaultMaxRetries = 5
+	baseRetryDelay    = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+)
+
+// MetricQuery is a statistic of a CloudWatch metric to fetch
+type MetricQuery struct {
+	// Key identifies the value in the result of Fetch
+	Key        string
+	Namespace  string
+	MetricName string
+	Dimensions []*cloudwatch.Dimension
+	// Statistic is one of Average, Sum, SampleCount, Maximum, Minimum or a percentile like p99
+	Statistic string
+	// Period in seconds, 60 by default
+	Period int64
+	// Range overrides CloudWatchFetcher.Range for metrics aggregated over a longer period
+	Range time.Duration
+	// Oldest uses the oldest datapoint in the range for metrics whose latest datapoint is not stable
+	Oldest bool
+}
+
+// CloudWatchFetcher fetches the latest datapoints of many metrics with batched GetMetricData calls
+type CloudWatchFetcher struct {
+	CloudWatch cloudwatchiface.CloudWatchAPI
+	// Range is the time range to look back for the latest datapoint
+	Range time.Duration
+	// MaxRetries is the number of retries when the request is throttled
+	MaxRetries int
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewCloudWatchFetcher returns a CloudWatchFetcher with the default settings
+func NewCloudWatchFetcher(cw cloudwatchiface.CloudWatchAPI) *CloudWatchFetcher {
+	return &CloudWatchFetcher{
+		CloudWatch: cw,
+		Range:      defaultRange,
+		MaxRetries: defaultMaxRetries,
+	}
+}
+
+// Fetch returns the latest value of each query keyed by MetricQuery.Key.
+// The queries which have no datapoints in the range are not included in the result.
+func (f *CloudWatchFetcher) Fetch(queries []MetricQuery) (map[string]float64, error) {
+	// A GetMetricData request has single StartTime, so group the queries by their range
+	var ranges []time.Duration
+	groups := make(map[time.Duration][]MetricQuery)
+	for _, q := range queries {
+		r := q.Range
+		if r == 0 {
+			r = f.Range
+		}
+		if r == 0 {
+			r = defaultRange
+		}
+		if _, ok := groups[r]; !ok {
+			ranges = append(ranges, r)
+		}
+		groups[r] = append(groups[r], q)
+	}
+
+	stat := make(map[string]float64)
+	for _, r := range ranges {
+		group := groups[r]
+		for len(group) > 0 {
+			n := len(group)
+			if n > maxQueriesPerRequest {
+				n = maxQueriesPerRequest
+			}
+			if err := f.fetch(group[:n], r, stat); err != nil {
+				return nil, err
+			}
+			group = group[n:]
+		}
+	}
+	return stat, nil
+}
+
+func (f *CloudWatchFetcher) fetch(queries []MetricQuery, r time.Duration, stat map[string]float64) error {
+	keys := make(map[string]MetricQuery, len(queries))
+	dataQueries := make([]*cloudwatch.MetricDataQuery, len(queries))
+	for i, q := range queries {
+		// Id must start with a lowercase letter
+		id := fmt.Sprintf("m%d", i)
+		keys[id] = q
+		period := q.Period
+		if period == 0 {
+			period = defaultPeriod
+		}
+		dataQueries[i] = &cloudwatch.MetricDataQuery{
+			Id: aws.String(id),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(q.Namespace),
+					MetricName: aws.String(q.MetricName),
+					Dimensions: q.Dimensions,
+				},
+				Period: aws.Int64(period),
+				Stat:   aws.String(q.Statistic),
+			},
+			ReturnData: aws.Bool(true),
+		}
+	}
+
+	now := time.Now()
+	if f.now != nil {
+		now = f.now()
+	}
+	input := &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(now.Add(-r)),
+		EndTime:           aws.Time(now),
+		MetricDataQueries: dataQueries,
+		ScanBy:            aws.String(cloudwatch.ScanByTimestampDescending),
+	}
+
+	picked := make(map[string]time.Time)
+	for {
+		output, err := f.getMetricData(input)
+		if err != nil {
+			return err
+		}
+		for _, result := range output.MetricDataResults {
+			q, ok := keys[aws.StringValue(result.Id)]
+			if !ok {
+				continue
+			}
+			for i, v := range result.Values {
+				if i >= len(result.Timestamps) || v == nil {
+					break
+				}
+				ts := aws.TimeValue(result.Timestamps[i])
+				if t, ok := picked[q.Key]; ok && (q.Oldest && !ts.Before(t) || !q.Oldest && !ts.After(t)) {
+					continue
+				}
+				picked[q.Key] = ts
+				stat[q.Key] = *v
+			}
+		}
+		if output.NextToken == nil {
+			return nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func (f *CloudWatchFetcher) getMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	for retry := 0; ; retry++ {
+		output, err := f.CloudWatch.GetMetricData(input)
+		if err == nil {
+			return output, nil
+		}
+		if retry >= f.MaxRetries || !request.IsErrorThrottle(err) {
+			return nil, err
+		}
+		if f.sleep != nil {
+			f.sleep(backoff(retry))
+		} else {
+			time.Sleep(backoff(retry))
+		}
+	}
+}
+
+// backoff returns exponential delay with full jitter
+func backoff(retry int) time.Duration {
+	d := baseRetryDelay << uint(retry)
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,136 @@
+package awsplugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+	inputs    []*cloudwatch.GetMetricDataInput
+	throttles int
+	err       error
+}
+
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	copied := *input
+	m.inputs = append(m.inputs, &copied)
+	if m.throttles > 0 {
+		m.throttles--
+		return nil, awserr.New("Throttling", "Rate exceeded", nil)
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	now := aws.TimeValue(input.EndTime)
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, q := range input.MetricDataQueries {
+		result := &cloudwatch.MetricDataResult{Id: q.Id}
+		switch aws.StringValue(q.MetricStat.Metric.MetricName) {
+		case "NoData":
+		case "Paged":
+			// the older datapoint comes in the next page
+			if input.NextToken == nil {
+				result.Timestamps = []*time.Time{aws.Time(now.Add(-1 * time.Minute))}
+				result.Values = []*float64{aws.Float64(2)}
+			} else {
+				result.Timestamps = []*time.Time{aws.Time(now.Add(-2 * time.Minute))}
+				result.Values = []*float64{aws.Float64(1)}
+			}
+		default:
+			result.Timestamps = []*time.Time{aws.Time(now.Add(-1 * time.Minute)), aws.Time(now.Add(-2 * time.Minute))}
+			result.Values = []*float64{aws.Float64(10), aws.Float64(20)}
+		}
+		output.MetricDataResults = append(output.MetricDataResults, result)
+	}
+	for _, q := range input.MetricDataQueries {
+		if aws.StringValue(q.MetricStat.Metric.MetricName) == "Paged" && input.NextToken == nil {
+			output.NextToken = aws.String("next")
+		}
+	}
+	return output, nil
+}
+
+func newTestFetcher(cw cloudwatchiface.CloudWatchAPI) *CloudWatchFetcher {
+	f := NewCloudWatchFetcher(cw)
+	f.now = func() time.Time { return time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC) }
+	f.sleep = func(time.Duration) {}
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	cw := &mockCloudWatchClient{}
+	f := newTestFetcher(cw)
+
+	dimensions := []*cloudwatch.Dimension{{Name: aws.String("DBInstanceIdentifier"), Value: aws.String("db1")}}
+	stat, err := f.Fetch([]MetricQuery{
+		{Key: "CPUUtilization", Namespace: "AWS/RDS", MetricName: "CPUUtilization", Dimensions: dimensions, Statistic: "Average"},
+		{Key: "NoData", Namespace: "AWS/RDS", MetricName: "NoData", Dimensions: dimensions, Statistic: "Average"},
+		{Key: "Paged", Namespace: "AWS/RDS", MetricName: "Paged", Dimensions: dimensions, Statistic: "Sum"},
+		{Key: "Oldest", Namespace: "AWS/RDS", MetricName: "CPUUtilization", Dimensions: dimensions, Statistic: "Average", Oldest: true},
+		{Key: "Slow", Namespace: "AWS/RDS", MetricName: "Slow", Dimensions: dimensions, Statistic: "Sum", Period: 300, Range: 15 * time.Minute},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"CPUUtilization": 10, "Oldest": 20, "Paged": 2, "Slow": 10}, stat)
+
+	// 2 pages of the default range and 1 request of 15 minutes range
+	assert.Len(t, cw.inputs, 3)
+	assert.Len(t, cw.inputs[0].MetricDataQueries, 4)
+	assert.Equal(t, 3*time.Minute, cw.inputs[0].EndTime.Sub(*cw.inputs[0].StartTime))
+	assert.Equal(t, "next", aws.StringValue(cw.inputs[1].NextToken))
+	assert.Equal(t, 15*time.Minute, cw.inputs[2].EndTime.Sub(*cw.inputs[2].StartTime))
+	assert.EqualValues(t, 300, *cw.inputs[2].MetricDataQueries[0].MetricStat.Period)
+	assert.EqualValues(t, 60, *cw.inputs[0].MetricDataQueries[0].MetricStat.Period)
+}
+
+func TestFetchBatch(t *testing.T) {
+	cw := &mockCloudWatchClient{}
+	f := newTestFetcher(cw)
+
+	var queries []MetricQuery
+	for i := 0; i < 1200; i++ {
+		queries = append(queries, MetricQuery{Key: fmt.Sprintf("cpu%d", i), Namespace: "AWS/EC2", MetricName: "CPUUtilization", Statistic: "Average"})
+	}
+	stat, err := f.Fetch(queries)
+	assert.Nil(t, err)
+	assert.Len(t, stat, 1200)
+	assert.Len(t, cw.inputs, 3)
+	assert.Len(t, cw.inputs[0].MetricDataQueries, 500)
+	assert.Len(t, cw.inputs[2].MetricDataQueries, 200)
+}
+
+func TestFetchThrottled(t *testing.T) {
+	queries := []MetricQuery{{Key: "CPUUtilization", Namespace: "AWS/EC2", MetricName: "CPUUtilization", Statistic: "Average"}}
+
+	cw := &mockCloudWatchClient{throttles: 2}
+	stat, err := newTestFetcher(cw).Fetch(queries)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, stat["CPUUtilization"])
+	assert.Len(t, cw.inputs, 3)
+
+	cw = &mockCloudWatchClient{throttles: 10}
+	_, err = newTestFetcher(cw).Fetch(queries)
+	assert.NotNil(t, err)
+	assert.Len(t, cw.inputs, defaultMaxRetries+1)
+
+	cw = &mockCloudWatchClient{err: errors.New("AccessDenied")}
+	_, err = newTestFetcher(cw).Fetch(queries)
+	assert.NotNil(t, err)
+	assert.Len(t, cw.inputs, 1, "do not retry other errors")
+}
+
+func TestBackoff(t *testing.T) {
+	for retry := 0; retry < 100; retry++ {
+		d := backoff(retry)
+		assert.True(t, d >= 0 && d < maxRetryDelay)
+	}
+}
@@ -0,0 +1,105 @@
+package awsplugin
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// SessionOptions are the region and the credential settings shared by the aws-* plugins
+type SessionOptions struct {
+	// Region is detected from the environment, the shared config or EC2 instance metadata if empty
+	Region string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// Profile is a profile name of the shared config and credentials files
+	Profile string
+
+	// RoleARN is a role to assume with the credentials above
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	// WebIdentityTokenFile is an OIDC token file to assume RoleARN with
+	WebIdentityTokenFile string
+}
+
+// AddCredentialFlags defines the flags of the credential settings in fs
+func (o *SessionOptions) AddCredentialFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.AccessKeyID, "access-key-id", "", "AWS Access Key ID")
+	fs.StringVar(&o.SecretAccessKey, "secret-access-key", "", "AWS Secret Access Key")
+	fs.StringVar(&o.Profile, "profile", "", "AWS shared config profile name")
+	fs.StringVar(&o.RoleARN, "role-arn", "", "ARN of the IAM role to assume")
+	fs.StringVar(&o.ExternalID, "external-id", "", "External ID to assume the role with")
+	fs.StringVar(&o.RoleSessionName, "role-session-name", "", "Session name of the assumed role")
+	fs.StringVar(&o.WebIdentityTokenFile, "web-identity-token-file", "", "Web identity token file to assume the role with")
+}
+
+// NewSession creates a session with the options.
+// Without any credential settings, the credentials are resolved by the default chain of the SDK:
+// the environment variables (including AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE),
+// the shared credentials file and the IAM role of EC2 instances or ECS tasks.
+func (o SessionOptions) NewSession() (*session.Session, error) {
+	if o.WebIdentityTokenFile != "" && o.RoleARN == "" {
+		return nil, errors.New("-role-arn is required to use -web-identity-token-file")
+	}
+	if (o.AccessKeyID == "") != (o.SecretAccessKey == "") {
+		return nil, errors.New("both of -access-key-id and -secret-access-key are required")
+	}
+
+	config := aws.NewConfig()
+	if o.Region != "" {
+		config = config.WithRegion(o.Region)
+	}
+	if o.AccessKeyID != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(o.AccessKeyID, o.SecretAccessKey, ""))
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           o.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.StringValue(sess.Config.Region) == "" {
+		if region := metadataRegion(sess); region != "" {
+			sess.Config.Region = aws.String(region)
+		}
+	}
+
+	// the STS client of the providers copies the config of sess at this point,
+	// so the role is assumed with the credentials resolved above
+	switch {
+	case o.WebIdentityTokenFile != "":
+		sess.Config.Credentials = stscreds.NewWebIdentityCredentials(sess, o.RoleARN, o.RoleSessionName, o.WebIdentityTokenFile)
+	case o.RoleARN != "":
+		sess.Config.Credentials = stscreds.NewCredentials(sess, o.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if o.ExternalID != "" {
+				p.ExternalID = aws.String(o.ExternalID)
+			}
+			if o.RoleSessionName != "" {
+				p.RoleSessionName = o.RoleSessionName
+			}
+		})
+	}
+	return sess, nil
+}
+
+func metadataRegion(sess *session.Session) string {
+	metadata := ec2metadata.New(sess)
+	if !metadata.Available() {
+		return ""
+	}
+	region, err := metadata.Region()
+	if err != nil {
+		return ""
+	}
+	return region
+}
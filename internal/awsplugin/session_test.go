@@ -0,0 +1,45 @@
+package awsplugin
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCredentialFlags(t *testing.T) {
+	var o SessionOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.AddCredentialFlags(fs)
+	err := fs.Parse([]string{
+		"-profile=prod",
+		"-role-arn=arn:aws:iam::123456789012:role/mackerel",
+		"-external-id=xyz",
+		"-role-session-name=mackerel-agent",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, SessionOptions{
+		Profile:         "prod",
+		RoleARN:         "arn:aws:iam::123456789012:role/mackerel",
+		ExternalID:      "xyz",
+		RoleSessionName: "mackerel-agent",
+	}, o)
+}
+
+func TestNewSession(t *testing.T) {
+	sess, err := SessionOptions{Region: "ap-northeast-1", AccessKeyID: "id", SecretAccessKey: "secret"}.NewSession()
+	assert.Nil(t, err)
+	assert.Equal(t, "ap-northeast-1", aws.StringValue(sess.Config.Region))
+	assert.NotNil(t, sess.Config.Credentials)
+
+	sess, err = SessionOptions{Region: "us-east-1", RoleARN: "arn:aws:iam::123456789012:role/mackerel"}.NewSession()
+	assert.Nil(t, err)
+	assert.NotNil(t, sess.Config.Credentials)
+
+	_, err = SessionOptions{Region: "us-east-1", WebIdentityTokenFile: "/var/run/token"}.NewSession()
+	assert.NotNil(t, err, "-web-identity-token-file requires -role-arn")
+
+	_, err = SessionOptions{Region: "us-east-1", AccessKeyID: "id"}.NewSession()
+	assert.NotNil(t, err, "-access-key-id requires -secret-access-key")
+}
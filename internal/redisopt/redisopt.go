@@ -0,0 +1,86 @@
+package redisopt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"strings"
+)
+
+// Options are the authentication and TLS settings shared by the plugins connecting to Redis
+type Options struct {
+	// Username is an ACL user of Redis 6, authenticated with AUTH <username> <password>
+	Username string
+	Password string
+	// PasswordFile is a file containing the password, which overrides Password
+	PasswordFile string
+
+	TLS           bool
+	TLSCACert     string
+	TLSCert       string
+	TLSKey        string
+	TLSSkipVerify bool
+}
+
+// AddFlags defines the flags of the settings in fs
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Username, "username", "", "Username of ACL")
+	fs.StringVar(&o.Password, "password", "", "Password")
+	fs.StringVar(&o.PasswordFile, "password-file", "", "File containing the password (overrides password)")
+	fs.BoolVar(&o.TLS, "tls", false, "Connect over TLS")
+	fs.StringVar(&o.TLSCACert, "tls-ca-cert", "", "CA certificate file to verify the server")
+	fs.StringVar(&o.TLSCert, "tls-cert", "", "Client certificate file")
+	fs.StringVar(&o.TLSKey, "tls-key", "", "Client private key file")
+	fs.BoolVar(&o.TLSSkipVerify, "tls-skip-verify", false, "Skip verifying the certificate of the server")
+}
+
+// ResolvePassword returns the password, which is read from PasswordFile if given
+func (o Options) ResolvePassword() (string, error) {
+	if o.PasswordFile == "" {
+		return o.Password, nil
+	}
+	return ReadPasswordFile(o.PasswordFile)
+}
+
+// TLSConfig returns the config of TLS, or nil without TLS
+func (o Options) TLSConfig() (*tls.Config, error) {
+	if !o.TLS {
+		return nil, nil
+	}
+	return NewTLSConfig(o.TLSCACert, o.TLSCert, o.TLSKey, o.TLSSkipVerify)
+}
+
+// NewTLSConfig returns the config of TLS with the CA certificate and the client certificate in PEM files
+func NewTLSConfig(caCert, cert, key string, skipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: skipVerify}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + caCert)
+		}
+		config.RootCAs = pool
+	}
+	if cert != "" || key != "" {
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{c}
+	}
+	return config, nil
+}
+
+// ReadPasswordFile reads the password from the file without the trailing newline
+func ReadPasswordFile(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,82 @@
+package redisopt
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddFlags(t *testing.T) {
+	var o Options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.AddFlags(fs)
+	err := fs.Parse([]string{
+		"-username=mackerel",
+		"-password-file=/etc/mackerel-agent/redis-password",
+		"-tls",
+		"-tls-ca-cert=/etc/ssl/redis-ca.pem",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, Options{
+		Username:     "mackerel",
+		PasswordFile: "/etc/mackerel-agent/redis-password",
+		TLS:          true,
+		TLSCACert:    "/etc/ssl/redis-ca.pem",
+	}, o)
+}
+
+func TestResolvePassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redisopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("s3cr=t \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	password, err := Options{Password: "secret"}.ResolvePassword()
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", password)
+
+	password, err = Options{Password: "secret", PasswordFile: file}.ResolvePassword()
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cr=t ", password, "the file overrides the password, keeping the trailing spaces")
+
+	_, err = Options{PasswordFile: filepath.Join(dir, "none")}.ResolvePassword()
+	assert.NotNil(t, err, "the file not found")
+}
+
+func TestTLSConfig(t *testing.T) {
+	config, err := Options{}.TLSConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, config, "no TLS")
+
+	config, err = Options{TLS: true, TLSSkipVerify: true}.TLSConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.InsecureSkipVerify)
+	assert.Nil(t, config.RootCAs)
+	assert.Empty(t, config.Certificates)
+
+	dir, err := ioutil.TempDir("", "redisopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(file, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewTLSConfig(file, "", "", false)
+	assert.NotNil(t, err, "the invalid CA certificate")
+
+	_, err = NewTLSConfig("", filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), false)
+	assert.NotNil(t, err, "the client certificate not found")
+}
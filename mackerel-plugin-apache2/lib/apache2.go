@@ -23,6 +23,8 @@ type Apache2Plugin struct {
 	Tempfile    string
 	Prefix      string
 	LabelPrefix string
+	// VHost enables the metrics per vhost parsed from the HTML server-status
+	VHost bool
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -85,6 +87,33 @@ func (c Apache2Plugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "score-", Label: "Open slot", Diff: false, Stacked: true},
 			},
 		},
+		"connections": {
+			Label: (labelPrefix + " Connections"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "conns_total", Label: "Total", Diff: false},
+				{Name: "conns_async_writing", Label: "Async Writing", Diff: false, Stacked: true},
+				{Name: "conns_async_keepalive", Label: "Async Keepalive", Diff: false, Stacked: true},
+				{Name: "conns_async_closing", Label: "Async Closing", Diff: false, Stacked: true},
+			},
+		},
+	}
+	if c.VHost {
+		graphdef["vhost.busy_workers.#"] = mp.Graphs{
+			Label: (labelPrefix + " VHost Busy Workers"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "busy", Label: "Busy Workers", Diff: false},
+			},
+		}
+		graphdef["vhost.request_duration.#"] = mp.Graphs{
+			Label: (labelPrefix + " VHost Request Duration (ms)"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "max", Label: "Longest Recent Request", Diff: false},
+				{Name: "current_max", Label: "Longest Request in Progress", Diff: false},
+			},
+		}
 	}
 	return graphdef
 }
@@ -100,6 +129,7 @@ func doMain(c *cli.Context) error {
 	apache2.Header = c.StringSlice("header")
 	apache2.Prefix = c.String("metric-key-prefix")
 	apache2.LabelPrefix = c.String("metric-label-prefix")
+	apache2.VHost = c.Bool("vhost")
 
 	helper := mp.NewMackerelPlugin(apache2)
 	helper.Tempfile = c.String("tempfile")
@@ -125,9 +155,25 @@ func (c Apache2Plugin) FetchMetrics() (map[string]interface{}, error) {
 		return nil, errScore
 	}
 
+	if c.VHost {
+		// the HTML page has the table of the workers
+		path := strings.SplitN(c.Path, "?", 2)[0]
+		page, err := getApache2Metrics(c.Host, c.Port, path, c.Header)
+		if err != nil {
+			return nil, err
+		}
+		errVHost := parseApache2VHostStatus(page, &stat)
+		if errVHost != nil {
+			return nil, errVHost
+		}
+	}
+
 	return stat, nil
 }
 
+// the states of the scoreboard, where "" is the open slot "."
+var scoreboardStates = []string{"_", "S", "R", "W", "K", "D", "C", "L", "G", "I", ""}
+
 // parsing scoreboard from server-status?auto
 func parseApache2Scoreboard(str string, p *map[string]interface{}) error {
 	for _, line := range strings.Split(str, "\n") {
@@ -138,6 +184,10 @@ func parseApache2Scoreboard(str string, p *map[string]interface{}) error {
 		if !matched {
 			continue
 		}
+		// all states are posted even if no workers are in the state
+		for _, sb := range scoreboardStates {
+			(*p)["score-"+sb] = 0.0
+		}
 		record := strings.Split(line, ":")
 		for _, sb := range strings.Split(strings.Trim(record[1], " "), "") {
 			if sb == "." {
@@ -163,7 +213,12 @@ func parseApache2Status(str string, p *map[string]interface{}) error {
 		"Total kBytes":   "bytes_sent",
 		"CPULoad":        "cpu_load",
 		"BusyWorkers":    "busy_workers",
-		"IdleWorkers":    "idle_workers"}
+		"IdleWorkers":    "idle_workers",
+		// the connections of event MPM
+		"ConnsTotal":          "conns_total",
+		"ConnsAsyncWriting":   "conns_async_writing",
+		"ConnsAsyncKeepAlive": "conns_async_keepalive",
+		"ConnsAsyncClosing":   "conns_async_closing"}
 
 	for _, line := range strings.Split(str, "\n") {
 		record := strings.Split(line, ":")
@@ -90,3 +90,79 @@ Scoreboard: W_.__...........................`
 	assert.Contains(t, ret, "IdleWorkers")
 	assert.Contains(t, ret, "Scoreboard")
 }
+
+func TestParseApache2StatusEventMPM(t *testing.T) {
+	stub := `ServerVersion: Apache/2.4.41 (Ubuntu)
+ServerMPM: event
+Total Accesses: 1042
+Total kBytes: 3385
+CPULoad: .00527
+BusyWorkers: 2
+IdleWorkers: 48
+ConnsTotal: 5
+ConnsAsyncWriting: 1
+ConnsAsyncKeepAlive: 3
+ConnsAsyncClosing: 0
+Scoreboard: __W__K__........
+`
+	stat := make(map[string]interface{})
+
+	err := parseApache2Status(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["conns_total"], 5)
+	assert.EqualValues(t, stat["conns_async_writing"], 1)
+	assert.EqualValues(t, stat["conns_async_keepalive"], 3)
+	assert.EqualValues(t, stat["conns_async_closing"], 0)
+
+	err = parseApache2Scoreboard(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["score-_"], 6)
+	assert.EqualValues(t, stat["score-R"], 0, "the states without workers are posted as 0")
+}
+
+func TestParseApache2VHostStatus(t *testing.T) {
+	stub := `<html><head>
+<title>Apache Status</title>
+</head><body>
+<h1>Apache Server Status for localhost (via 127.0.0.1)</h1>
+
+<table border="0"><tr><th>Srv</th><th>PID</th><th>Acc</th><th>M</th><th>CPU
+</th><th>SS</th><th>Req</th><th>Dur</th><th>Conn</th><th>Child</th><th>Slot</th><th>Client</th><th>Protocol</th><th>VHost</th><th>Request</th></tr>
+
+<tr><td><b>0-0</b></td><td>1201</td><td>0/12/12</td><td>_
+</td><td>0.02</td><td>3</td><td>120</td><td>480</td><td>0.0</td><td>0.05</td><td>0.05
+</td><td>10.0.0.5</td><td>http/1.1</td><td nowrap>www.example.com:80</td><td nowrap>GET /index.html HTTP/1.1</td></tr>
+
+<tr><td><b>0-0</b></td><td>1201</td><td>1/8/8</td><td><b>W</b>
+</td><td>0.01</td><td>12</td><td>0</td><td>310</td><td>0.0</td><td>0.02</td><td>0.02
+</td><td>10.0.0.6</td><td>http/1.1</td><td nowrap>www.example.com:80</td><td nowrap>POST /upload HTTP/1.1</td></tr>
+
+<tr><td><b>1-0</b></td><td>1202</td><td>0/3/3</td><td>_
+</td><td>0.00</td><td>40</td><td>15</td><td>45</td><td>0.0</td><td>0.01</td><td>0.01
+</td><td>10.0.0.7</td><td>http/1.1</td><td nowrap>api.example.com:443</td><td nowrap>GET /v1/items HTTP/1.1</td></tr>
+
+<tr><td><b>1-0</b></td><td>-</td><td>0/0/0</td><td>.
+</td><td>0.00</td><td>0</td><td>0</td><td>0</td><td>0.0</td><td>0.00</td><td>0.00
+</td><td></td><td></td><td nowrap></td><td nowrap></td></tr>
+
+</table>
+ <hr /> <table>
+ <tr><th>Srv</th><td>Child Server number - generation</td></tr>
+ <tr><th>SS</th><td>Seconds since beginning of most recent request</td></tr>
+ </table>
+</body></html>
+`
+	stat := make(map[string]interface{})
+
+	err := parseApache2VHostStatus(stub, &stat)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["vhost.busy_workers.www_example_com_80.busy"], 1)
+	assert.EqualValues(t, stat["vhost.request_duration.www_example_com_80.max"], 120)
+	assert.EqualValues(t, stat["vhost.request_duration.www_example_com_80.current_max"], 12000)
+	assert.EqualValues(t, stat["vhost.busy_workers.api_example_com_443.busy"], 0)
+	assert.EqualValues(t, stat["vhost.request_duration.api_example_com_443.max"], 15)
+	assert.Len(t, stat, 6)
+
+	err = parseApache2VHostStatus("<html><body><table><tr><th>Srv</th><th>PID</th></tr></table></body></html>", &stat)
+	assert.NotNil(t, err, "ExtendedStatus Off")
+}
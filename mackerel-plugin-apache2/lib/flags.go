@@ -12,6 +12,7 @@ var flags = []cli.Flag{
 	cliTempFile,
 	cliMetricKerPrefix,
 	cliLabelPrefix,
+	cliVHost,
 }
 
 var cliHTTPHost = cli.StringFlag{
@@ -59,3 +60,8 @@ var cliLabelPrefix = cli.StringFlag{
 	Value: "Apache",
 	Usage: "Set metric label prefix.",
 }
+
+var cliVHost = cli.BoolFlag{
+	Name:  "vhost",
+	Usage: "Post the busy workers and the request duration per vhost from the HTML status page. (requires ExtendedStatus On)",
+}
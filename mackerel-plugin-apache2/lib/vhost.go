@@ -0,0 +1,79 @@
+package mpapache2
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	tableRowRe   = regexp.MustCompile(`(?is)<tr>(.*?)</tr>`)
+	tableCellRe  = regexp.MustCompile(`(?is)<t[dh][^>]*>(.*?)</t[dh]>`)
+	htmlTagRe    = regexp.MustCompile(`<[^>]*>`)
+	metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+)
+
+// vhostStatus is the aggregation of the workers serving the vhost
+type vhostStatus struct {
+	busy int
+	// the longest processing time of the most recent requests in milliseconds
+	maxRequestTime float64
+	// the longest time of the requests in progress in milliseconds
+	maxCurrentTime float64
+}
+
+// parsing the worker table of the HTML server-status with ExtendedStatus On
+func parseApache2VHostStatus(str string, p *map[string]interface{}) error {
+	columns := map[string]int{}
+	vhosts := make(map[string]*vhostStatus)
+	for _, row := range tableRowRe.FindAllStringSubmatch(str, -1) {
+		var cells []string
+		for _, cell := range tableCellRe.FindAllStringSubmatch(row[1], -1) {
+			cells = append(cells, strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(cell[1], ""))))
+		}
+		// the header of the worker table, which is followed by the legend table also starting with "Srv"
+		if len(columns) == 0 && len(cells) > 0 && cells[0] == "Srv" {
+			for i, name := range cells {
+				columns[name] = i
+			}
+			continue
+		}
+		if len(columns) == 0 || len(cells) != len(columns) {
+			continue
+		}
+
+		vhost := cells[columns["VHost"]]
+		if vhost == "" {
+			continue
+		}
+		s, ok := vhosts[vhost]
+		if !ok {
+			s = &vhostStatus{}
+			vhosts[vhost] = s
+		}
+		if req, err := strconv.ParseFloat(cells[columns["Req"]], 64); err == nil && req > s.maxRequestTime {
+			s.maxRequestTime = req
+		}
+		mode := cells[columns["M"]]
+		if mode == "_" || mode == "." {
+			continue
+		}
+		s.busy++
+		if ss, err := strconv.ParseFloat(cells[columns["SS"]], 64); err == nil && ss*1000 > s.maxCurrentTime {
+			s.maxCurrentTime = ss * 1000
+		}
+	}
+	if _, ok := columns["VHost"]; !ok {
+		return errors.New("worker table with VHost is not found, ExtendedStatus may be Off")
+	}
+
+	for vhost, s := range vhosts {
+		name := metricNameRe.ReplaceAllString(vhost, "_")
+		(*p)["vhost.busy_workers."+name+".busy"] = float64(s.busy)
+		(*p)["vhost.request_duration."+name+".max"] = s.maxRequestTime
+		(*p)["vhost.request_duration."+name+".current_max"] = s.maxCurrentTime
+	}
+	return nil
+}
@@ -1,16 +1,13 @@
 package mpawscloudfront
 
 import (
-	"errors"
 	"flag"
 	"log"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 const (
@@ -53,32 +50,26 @@ type metrics struct {
 
 // CloudFrontPlugin mackerel plugin for cloudfront
 type CloudFrontPlugin struct {
-	AccessKeyID     string
-	SecretAccessKey string
-	CloudWatch      *cloudwatch.CloudWatch
-	Name            string
+	awsplugin.SessionOptions
+	CloudWatch *cloudwatch.CloudWatch
+	Name       string
 }
 
 func (p *CloudFrontPlugin) prepare() error {
-	sess, err := session.NewSession()
+	// CloudFront metrics are available only in us-east-1
+	p.Region = region
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	config = config.WithRegion(region)
-
-	p.CloudWatch = cloudwatch.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess)
 
 	return nil
 }
 
-func (p CloudFrontPlugin) getLastPoint(metric metrics) (float64, error) {
-	now := time.Now()
-
+// FetchMetrics fetch the metrics
+func (p CloudFrontPlugin) FetchMetrics() (map[string]float64, error) {
 	dimensions := []*cloudwatch.Dimension{
 		{
 			Name:  aws.String("DistributionId"),
@@ -90,46 +81,7 @@ func (p CloudFrontPlugin) getLastPoint(metric metrics) (float64, error) {
 		},
 	}
 
-	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: dimensions,
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)), // 3 min (to fetch at least 1 data-point)
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metric.Name),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String(metric.Type)},
-		Namespace:  aws.String(namespace),
-	})
-	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
-	}
-
-	// get a least recently datapoint
-	// because a most recently datapoint is not stable.
-	least := time.Now()
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(least) {
-			least = *dp.Timestamp
-			if metric.Type == metricsTypeAverage {
-				latestVal = *dp.Average
-			} else if metric.Type == metricsTypeSum {
-				latestVal = *dp.Sum
-			}
-		}
-	}
-
-	return latestVal, nil
-}
-
-// FetchMetrics fetch the metrics
-func (p CloudFrontPlugin) FetchMetrics() (map[string]float64, error) {
-	stat := make(map[string]float64)
-
+	var queries []awsplugin.MetricQuery
 	for _, met := range [...]metrics{
 		{Name: "Requests", Type: metricsTypeSum},
 		{Name: "BytesDownloaded", Type: metricsTypeSum},
@@ -137,15 +89,18 @@ func (p CloudFrontPlugin) FetchMetrics() (map[string]float64, error) {
 		{Name: "4xxErrorRate", Type: metricsTypeAverage},
 		{Name: "5xxErrorRate", Type: metricsTypeAverage},
 	} {
-		v, err := p.getLastPoint(met)
-		if err == nil {
-			stat[met.Name] = v
-		} else {
-			log.Printf("%s: %s", met, err)
-		}
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        met.Name,
+			Namespace:  namespace,
+			MetricName: met.Name,
+			Dimensions: dimensions,
+			Statistic:  met.Type,
+			// get a least recently datapoint
+			// because a most recently datapoint is not stable.
+			Oldest: true,
+		})
 	}
-
-	return stat, nil
+	return awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
 }
 
 // GraphDefinition of CloudFrontPlugin
@@ -155,16 +110,14 @@ func (p CloudFrontPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	var sessionOpts awsplugin.SessionOptions
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optIdentifier := flag.String("identifier", "", "Distribution ID")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var plugin CloudFrontPlugin
-
-	plugin.AccessKeyID = *optAccessKeyID
-	plugin.SecretAccessKey = *optSecretAccessKey
+	plugin.SessionOptions = sessionOpts
 	plugin.Name = *optIdentifier
 
 	err := plugin.prepare()
@@ -0,0 +1,292 @@
+package mpawscloudwatch
+
+import (
+	"flag"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
+)
+
+// CloudWatchPlugin mackerel plugin for the CloudWatch metrics described in the config file
+type CloudWatchPlugin struct {
+	awsplugin.SessionOptions
+	Prefix     string
+	CloudWatch cloudwatchiface.CloudWatchAPI
+
+	conf    *config
+	targets map[string][]target
+}
+
+// target is a combination of the dimension values of a graph
+type target struct {
+	// Name is the part of the metric names for the value, empty if the graph has no wildcards
+	Name       string
+	Dimensions []*cloudwatch.Dimension
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p CloudWatchPlugin) MetricKeyPrefix() string {
+	return p.Prefix
+}
+
+func (p *CloudWatchPlugin) prepare() error {
+	sess, err := p.NewSession()
+	if err != nil {
+		return err
+	}
+	p.CloudWatch = cloudwatch.New(sess)
+	return p.resolveTargets()
+}
+
+// resolveTargets lists the dimension values of the graphs
+func (p *CloudWatchPlugin) resolveTargets() error {
+	p.targets = make(map[string][]target, len(p.conf.Graphs))
+	for name, g := range p.conf.Graphs {
+		var targets []target
+		var err error
+		if g.discovery() {
+			targets, err = discoverTargets(p.CloudWatch, g)
+		} else {
+			targets = expandTargets(g)
+		}
+		if err != nil {
+			return err
+		}
+		p.targets[name] = targets
+	}
+	return nil
+}
+
+func targetName(values []string) string {
+	return metricNameRe.ReplaceAllString(strings.Join(values, "_"), "_")
+}
+
+// expandTargets returns the combinations of the values of the dimensions
+func expandTargets(g *graphConfig) []target {
+	targets := []target{{}}
+	names := [][]string{nil}
+	for _, d := range g.Dimensions {
+		if len(d.Values) == 0 {
+			for i := range targets {
+				targets[i].Dimensions = append(targets[i].Dimensions, &cloudwatch.Dimension{
+					Name:  aws.String(d.Name),
+					Value: aws.String(d.Value),
+				})
+			}
+			continue
+		}
+		var expanded []target
+		var expandedNames [][]string
+		for i, t := range targets {
+			for _, v := range d.Values {
+				dims := append(append([]*cloudwatch.Dimension{}, t.Dimensions...), &cloudwatch.Dimension{
+					Name:  aws.String(d.Name),
+					Value: aws.String(v),
+				})
+				expanded = append(expanded, target{Dimensions: dims})
+				expandedNames = append(expandedNames, append(append([]string{}, names[i]...), v))
+			}
+		}
+		targets, names = expanded, expandedNames
+	}
+	for i := range targets {
+		targets[i].Name = targetName(names[i])
+	}
+	return targets
+}
+
+// discoverTargets lists the metrics which have exactly the dimensions of the graph
+func discoverTargets(cw cloudwatchiface.CloudWatchAPI, g *graphConfig) ([]target, error) {
+	filters := make([]*cloudwatch.DimensionFilter, len(g.Dimensions))
+	for i, d := range g.Dimensions {
+		filters[i] = &cloudwatch.DimensionFilter{Name: aws.String(d.Name)}
+		if d.Value != wildcardValue && len(d.Values) == 0 {
+			filters[i].Value = aws.String(d.Value)
+		}
+	}
+
+	found := make(map[string]target)
+	metricNames := make(map[string]bool)
+	for _, m := range g.Metrics {
+		if metricNames[m.MetricName] {
+			continue
+		}
+		metricNames[m.MetricName] = true
+		err := cw.ListMetricsPages(&cloudwatch.ListMetricsInput{
+			Namespace:  aws.String(g.Namespace),
+			MetricName: aws.String(m.MetricName),
+			Dimensions: filters,
+		}, func(output *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+			for _, metric := range output.Metrics {
+				if t, ok := matchTarget(g, metric.Dimensions); ok {
+					found[t.Name] = t
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]target, 0, len(found))
+	for _, t := range found {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+	return targets, nil
+}
+
+// matchTarget returns the target of the dimensions in the order of the config, if the dimensions match to the graph
+func matchTarget(g *graphConfig, dimensions []*cloudwatch.Dimension) (target, bool) {
+	if len(dimensions) != len(g.Dimensions) {
+		return target{}, false
+	}
+	values := make(map[string]string, len(dimensions))
+	for _, d := range dimensions {
+		values[aws.StringValue(d.Name)] = aws.StringValue(d.Value)
+	}
+
+	var t target
+	var names []string
+	for _, d := range g.Dimensions {
+		v, ok := values[d.Name]
+		if !ok {
+			return target{}, false
+		}
+		switch {
+		case d.Value == wildcardValue:
+			names = append(names, v)
+		case len(d.Values) > 0:
+			if !contains(d.Values, v) {
+				return target{}, false
+			}
+			names = append(names, v)
+		case d.Value != v:
+			return target{}, false
+		}
+		t.Dimensions = append(t.Dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(d.Name),
+			Value: aws.String(v),
+		})
+	}
+	t.Name = targetName(names)
+	return t, true
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchMetrics fetch the metrics
+func (p CloudWatchPlugin) FetchMetrics() (map[string]float64, error) {
+	var queries []awsplugin.MetricQuery
+	for name, g := range p.conf.Graphs {
+		for _, t := range p.targets[name] {
+			keyPrefix := name + "."
+			if g.multiple() {
+				keyPrefix += t.Name + "."
+			}
+			for _, m := range g.Metrics {
+				queries = append(queries, awsplugin.MetricQuery{
+					Key:        keyPrefix + m.Name,
+					Namespace:  g.Namespace,
+					MetricName: m.MetricName,
+					Dimensions: t.Dimensions,
+					Statistic:  m.Statistic,
+					Period:     g.Period,
+					Range:      g.rangeDuration,
+				})
+			}
+		}
+	}
+	return awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+}
+
+// GraphDefinition of CloudWatchPlugin
+func (p CloudWatchPlugin) GraphDefinition() map[string]mp.Graphs {
+	graphdef := make(map[string]mp.Graphs, len(p.conf.Graphs))
+	for name, g := range p.conf.Graphs {
+		key := name
+		if g.multiple() {
+			key += ".#"
+		}
+		label := g.Label
+		if label == "" {
+			label = name
+		}
+		unit := g.Unit
+		if unit == "" {
+			unit = "float"
+		}
+		var metrics []mp.Metrics
+		for _, m := range g.Metrics {
+			metricLabel := m.Label
+			if metricLabel == "" {
+				metricLabel = m.Name
+			}
+			metrics = append(metrics, mp.Metrics{
+				Name:    m.Name,
+				Label:   metricLabel,
+				Stacked: m.Stacked,
+				Scale:   m.Scale,
+				// the metric names are unique only in the graph
+				AbsoluteName: !g.multiple(),
+			})
+		}
+		graphdef[key] = mp.Graphs{
+			Label:   label,
+			Unit:    unit,
+			Metrics: metrics,
+		}
+	}
+	return graphdef
+}
+
+// Do the plugin
+func Do() {
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
+	optConfig := flag.String("config", "", "Config file of the graphs")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optPrefix := flag.String("metric-key-prefix", "cloudwatch", "Metric key prefix")
+	flag.Parse()
+
+	if *optConfig == "" {
+		log.Fatalln("-config is required")
+	}
+	conf, err := loadConfig(*optConfig)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var plugin CloudWatchPlugin
+	plugin.SessionOptions = sessionOpts
+	plugin.Prefix = *optPrefix
+	plugin.conf = conf
+
+	if err := plugin.prepare(); err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(plugin)
+	helper.Tempfile = *optTempfile
+	helper.Run()
+}
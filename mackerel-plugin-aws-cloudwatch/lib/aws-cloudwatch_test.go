@@ -0,0 +1,136 @@
+package mpawscloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `{
+  "graphs": {
+    "sqs_messages": {
+      "label": "SQS Messages",
+      "unit": "integer",
+      "namespace": "AWS/SQS",
+      "dimensions": [{"name": "QueueName", "value": "*"}],
+      "period": 300,
+      "range": "10m",
+      "metrics": [
+        {"name": "visible", "label": "Visible", "metric_name": "ApproximateNumberOfMessagesVisible", "statistic": "Maximum"},
+        {"name": "not_visible", "label": "Not Visible", "metric_name": "ApproximateNumberOfMessagesNotVisible", "statistic": "Maximum"}
+      ]
+    },
+    "apigateway_latency": {
+      "namespace": "AWS/ApiGateway",
+      "dimensions": [{"name": "ApiName", "value": "my-api"}, {"name": "Stage", "values": ["prod", "staging"]}],
+      "metrics": [
+        {"name": "p99", "metric_name": "Latency", "statistic": "p99"}
+      ]
+    },
+    "nat_gateway": {
+      "namespace": "AWS/NATGateway",
+      "dimensions": [{"name": "NatGatewayId", "value": "nat-0123"}],
+      "metrics": [
+        {"name": "error_port_allocation", "metric_name": "ErrorPortAllocation", "statistic": "Sum"}
+      ]
+    }
+  }
+}`
+
+func TestParseConfig(t *testing.T) {
+	conf, err := parseConfig([]byte(testConfig))
+	assert.Nil(t, err)
+	assert.Len(t, conf.Graphs, 3)
+	assert.Equal(t, 10*time.Minute, conf.Graphs["sqs_messages"].rangeDuration)
+	assert.True(t, conf.Graphs["sqs_messages"].discovery())
+	assert.True(t, conf.Graphs["apigateway_latency"].multiple())
+	assert.False(t, conf.Graphs["apigateway_latency"].discovery())
+	assert.False(t, conf.Graphs["nat_gateway"].multiple())
+
+	_, err = parseConfig([]byte(`{"graphs": {"sqs": {"namespace": "AWS/SQS", "metrics": [{"name": "visible"}]}}}`))
+	assert.NotNil(t, err, "metric_name and statistic are required")
+	_, err = parseConfig([]byte(`{"graphs": {"sqs": {"namespace": "AWS/SQS", "dimensions": [{"name": "QueueName"}], "metrics": [{"name": "visible", "metric_name": "NumberOfMessagesSent", "statistic": "Sum"}]}}}`))
+	assert.NotNil(t, err, "the value of the dimension is required")
+	_, err = parseConfig([]byte(`{"graphs": {"sqs messages": {"namespace": "AWS/SQS", "metrics": [{"name": "sent", "metric_name": "NumberOfMessagesSent", "statistic": "Sum"}]}}}`))
+	assert.NotNil(t, err, "the graph name cannot contain spaces")
+	_, err = parseConfig([]byte(`{"graphs": {"sqs.#": {"namespace": "AWS/SQS", "metrics": [{"name": "sent", "metric_name": "NumberOfMessagesSent", "statistic": "Sum"}]}}}`))
+	assert.NotNil(t, err, "the graph name cannot contain #")
+	_, err = parseConfig([]byte(`{"graphs": {"sqs": {"namespace": "AWS/SQS", "metrics": [{"name": "messages.sent", "metric_name": "NumberOfMessagesSent", "statistic": "Sum"}]}}}`))
+	assert.NotNil(t, err, "the metric name cannot contain .")
+}
+
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+	queries []*cloudwatch.MetricDataQuery
+}
+
+func dimension(name, value string) *cloudwatch.Dimension {
+	return &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)}
+}
+
+func (m *mockCloudWatchClient) ListMetricsPages(input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool) error {
+	fn(&cloudwatch.ListMetricsOutput{
+		Metrics: []*cloudwatch.Metric{
+			{Dimensions: []*cloudwatch.Dimension{dimension("QueueName", "jobs.fifo")}},
+			{Dimensions: []*cloudwatch.Dimension{dimension("QueueName", "mails")}},
+			{Dimensions: []*cloudwatch.Dimension{dimension("QueueName", "mails"), dimension("Other", "x")}},
+		},
+	}, true)
+	return nil
+}
+
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	m.queries = append(m.queries, input.MetricDataQueries...)
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, q := range input.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         q.Id,
+			Timestamps: []*time.Time{input.EndTime},
+			Values:     []*float64{aws.Float64(float64(len(q.MetricStat.Metric.Dimensions)))},
+		})
+	}
+	return output, nil
+}
+
+func TestFetchMetrics(t *testing.T) {
+	conf, err := parseConfig([]byte(testConfig))
+	assert.Nil(t, err)
+	cw := &mockCloudWatchClient{}
+	p := CloudWatchPlugin{Prefix: "cloudwatch", CloudWatch: cw, conf: conf}
+	assert.Nil(t, p.resolveTargets())
+	assert.Equal(t, []target{
+		{Name: "jobs_fifo", Dimensions: []*cloudwatch.Dimension{dimension("QueueName", "jobs.fifo")}},
+		{Name: "mails", Dimensions: []*cloudwatch.Dimension{dimension("QueueName", "mails")}},
+	}, p.targets["sqs_messages"])
+	assert.Equal(t, []target{
+		{Name: "prod", Dimensions: []*cloudwatch.Dimension{dimension("ApiName", "my-api"), dimension("Stage", "prod")}},
+		{Name: "staging", Dimensions: []*cloudwatch.Dimension{dimension("ApiName", "my-api"), dimension("Stage", "staging")}},
+	}, p.targets["apigateway_latency"])
+
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		"sqs_messages.jobs_fifo.visible":     1,
+		"sqs_messages.jobs_fifo.not_visible": 1,
+		"sqs_messages.mails.visible":         1,
+		"sqs_messages.mails.not_visible":     1,
+		"apigateway_latency.prod.p99":        2,
+		"apigateway_latency.staging.p99":     2,
+		"nat_gateway.error_port_allocation":  1,
+	}, stat)
+	for _, q := range cw.queries {
+		if aws.StringValue(q.MetricStat.Metric.Namespace) == "AWS/SQS" {
+			assert.Equal(t, int64(300), aws.Int64Value(q.MetricStat.Period))
+		}
+	}
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "sqs_messages.#")
+	assert.Contains(t, graphdef, "apigateway_latency.#")
+	assert.Equal(t, "nat_gateway", graphdef["nat_gateway"].Label)
+	assert.True(t, graphdef["nat_gateway"].Metrics[0].AbsoluteName)
+}
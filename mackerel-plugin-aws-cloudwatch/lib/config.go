@@ -0,0 +1,125 @@
+package mpawscloudwatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"time"
+)
+
+// wildcardValue in the dimension value discovers the values with ListMetrics
+const wildcardValue = "*"
+
+type config struct {
+	Graphs map[string]*graphConfig `json:"graphs"`
+}
+
+type graphConfig struct {
+	Label      string            `json:"label"`
+	Unit       string            `json:"unit"`
+	Namespace  string            `json:"namespace"`
+	Dimensions []dimensionConfig `json:"dimensions"`
+	// Period of the statistics in seconds, 60 by default
+	Period int64 `json:"period"`
+	// Range to look back for the latest datapoint like "10m", 3 minutes by default
+	Range   string         `json:"range"`
+	Metrics []metricConfig `json:"metrics"`
+
+	rangeDuration time.Duration
+}
+
+type dimensionConfig struct {
+	Name string `json:"name"`
+	// Value is the value of the dimension, or "*" to discover the values
+	Value string `json:"value"`
+	// Values is the list of the values to post the metrics per value
+	Values []string `json:"values"`
+}
+
+type metricConfig struct {
+	Name       string  `json:"name"`
+	Label      string  `json:"label"`
+	MetricName string  `json:"metric_name"`
+	Statistic  string  `json:"statistic"`
+	Stacked    bool    `json:"stacked"`
+	Scale      float64 `json:"scale"`
+}
+
+// multiple returns true if the graph has the metrics per combination of the dimension values
+func (g *graphConfig) multiple() bool {
+	for _, d := range g.Dimensions {
+		if d.Value == wildcardValue || len(d.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *graphConfig) discovery() bool {
+	for _, d := range g.Dimensions {
+		if d.Value == wildcardValue {
+			return true
+		}
+	}
+	return false
+}
+
+// the names in the metric keys, where a metric name cannot contain "." which separates it from the graph name
+var (
+	validGraphNameRe  = regexp.MustCompile(`^[-a-zA-Z0-9_.]+$`)
+	validMetricNameRe = regexp.MustCompile(`^[-a-zA-Z0-9_]+$`)
+)
+
+func loadConfig(fileName string) (*config, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(b)
+}
+
+func parseConfig(b []byte) (*config, error) {
+	var conf config
+	if err := json.Unmarshal(b, &conf); err != nil {
+		return nil, err
+	}
+	if len(conf.Graphs) == 0 {
+		return nil, fmt.Errorf("no graphs are configured")
+	}
+	for name, g := range conf.Graphs {
+		if !validGraphNameRe.MatchString(name) {
+			return nil, fmt.Errorf("graph %s: the name can contain only alphanumerics, -, _ and .", name)
+		}
+		if g.Namespace == "" {
+			return nil, fmt.Errorf("graph %s: namespace is required", name)
+		}
+		if len(g.Metrics) == 0 {
+			return nil, fmt.Errorf("graph %s: no metrics are configured", name)
+		}
+		for _, d := range g.Dimensions {
+			if d.Name == "" {
+				return nil, fmt.Errorf("graph %s: the name of the dimension is required", name)
+			}
+			if (d.Value == "") == (len(d.Values) == 0) {
+				return nil, fmt.Errorf("graph %s: either value or values of dimension %s is required", name, d.Name)
+			}
+		}
+		for _, m := range g.Metrics {
+			if m.Name == "" || m.MetricName == "" || m.Statistic == "" {
+				return nil, fmt.Errorf("graph %s: name, metric_name and statistic of the metrics are required", name)
+			}
+			if !validMetricNameRe.MatchString(m.Name) {
+				return nil, fmt.Errorf("graph %s: the name of metric %s can contain only alphanumerics, - and _", name, m.Name)
+			}
+		}
+		if g.Range != "" {
+			d, err := time.ParseDuration(g.Range)
+			if err != nil {
+				return nil, fmt.Errorf("graph %s: %s", name, err)
+			}
+			g.rangeDuration = d
+		}
+	}
+	return &conf, nil
+}
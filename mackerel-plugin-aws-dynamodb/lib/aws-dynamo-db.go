@@ -7,11 +7,10 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 const (
@@ -39,10 +38,8 @@ type DynamoDBPlugin struct {
 	TableName string
 	Prefix    string
 
-	AccessKeyID     string
-	SecretAccessKey string
-	Region          string
-	CloudWatch      *cloudwatch.CloudWatch
+	awsplugin.SessionOptions
+	CloudWatch *cloudwatch.CloudWatch
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -52,44 +49,34 @@ func (p DynamoDBPlugin) MetricKeyPrefix() string {
 
 // prepare creates CloudWatch instance
 func (p *DynamoDBPlugin) prepare() error {
-	sess, err := session.NewSession()
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
-
-	p.CloudWatch = cloudwatch.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess)
 
 	return nil
 }
 
-func transformAndAppendDatapoint(dp *cloudwatch.Datapoint, dataType string, label string, stats map[string]interface{}) map[string]interface{} {
-	if dp != nil {
-		switch dataType {
-		case metricsTypeAverage:
-			stats[label] = *dp.Average
-		case metricsTypeSum:
-			stats[label] = *dp.Sum
-		case metricsTypeMaximum:
-			stats[label] = *dp.Maximum
-		case metricsTypeMinimum:
-			stats[label] = *dp.Minimum
-		case metricsTypeSampleCount:
-			stats[label] = *dp.SampleCount
+// newQueries returns the queries of the statistics in a metricsGroup
+func newQueries(mg metricsGroup, dimensions []*cloudwatch.Dimension, replace string) []awsplugin.MetricQuery {
+	queries := make([]awsplugin.MetricQuery, len(mg.Metrics))
+	for i, met := range mg.Metrics {
+		queries[i] = awsplugin.MetricQuery{
+			Key:        strings.Replace(met.MackerelName, "#", replace, 1),
+			Namespace:  namespace,
+			MetricName: mg.CloudWatchName,
+			Dimensions: dimensions,
+			Statistic:  met.Type,
+			Range:      8 * time.Minute, // since some metrics are aggregated over 5 min
 		}
 	}
-	return stats
+	return queries
 }
 
-// fetch metrics which takes "Operation" dimensions querying both ListMetrics and GetMetricsStatistics
-func fetchOperationWildcardMetrics(cw cloudwatchiface.CloudWatchAPI, mg metricsGroup, baseDimensions []*cloudwatch.Dimension) (map[string]interface{}, error) {
+// operationWildcardQueries returns queries of metrics which takes "Operation" dimensions with ListMetrics
+func operationWildcardQueries(cw cloudwatchiface.CloudWatchAPI, mg metricsGroup, baseDimensions []*cloudwatch.Dimension) ([]awsplugin.MetricQuery, error) {
 	// get available dimensions
 	dimensionFilters := make([]*cloudwatch.DimensionFilter, len(baseDimensions))
 	for i, dimension := range baseDimensions {
@@ -109,9 +96,7 @@ func fetchOperationWildcardMetrics(cw cloudwatchiface.CloudWatchAPI, mg metricsG
 		return nil, err
 	}
 
-	stats := make(map[string]interface{})
-
-	// get datapoints with retrieved dimensions
+	var queries []awsplugin.MetricQuery
 	for _, cwMetric := range res.Metrics {
 		dimensions := cwMetric.Dimensions
 		// extract operation name
@@ -123,64 +108,12 @@ func fetchOperationWildcardMetrics(cw cloudwatchiface.CloudWatchAPI, mg metricsG
 			}
 		}
 		if operation == nil {
-			log.Printf("Unexpected dimension, skip: %s", dimensions)
-			continue
-		}
-
-		dp, err := getLastPointFromCloudWatch(cw, mg, dimensions)
-		if err != nil {
-			return nil, nil
-		}
-		if dp != nil {
-			for _, met := range mg.Metrics {
-				label := strings.Replace(met.MackerelName, "#", *operation, 1)
-				stats = transformAndAppendDatapoint(dp, met.Type, label, stats)
-			}
-		}
-	}
-
-	return stats, nil
-}
-
-// getLastPoint fetches a CloudWatch metric and parse
-func getLastPointFromCloudWatch(cw cloudwatchiface.CloudWatchAPI, metric metricsGroup, dimensions []*cloudwatch.Dimension) (*cloudwatch.Datapoint, error) {
-	now := time.Now()
-	statsInput := make([]*string, len(metric.Metrics))
-	for i, typ := range metric.Metrics {
-		statsInput[i] = aws.String(typ.Type)
-	}
-	input := &cloudwatch.GetMetricStatisticsInput{
-		// 8 min, since some metrics are aggregated over 5 min
-		StartTime:  aws.Time(now.Add(time.Duration(480) * time.Second * -1)),
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metric.CloudWatchName),
-		Period:     aws.Int64(60),
-		Statistics: statsInput,
-		Namespace:  aws.String(namespace),
-		Dimensions: dimensions,
-	}
-	response, err := cw.GetMetricStatistics(input)
-	if err != nil {
-		return nil, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return nil, nil
-	}
-
-	latest := new(time.Time)
-	var latestDp *cloudwatch.Datapoint
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
+			log.Printf("Unexpected dimension, skip: %v", dimensions)
 			continue
 		}
-
-		latest = dp.Timestamp
-		latestDp = dp
+		queries = append(queries, newQueries(mg, dimensions, *operation)...)
 	}
-
-	return latestDp, nil
+	return queries, nil
 }
 
 var defaultMetricsGroup = []metricsGroup{
@@ -235,33 +168,32 @@ var operationalMetricsGroup = []metricsGroup{
 
 // FetchMetrics fetch the metrics
 func (p DynamoDBPlugin) FetchMetrics() (map[string]interface{}, error) {
-	stats := make(map[string]interface{})
-
 	tableDimensions := []*cloudwatch.Dimension{{
 		Name:  aws.String("TableName"),
 		Value: aws.String(p.TableName),
 	}}
+
+	var queries []awsplugin.MetricQuery
 	for _, met := range defaultMetricsGroup {
-		dp, err := getLastPointFromCloudWatch(p.CloudWatch, met, tableDimensions)
-		if err == nil {
-			for _, m := range met.Metrics {
-				stats = transformAndAppendDatapoint(dp, m.Type, m.MackerelName, stats)
-			}
-		} else {
-			log.Printf("%s: %s", met, err)
-		}
+		queries = append(queries, newQueries(met, tableDimensions, "")...)
 	}
-
 	for _, met := range operationalMetricsGroup {
-		operationalStats, err := fetchOperationWildcardMetrics(p.CloudWatch, met, tableDimensions)
+		q, err := operationWildcardQueries(p.CloudWatch, met, tableDimensions)
 		if err == nil {
-			for name, s := range operationalStats {
-				stats[name] = s
-			}
+			queries = append(queries, q...)
 		} else {
-			log.Printf("%s: %s", met, err)
+			log.Printf("%s: %s", met.CloudWatchName, err)
 		}
 	}
+
+	values, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		stats[k] = v
+	}
 	return transformMetrics(stats), nil
 }
 
@@ -367,19 +299,16 @@ func (p DynamoDBPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optTableName := flag.String("table-name", "", "DynamoDB Table Name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optPrefix := flag.String("metric-key-prefix", "dynamodb", "Metric key prefix")
 	flag.Parse()
 
 	var plugin DynamoDBPlugin
-
-	plugin.AccessKeyID = *optAccessKeyID
-	plugin.SecretAccessKey = *optSecretAccessKey
-	plugin.Region = *optRegion
+	plugin.SessionOptions = sessionOpts
 	plugin.TableName = *optTableName
 	plugin.Prefix = *optPrefix
 
@@ -6,11 +6,11 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -26,85 +26,46 @@ var graphdef = map[string]mp.Graphs{
 
 // CPUCreditPlugin is a mackerel plugin
 type CPUCreditPlugin struct {
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	InstanceID      string
-}
-
-func getLastPointAverage(cw *cloudwatch.CloudWatch, dimension *cloudwatch.Dimension, metricName string) (float64, error) {
-	namespace := "AWS/EC2"
-	now := time.Now()
-	prev := now.Add(time.Duration(600) * time.Second * -1) // 10 min (to fetch at least 1 data-point)
-
-	input := &cloudwatch.GetMetricStatisticsInput{
-		Dimensions: []*cloudwatch.Dimension{dimension},
-		EndTime:    aws.Time(now),
-		StartTime:  aws.Time(prev),
-		MetricName: aws.String(metricName),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String("Average")},
-		Namespace:  aws.String(namespace),
-	}
-
-	response, err := cw.GetMetricStatistics(input)
-	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
-	}
-
-	latest := new(time.Time)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
-		}
-
-		latest = dp.Timestamp
-		latestVal = *dp.Average
-	}
-
-	return latestVal, nil
+	awsplugin.SessionOptions
+	InstanceID string
 }
 
 // FetchMetrics fetch the metrics
 func (p CPUCreditPlugin) FetchMetrics() (map[string]float64, error) {
-	sess, err := session.NewSession()
+	sess, err := p.NewSession()
 	if err != nil {
 		return nil, err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
-
-	cw := cloudwatch.New(sess, config)
+	cw := cloudwatch.New(sess)
 
-	dimension := &cloudwatch.Dimension{
+	dimensions := []*cloudwatch.Dimension{{
 		Name:  aws.String("InstanceId"),
 		Value: aws.String(p.InstanceID),
+	}}
+
+	var queries []awsplugin.MetricQuery
+	for key, metricName := range map[string]string{
+		"usage":   "CPUCreditUsage",
+		"balance": "CPUCreditBalance",
+	} {
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        key,
+			Namespace:  "AWS/EC2",
+			MetricName: metricName,
+			Dimensions: dimensions,
+			Statistic:  "Average",
+			Range:      10 * time.Minute, // to fetch at least 1 data-point
+		})
 	}
 
-	stat := make(map[string]float64)
-
-	stat["usage"], err = getLastPointAverage(cw, dimension, "CPUCreditUsage")
+	stat, err := awsplugin.NewCloudWatchFetcher(cw).Fetch(queries)
 	if err != nil {
 		return nil, err
 	}
-
-	stat["balance"], err = getLastPointAverage(cw, dimension, "CPUCreditBalance")
-	if err != nil {
-		return nil, err
+	if len(stat) != len(queries) {
+		return nil, errors.New("fetched no datapoints")
 	}
-
 	return stat, nil
 }
 
@@ -115,29 +76,21 @@ func (p CPUCreditPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
 	optInstanceID := flag.String("instance-id", "", "Instance ID")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var cpucredit CPUCreditPlugin
+	cpucredit.SessionOptions = sessionOpts
 
-	if *optRegion == "" || *optInstanceID == "" {
-		ec2metadata := ec2metadata.New(session.New())
-		if ec2metadata.Available() {
-			cpucredit.Region, _ = ec2metadata.Region()
-			cpucredit.InstanceID, _ = ec2metadata.GetMetadata("instance-id")
-		}
-	} else {
-		cpucredit.Region = *optRegion
-		cpucredit.InstanceID = *optInstanceID
+	cpucredit.InstanceID = *optInstanceID
+	if *optInstanceID == "" {
+		cpucredit.InstanceID, _ = ec2metadata.New(session.New()).GetMetadata("instance-id")
 	}
 
-	cpucredit.AccessKeyID = *optAccessKeyID
-	cpucredit.SecretAccessKey = *optSecretAccessKey
-
 	helper := mp.NewMackerelPlugin(cpucredit)
 	helper.Tempfile = *optTempfile
 
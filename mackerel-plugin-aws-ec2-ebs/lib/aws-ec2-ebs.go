@@ -9,12 +9,12 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var metricPeriodDefault = 300
@@ -161,22 +161,26 @@ var stderrLogger *log.Logger
 
 // EBSPlugin mackerel plugin for ebs
 type EBSPlugin struct {
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	InstanceID      string
-	Credentials     *credentials.Credentials
-	EC2             *ec2.EC2
-	CloudWatch      *cloudwatch.CloudWatch
-	Volumes         []*ec2.Volume
+	awsplugin.SessionOptions
+	InstanceID string
+	EC2        *ec2.EC2
+	CloudWatch *cloudwatch.CloudWatch
+	Volumes    []*ec2.Volume
+
+	// Local reads the statistics of the NVMe devices instead of CloudWatch
+	Local         bool
+	SysfsDir      string
+	NVMeStateFile string
 }
 
 func (p *EBSPlugin) prepare() error {
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		p.Credentials = credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, "")
+	sess, err := p.NewSession()
+	if err != nil {
+		return err
 	}
+	p.EC2 = ec2.New(sess)
+	p.CloudWatch = cloudwatch.New(sess)
 
-	p.EC2 = ec2.New(session.New(&aws.Config{Credentials: p.Credentials, Region: &p.Region}))
 	resp, err := p.EC2.DescribeVolumes(&ec2.DescribeVolumesInput{
 		Filters: []*ec2.Filter{
 			{
@@ -202,90 +206,72 @@ func (p *EBSPlugin) prepare() error {
 	return nil
 }
 
-func (p EBSPlugin) getLastPoint(vol *ec2.Volume, metricName string, statType string) (float64, int, error) {
-	now := time.Now()
-
-	period := metricPeriodDefault
-	if tmp, ok := metricPeriodByVolumeType[*vol.VolumeType]; ok {
-		period = tmp
-	}
-	start := now.Add(time.Duration(period) * 3 * time.Second * -1)
-
-	resp, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: []*cloudwatch.Dimension{
-			{
-				Name:  aws.String("VolumeId"),
-				Value: vol.VolumeId,
-			},
-		},
-		StartTime:  &start,
-		EndTime:    &now,
-		MetricName: &metricName,
-		Period:     aws.Int64(60),
-		Statistics: []*string{&statType},
-		Namespace:  aws.String("AWS/EBS"),
-	})
-	if err != nil {
-		return 0, 0, err
-	}
-
-	datapoints := resp.Datapoints
-	if len(datapoints) == 0 {
-		return 0, 0, errors.New("fetched no datapoints")
-	}
-
-	latest := time.Unix(0, 0)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(latest) {
-			continue
-		}
-
-		latest = *dp.Timestamp
-		switch statType {
-		case "Average":
-			latestVal = *dp.Average
-		case "Sum":
-			latestVal = *dp.Sum
-		}
-	}
-
-	return latestVal, period, nil
-}
-
 // FetchMetrics fetch the metrics
 func (p EBSPlugin) FetchMetrics() (map[string]interface{}, error) {
-	stat := make(map[string]interface{})
-	p.CloudWatch = cloudwatch.New(session.New(&aws.Config{Credentials: p.Credentials, Region: &p.Region}))
+	if p.Local {
+		return p.fetchNVMeMetrics()
+	}
+
+	var queries []awsplugin.MetricQuery
+	// to calculate the value of each query
+	metricKeys := make(map[string]string)
+	periods := make(map[string]int)
 	for _, vol := range p.Volumes {
 		volumeID := normalizeVolumeID(*vol.VolumeId)
 		graphs := defaultGraphs
 		if *vol.VolumeType == "io1" {
 			graphs = allGraphs
 		}
+		period := metricPeriodDefault
+		if tmp, ok := metricPeriodByVolumeType[*vol.VolumeType]; ok {
+			period = tmp
+		}
 		for _, graphName := range graphs {
 			for _, metric := range graphdef[graphName].Metrics {
 				metricKey := graphName + "." + metric.Name
 				cloudwatchdef := cloudwatchdefs[metricKey]
-				val, period, err := p.getLastPoint(vol, cloudwatchdef.MetricName, cloudwatchdef.Statistics)
-				if err != nil {
-					retErr := errors.New(volumeID + " " + err.Error() + ":" + cloudwatchdef.MetricName)
-					if err.Error() == "fetched no datapoints" {
-						getStderrLogger().Println(retErr)
-					} else {
-						return nil, retErr
-					}
-				} else {
-					stat[strings.Replace(metricKey, "#", volumeID, -1)] = cloudwatchdef.CalcFunc(val, float64(period))
-				}
+				key := strings.Replace(metricKey, "#", volumeID, -1)
+				queries = append(queries, awsplugin.MetricQuery{
+					Key:        key,
+					Namespace:  "AWS/EBS",
+					MetricName: cloudwatchdef.MetricName,
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("VolumeId"),
+							Value: vol.VolumeId,
+						},
+					},
+					Statistic: cloudwatchdef.Statistics,
+					Range:     time.Duration(period) * 3 * time.Second,
+				})
+				metricKeys[key] = metricKey
+				periods[key] = period
 			}
 		}
 	}
+
+	values, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]interface{})
+	for _, q := range queries {
+		val, ok := values[q.Key]
+		if !ok {
+			getStderrLogger().Println(q.Key + " fetched no datapoints:" + q.MetricName)
+			continue
+		}
+		stat[q.Key] = cloudwatchdefs[metricKeys[q.Key]].CalcFunc(val, float64(periods[q.Key]))
+	}
 	return stat, nil
 }
 
 // GraphDefinition for plugin
 func (p EBSPlugin) GraphDefinition() map[string]mp.Graphs {
+	if p.Local {
+		return localGraphdef
+	}
 	return graphdef
 }
 
@@ -302,30 +288,34 @@ func normalizeVolumeID(volumeID string) string {
 
 // Do the plugin
 func Do() {
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
 	optInstanceID := flag.String("instance-id", "", "Instance ID")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optLocal := flag.Bool("local", false, "Read the statistics of EBS volumes from the NVMe devices of the Nitro instance")
 	flag.Parse()
 
 	var ebs EBSPlugin
 
-	ebs.Region = *optRegion
+	if *optLocal {
+		ebs.Local = true
+		ebs.SysfsDir = "/sys/class/nvme"
+		ebs.NVMeStateFile = generateNVMeStateFilePath(*optTempfile)
+		helper := mp.NewMackerelPlugin(ebs)
+		helper.Tempfile = *optTempfile
+		helper.Run()
+		return
+	}
+
+	ebs.SessionOptions = sessionOpts
 	ebs.InstanceID = *optInstanceID
 
 	// get metadata in ec2 instance
-	ec2MC := ec2metadata.New(session.New())
-	if *optRegion == "" {
-		ebs.Region, _ = ec2MC.Region()
-	}
 	if *optInstanceID == "" {
-		ebs.InstanceID, _ = ec2MC.GetMetadata("instance-id")
+		ebs.InstanceID, _ = ec2metadata.New(session.New()).GetMetadata("instance-id")
 	}
 
-	ebs.AccessKeyID = *optAccessKeyID
-	ebs.SecretAccessKey = *optSecretAccessKey
-
 	if err := ebs.prepare(); err != nil {
 		log.Fatalln(err)
 	}
@@ -0,0 +1,271 @@
+package mpawsec2ebs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+)
+
+// The detailed performance statistics of EBS volumes on Nitro instances are provided by
+// the vendor specific log page of the NVMe controller.
+// https://docs.aws.amazon.com/ebs/latest/userguide/nvme-detailed-performance-stats.html
+const (
+	ebsModelName     = "Amazon Elastic Block Store"
+	ebsStatsLogID    = 0xD0
+	ebsStatsLogSize  = 4096
+	ebsStatsLogMagic = 0x3C23B510
+)
+
+// ebsStats is the cumulative statistics of a volume. The times are in microseconds.
+type ebsStats struct {
+	ReadOps                  uint64 `json:"read_ops"`
+	WriteOps                 uint64 `json:"write_ops"`
+	ReadBytes                uint64 `json:"read_bytes"`
+	WriteBytes               uint64 `json:"write_bytes"`
+	ReadTime                 uint64 `json:"read_time"`
+	WriteTime                uint64 `json:"write_time"`
+	VolumeExceededIOPSTime   uint64 `json:"volume_exceeded_iops_time"`
+	VolumeExceededTPTime     uint64 `json:"volume_exceeded_tp_time"`
+	InstanceExceededIOPSTime uint64 `json:"instance_exceeded_iops_time"`
+	InstanceExceededTPTime   uint64 `json:"instance_exceeded_tp_time"`
+	QueueLength              uint64 `json:"queue_length"`
+}
+
+func parseStatsLogPage(b []byte) (*ebsStats, error) {
+	if len(b) < 96 {
+		return nil, errors.New("the log page is too short")
+	}
+	if binary.LittleEndian.Uint32(b[0:4]) != ebsStatsLogMagic {
+		return nil, errors.New("the log page is not the EBS statistics")
+	}
+	u64 := func(offset int) uint64 {
+		return binary.LittleEndian.Uint64(b[offset : offset+8])
+	}
+	return &ebsStats{
+		ReadOps:                  u64(8),
+		WriteOps:                 u64(16),
+		ReadBytes:                u64(24),
+		WriteBytes:               u64(32),
+		ReadTime:                 u64(40),
+		WriteTime:                u64(48),
+		VolumeExceededIOPSTime:   u64(56),
+		VolumeExceededTPTime:     u64(64),
+		InstanceExceededIOPSTime: u64(72),
+		InstanceExceededTPTime:   u64(80),
+		QueueLength:              u64(88),
+	}, nil
+}
+
+// ebsDevices returns the NVMe controller devices of EBS volumes keyed by the volume IDs
+func ebsDevices(sysfsDir string) (map[string]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsDir, "nvme*"))
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[string]string)
+	for _, dir := range dirs {
+		model, err := ioutil.ReadFile(filepath.Join(dir, "model"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(model)) != ebsModelName {
+			continue
+		}
+		serial, err := ioutil.ReadFile(filepath.Join(dir, "serial"))
+		if err != nil {
+			continue
+		}
+		devices[volumeIDOfSerial(strings.TrimSpace(string(serial)))] = "/dev/" + filepath.Base(dir)
+	}
+	return devices, nil
+}
+
+// volumeIDOfSerial converts the serial number like "vol0123456789abcdef0" to the volume ID
+func volumeIDOfSerial(serial string) string {
+	if strings.HasPrefix(serial, "vol") && !strings.HasPrefix(serial, "vol-") {
+		return "vol-" + serial[3:]
+	}
+	return serial
+}
+
+type nvmeSaveItem struct {
+	LastTime time.Time
+	Volumes  map[string]*ebsStats
+}
+
+func saveNVMeStats(fileName string, volumes map[string]*ebsStats, now time.Time) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(nvmeSaveItem{LastTime: now, Volumes: volumes})
+}
+
+func fetchSavedNVMeStats(fileName string) (*nvmeSaveItem, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var item nvmeSaveItem
+	if err := json.NewDecoder(f).Decode(&item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// counterReset returns true if any of the counters goes backwards, where the queue length is not a counter
+func counterReset(cur, prev *ebsStats) bool {
+	counters := [][2]uint64{
+		{cur.ReadOps, prev.ReadOps},
+		{cur.WriteOps, prev.WriteOps},
+		{cur.ReadBytes, prev.ReadBytes},
+		{cur.WriteBytes, prev.WriteBytes},
+		{cur.ReadTime, prev.ReadTime},
+		{cur.WriteTime, prev.WriteTime},
+		{cur.VolumeExceededIOPSTime, prev.VolumeExceededIOPSTime},
+		{cur.VolumeExceededTPTime, prev.VolumeExceededTPTime},
+		{cur.InstanceExceededIOPSTime, prev.InstanceExceededIOPSTime},
+		{cur.InstanceExceededTPTime, prev.InstanceExceededTPTime},
+	}
+	for _, c := range counters {
+		if c[0] < c[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// calcNVMeMetrics calculates the metrics of the graphs from the statistics of two points
+func calcNVMeMetrics(current, last map[string]*ebsStats, elapsed time.Duration) map[string]interface{} {
+	stat := make(map[string]interface{})
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return stat
+	}
+	for volumeID, cur := range current {
+		prev, ok := last[volumeID]
+		// the counters are reset when the volume is reattached
+		if !ok || counterReset(cur, prev) {
+			continue
+		}
+		key := func(graphName, metricName string) string {
+			return strings.Replace(graphName, "#", normalizeVolumeID(volumeID), -1) + "." + metricName
+		}
+		readOps := float64(cur.ReadOps - prev.ReadOps)
+		writeOps := float64(cur.WriteOps - prev.WriteOps)
+		readBytes := float64(cur.ReadBytes - prev.ReadBytes)
+		writeBytes := float64(cur.WriteBytes - prev.WriteBytes)
+
+		stat[key("ec2.ebs.bandwidth.#", "read")] = readBytes / seconds
+		stat[key("ec2.ebs.bandwidth.#", "write")] = writeBytes / seconds
+		stat[key("ec2.ebs.throughput.#", "read")] = readOps / seconds
+		stat[key("ec2.ebs.throughput.#", "write")] = writeOps / seconds
+		if readOps > 0 {
+			stat[key("ec2.ebs.size_per_op.#", "read")] = readBytes / readOps
+			stat[key("ec2.ebs.latency.#", "read")] = float64(cur.ReadTime-prev.ReadTime) / readOps / 1000
+		}
+		if writeOps > 0 {
+			stat[key("ec2.ebs.size_per_op.#", "write")] = writeBytes / writeOps
+			stat[key("ec2.ebs.latency.#", "write")] = float64(cur.WriteTime-prev.WriteTime) / writeOps / 1000
+		}
+		stat[key("ec2.ebs.queue_length.#", "queue_length")] = float64(cur.QueueLength)
+
+		percentage := func(cur, prev uint64) float64 {
+			return float64(cur-prev) / 1e6 / seconds * 100
+		}
+		stat[key("ec2.ebs.performance_exceeded.#", "volume_iops")] = percentage(cur.VolumeExceededIOPSTime, prev.VolumeExceededIOPSTime)
+		stat[key("ec2.ebs.performance_exceeded.#", "volume_throughput")] = percentage(cur.VolumeExceededTPTime, prev.VolumeExceededTPTime)
+		stat[key("ec2.ebs.performance_exceeded.#", "instance_iops")] = percentage(cur.InstanceExceededIOPSTime, prev.InstanceExceededIOPSTime)
+		stat[key("ec2.ebs.performance_exceeded.#", "instance_throughput")] = percentage(cur.InstanceExceededTPTime, prev.InstanceExceededTPTime)
+	}
+	return stat
+}
+
+// localGraphdef is the graphs of -local. The idle time and the metrics of io1 volumes are provided only by CloudWatch.
+var localGraphdef = map[string]mp.Graphs{
+	"ec2.ebs.performance_exceeded.#": {
+		Label: "EBS Time Spent Exceeding Performance",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "volume_iops", Label: "Volume IOPS", Diff: false},
+			{Name: "volume_throughput", Label: "Volume Throughput", Diff: false},
+			{Name: "instance_iops", Label: "Instance IOPS", Diff: false},
+			{Name: "instance_throughput", Label: "Instance Throughput", Diff: false},
+		},
+	},
+}
+
+func init() {
+	for _, graphName := range defaultGraphs {
+		if graphName == "ec2.ebs.idle_time.#" {
+			continue
+		}
+		localGraphdef[graphName] = graphdef[graphName]
+	}
+}
+
+// fetchNVMeMetrics reads the statistics of the volumes attached to the instance
+func (p EBSPlugin) fetchNVMeMetrics() (map[string]interface{}, error) {
+	devices, err := ebsDevices(p.SysfsDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no EBS volumes are found as NVMe devices")
+	}
+
+	current := make(map[string]*ebsStats, len(devices))
+	for volumeID, device := range devices {
+		b, err := readStatsLogPage(device)
+		if err != nil {
+			getStderrLogger().Println(device + ": " + err.Error())
+			continue
+		}
+		s, err := parseStatsLogPage(b)
+		if err != nil {
+			getStderrLogger().Println(device + ": " + err.Error())
+			continue
+		}
+		current[volumeID] = s
+	}
+
+	now := time.Now()
+	saved, err := fetchSavedNVMeStats(p.NVMeStateFile)
+	if err := saveNVMeStats(p.NVMeStateFile, current, now); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	// maybe first time run
+	if saved == nil {
+		return map[string]interface{}{}, nil
+	}
+	return calcNVMeMetrics(current, saved.Volumes, now.Sub(saved.LastTime)), nil
+}
+
+// generateNVMeStateFilePath returns the file of the previous statistics next to the tempfile of the plugin
+func generateNVMeStateFilePath(tempfile string) string {
+	if tempfile != "" {
+		return tempfile + "-nvme"
+	}
+	dir := os.Getenv("MACKEREL_PLUGIN_WORKDIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "mackerel-plugin-aws-ec2-ebs-nvme")
+}
@@ -0,0 +1,62 @@
+// +build linux
+
+package mpawsec2ebs
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// nvmeAdminCmd is struct nvme_admin_cmd of linux/nvme_ioctl.h
+type nvmeAdminCmd struct {
+	opcode      uint8
+	flags       uint8
+	rsvd1       uint16
+	nsid        uint32
+	cdw2        uint32
+	cdw3        uint32
+	metadata    uint64
+	addr        uint64
+	metadataLen uint32
+	dataLen     uint32
+	cdw10       uint32
+	cdw11       uint32
+	cdw12       uint32
+	cdw13       uint32
+	cdw14       uint32
+	cdw15       uint32
+	timeoutMs   uint32
+	result      uint32
+}
+
+const (
+	nvmeIoctlAdminCmd   = 0xC0484E41 // _IOWR('N', 0x41, struct nvme_admin_cmd)
+	nvmeAdminGetLogPage = 0x02
+)
+
+// readStatsLogPage issues Get Log Page of the EBS statistics to the NVMe controller device
+func readStatsLogPage(device string) ([]byte, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, ebsStatsLogSize)
+	cmd := nvmeAdminCmd{
+		opcode:  nvmeAdminGetLogPage,
+		nsid:    1,
+		addr:    uint64(uintptr(unsafe.Pointer(&b[0]))),
+		dataLen: ebsStatsLogSize,
+		// the number of dwords minus 1 is in the upper 16 bits
+		cdw10: ebsStatsLogID | ((ebsStatsLogSize/4 - 1) << 16),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), nvmeIoctlAdminCmd, uintptr(unsafe.Pointer(&cmd)))
+	runtime.KeepAlive(b)
+	if errno != 0 {
+		return nil, errno
+	}
+	return b, nil
+}
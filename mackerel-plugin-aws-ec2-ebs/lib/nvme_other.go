@@ -0,0 +1,9 @@
+// +build !linux
+
+package mpawsec2ebs
+
+import "errors"
+
+func readStatsLogPage(device string) ([]byte, error) {
+	return nil, errors.New("the statistics of NVMe devices can be read only on Linux")
+}
@@ -0,0 +1,95 @@
+package mpawsec2ebs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatsLogPage(t *testing.T) {
+	b := make([]byte, ebsStatsLogSize)
+	binary.LittleEndian.PutUint32(b[0:], ebsStatsLogMagic)
+	for i, v := range []uint64{100, 200, 409600, 819200, 50000, 80000, 1, 2, 3, 4, 5} {
+		binary.LittleEndian.PutUint64(b[8+i*8:], v)
+	}
+	s, err := parseStatsLogPage(b)
+	assert.Nil(t, err)
+	assert.Equal(t, &ebsStats{
+		ReadOps:                  100,
+		WriteOps:                 200,
+		ReadBytes:                409600,
+		WriteBytes:               819200,
+		ReadTime:                 50000,
+		WriteTime:                80000,
+		VolumeExceededIOPSTime:   1,
+		VolumeExceededTPTime:     2,
+		InstanceExceededIOPSTime: 3,
+		InstanceExceededTPTime:   4,
+		QueueLength:              5,
+	}, s)
+
+	_, err = parseStatsLogPage(make([]byte, ebsStatsLogSize))
+	assert.NotNil(t, err, "the magic is checked")
+}
+
+func TestEBSDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-aws-ec2-ebs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, files := range map[string]map[string]string{
+		"nvme0": {"model": "Amazon Elastic Block Store              \n", "serial": "vol0123456789abcdef0\n"},
+		"nvme1": {"model": "Amazon EC2 NVMe Instance Storage        \n", "serial": "AWS1234567890ABCDEF\n"},
+	} {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+		for file, content := range files {
+			ioutil.WriteFile(filepath.Join(dir, name, file), []byte(content), 0644)
+		}
+	}
+	devices, err := ebsDevices(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"vol-0123456789abcdef0": "/dev/nvme0"}, devices)
+}
+
+func TestCalcNVMeMetrics(t *testing.T) {
+	last := map[string]*ebsStats{
+		"vol-1": {ReadOps: 1000, WriteOps: 2000, ReadBytes: 4096000, WriteBytes: 8192000, ReadTime: 500000, WriteTime: 1000000},
+		"vol-2": {ReadOps: 1000, WriteOps: 2000},
+		"vol-3": {ReadOps: 1000, WriteOps: 2000, ReadBytes: 4096000, VolumeExceededTPTime: 1000000},
+	}
+	current := map[string]*ebsStats{
+		"vol-1": {
+			ReadOps: 1600, WriteOps: 2000, ReadBytes: 6553600, WriteBytes: 8192000, ReadTime: 800000, WriteTime: 1000000,
+			VolumeExceededIOPSTime: 15000000, QueueLength: 2,
+		},
+		"vol-2": {ReadOps: 10, WriteOps: 20},
+		"vol-3": {ReadOps: 1600, WriteOps: 2000, ReadBytes: 4096000, VolumeExceededTPTime: 0},
+	}
+	stat := calcNVMeMetrics(current, last, time.Minute)
+	assert.Equal(t, 40960.0, stat["ec2.ebs.bandwidth.vol-1.read"])
+	assert.Equal(t, 10.0, stat["ec2.ebs.throughput.vol-1.read"])
+	assert.Equal(t, 0.0, stat["ec2.ebs.throughput.vol-1.write"])
+	assert.Equal(t, 4096.0, stat["ec2.ebs.size_per_op.vol-1.read"])
+	assert.Equal(t, 0.5, stat["ec2.ebs.latency.vol-1.read"])
+	assert.Equal(t, 2.0, stat["ec2.ebs.queue_length.vol-1.queue_length"])
+	assert.Equal(t, 25.0, stat["ec2.ebs.performance_exceeded.vol-1.volume_iops"])
+	_, ok := stat["ec2.ebs.latency.vol-1.write"]
+	assert.False(t, ok, "no latency without operations")
+	_, ok = stat["ec2.ebs.bandwidth.vol-2.read"]
+	assert.False(t, ok, "the counters are reset")
+	_, ok = stat["ec2.ebs.performance_exceeded.vol-3.volume_throughput"]
+	assert.False(t, ok, "any of the counters is reset")
+}
+
+func TestGenerateNVMeStateFilePath(t *testing.T) {
+	assert.Equal(t, "/var/tmp/ebs-a-nvme", generateNVMeStateFilePath("/var/tmp/ebs-a"))
+	assert.NotEqual(t, generateNVMeStateFilePath("/var/tmp/ebs-a"), generateNVMeStateFilePath("/var/tmp/ebs-b"))
+	assert.Equal(t, "mackerel-plugin-aws-ec2-ebs-nvme", filepath.Base(generateNVMeStateFilePath("")))
+}
@@ -1,27 +1,24 @@
 package mpawsec2
 
 import (
-	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 // EC2Plugin is a mackerel plugin for ec2
 type EC2Plugin struct {
-	InstanceID  string
-	Region      string
-	Credentials *credentials.Credentials
-	CloudWatch  *cloudwatch.CloudWatch
+	awsplugin.SessionOptions
+	InstanceID string
+	CloudWatch *cloudwatch.CloudWatch
 }
 
 var graphdef = map[string]mp.Graphs{
@@ -80,53 +77,20 @@ func (p EC2Plugin) GraphDefinition() map[string]mp.Graphs {
 	return graphdef
 }
 
-func getLastPoint(cloudWatch *cloudwatch.CloudWatch, dimension *cloudwatch.Dimension, metricName string) (float64, error) {
-	now := time.Now()
-	response, err := cloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		StartTime:  aws.Time(now.Add(time.Duration(600) * time.Second * -1)),
-		EndTime:    aws.Time(now),
-		Period:     aws.Int64(60),
-		Namespace:  aws.String("AWS/EC2"),
-		Dimensions: []*cloudwatch.Dimension{dimension},
-		MetricName: aws.String(metricName),
-		Statistics: []*string{aws.String("Average")},
-	})
+// FetchMetrics fetches metrics from CloudWatch
+func (p EC2Plugin) FetchMetrics() (map[string]float64, error) {
+	sess, err := p.NewSession()
 	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
+		return nil, err
 	}
+	p.CloudWatch = cloudwatch.New(sess)
 
-	latest := time.Unix(0, 0)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(latest) {
-			continue
-		}
-
-		latest = *dp.Timestamp
-		latestVal = *dp.Average
-	}
-
-	return latestVal, nil
-}
-
-// FetchMetrics fetches metrics from CloudWatch
-func (p EC2Plugin) FetchMetrics() (map[string]float64, error) {
-	stat := make(map[string]float64)
-	p.CloudWatch = cloudwatch.New(session.New(
-		&aws.Config{
-			Credentials: p.Credentials,
-			Region:      &p.Region,
-		}))
-	dimension := &cloudwatch.Dimension{
+	dimensions := []*cloudwatch.Dimension{{
 		Name:  aws.String("InstanceId"),
 		Value: aws.String(p.InstanceID),
-	}
+	}}
 
+	var queries []awsplugin.MetricQuery
 	for _, met := range [...]string{
 		"CPUUtilization",
 		"DiskReadBytes",
@@ -141,42 +105,35 @@ func (p EC2Plugin) FetchMetrics() (map[string]float64, error) {
 		"StatusCheckFailed_Instance",
 		"StatusCheckFailed_System",
 	} {
-		v, err := getLastPoint(p.CloudWatch, dimension, met)
-		if err == nil {
-			stat[met] = v
-		} else {
-			log.Printf("%s: %s", met, err)
-		}
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        met,
+			Namespace:  "AWS/EC2",
+			MetricName: met,
+			Dimensions: dimensions,
+			Statistic:  "Average",
+			// basic monitoring publishes datapoints every 5 minutes
+			Range: 10 * time.Minute,
+		})
 	}
-
-	return stat, nil
+	return awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
 }
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optInstanceID := flag.String("instance-id", "", "Instance ID")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var ec2 EC2Plugin
-
-	// use credentials from option
-	if *optAccessKeyID != "" && *optSecretAccessKey != "" {
-		ec2.Credentials = credentials.NewStaticCredentials(*optAccessKeyID, *optSecretAccessKey, "")
-	}
+	ec2.SessionOptions = sessionOpts
 
 	// get metadata in ec2 instance
-	ec2MC := ec2metadata.New(session.New())
-	ec2.Region = *optRegion
-	if *optRegion == "" {
-		ec2.Region, _ = ec2MC.Region()
-	}
 	ec2.InstanceID = *optInstanceID
 	if *optInstanceID == "" {
-		ec2.InstanceID, _ = ec2MC.GetMetadata("instance-id")
+		ec2.InstanceID, _ = ec2metadata.New(session.New()).GetMetadata("instance-id")
 	}
 
 	helper := mp.NewMackerelPlugin(ec2)
@@ -1,19 +1,17 @@
 package mpawselasticache
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var metricsdefMemcached = []string{
@@ -32,7 +30,9 @@ var metricsdefRedis = []string{
 	"CurrConnections", "Evictions", "Reclaimed", "NewConnections", "BytesUsedForCache",
 	"CacheHits", "CacheMisses", "ReplicationLag", "GetTypeCmds", "SetTypeCmds",
 	"KeyBasedCmds", "StringBasedCmds", "HashBasedCmds", "ListBasedCmds", "SetBasedCmds",
-	"SortedSetBasedCmds", "CurrItems",
+	"SortedSetBasedCmds", "CurrItems", "EngineCPUUtilization", "DatabaseMemoryUsagePercentage",
+	"GetTypeCmdsLatency", "SetTypeCmdsLatency", "KeyBasedCmdsLatency", "StringBasedCmdsLatency", "HashBasedCmdsLatency",
+	"ListBasedCmdsLatency", "SetBasedCmdsLatency", "SortedSetBasedCmdsLatency",
 }
 
 var graphdefMemcached = map[string]mp.Graphs{
@@ -231,98 +231,182 @@ var graphdefRedis = map[string]mp.Graphs{
 			{Name: "Reclaimed", Label: "Reclaimed"},
 		},
 	},
+	"ecache.EngineCPUUtilization": {
+		Label: "ECache Engine CPU Utilization",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "EngineCPUUtilization", Label: "EngineCPUUtilization"},
+		},
+	},
+	"ecache.DatabaseMemoryUsagePercentage": {
+		Label: "ECache Database Memory Usage",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "DatabaseMemoryUsagePercentage", Label: "DatabaseMemoryUsagePercentage"},
+		},
+	},
+	"ecache.ReplicationLag": {
+		Label: "ECache Replication Lag",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "ReplicationLag", Label: "ReplicationLag"},
+		},
+	},
+	// the latencies are in microseconds
+	"ecache.CommandLatency": {
+		Label: "ECache Command Latency",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "GetTypeCmdsLatency", Label: "GetTypeCmds"},
+			{Name: "SetTypeCmdsLatency", Label: "SetTypeCmds"},
+			{Name: "KeyBasedCmdsLatency", Label: "KeyBasedCmds"},
+			{Name: "StringBasedCmdsLatency", Label: "StringBasedCmds"},
+			{Name: "HashBasedCmdsLatency", Label: "HashBasedCmds"},
+			{Name: "ListBasedCmdsLatency", Label: "ListBasedCmds"},
+			{Name: "SetBasedCmdsLatency", Label: "SetBasedCmds"},
+			{Name: "SortedSetBasedCmdsLatency", Label: "SortedSetBasedCmds"},
+		},
+	},
+}
+
+// shardMetrics are aggregated per shard of the replication group
+var shardMetrics = []string{"ReplicationLag", "EngineCPUUtilization", "DatabaseMemoryUsagePercentage"}
+
+var graphdefShard = map[string]mp.Graphs{
+	"ecache.shard.ReplicationLag.#": {
+		Label: "ECache Shard Replication Lag",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "Max", Label: "Max"},
+		},
+	},
+	"ecache.shard.EngineCPUUtilization.#": {
+		Label: "ECache Shard Engine CPU Utilization",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "Max", Label: "Max"},
+		},
+	},
+	"ecache.shard.DatabaseMemoryUsagePercentage.#": {
+		Label: "ECache Shard Database Memory Usage",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			{Name: "Max", Label: "Max"},
+		},
+	},
 }
 
+// allNodes in -cache-node-id posts the metrics of all nodes of the cache cluster
+const allNodes = "*"
+
 // ECachePlugin mackerel plugin for elasticache
 type ECachePlugin struct {
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	CacheClusterID  string
-	CacheNodeID     string
-	ElastiCacheType string
-	CacheMetrics    []string
+	awsplugin.SessionOptions
+	CacheClusterID     string
+	CacheNodeID        string
+	ReplicationGroupID string
+	ElastiCacheType    string
+	CacheMetrics       []string
+	CloudWatch         cloudwatchiface.CloudWatchAPI
+	ElastiCache        elasticacheiface.ElastiCacheAPI
+
+	nodes []cacheNode
 }
 
-func getLastPoint(cloudWatch *cloudwatch.CloudWatch, dimensions []*cloudwatch.Dimension, metricName string) (float64, error) {
-	now := time.Now()
+// multiNode returns true if the metrics of the nodes are posted as wildcards
+func (p ECachePlugin) multiNode() bool {
+	return p.ReplicationGroupID != "" || p.CacheNodeID == allNodes
+}
 
-	response, err := cloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: dimensions,
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)), // 3 mins (to fetch at least 1 data-point)
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metricName),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String("Average")},
-		Namespace:  aws.String("AWS/ElastiCache"),
-	})
+func (p *ECachePlugin) prepare() error {
+	sess, err := p.NewSession()
 	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
+		return err
 	}
+	p.CloudWatch = cloudwatch.New(sess)
+	p.ElastiCache = elasticache.New(sess)
 
-	latest := new(time.Time)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
-		}
-
-		latest = dp.Timestamp
-		latestVal = *dp.Average
+	switch {
+	case p.ReplicationGroupID != "":
+		p.nodes, err = describeReplicationGroup(p.ElastiCache, p.ReplicationGroupID)
+	case p.CacheNodeID == allNodes:
+		p.nodes, err = describeCacheNodes(p.ElastiCache, p.CacheClusterID)
 	}
-
-	return latestVal, nil
+	return err
 }
 
 // FetchMetrics fetch elasticache values
 func (p ECachePlugin) FetchMetrics() (map[string]float64, error) {
-	sess, err := session.NewSession()
-	if err != nil {
-		return nil, err
-	}
-
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
+	if p.multiNode() {
+		return p.fetchNodeMetrics()
 	}
 
-	cloudWatch := cloudwatch.New(sess, config)
+	perInstances := cacheNode{CacheClusterID: p.CacheClusterID, CacheNodeID: p.CacheNodeID}.dimensions()
 
-	stat := make(map[string]float64)
+	var queries []awsplugin.MetricQuery
+	for _, met := range p.CacheMetrics {
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        met,
+			Namespace:  "AWS/ElastiCache",
+			MetricName: met,
+			Dimensions: perInstances,
+			Statistic:  "Average",
+		})
+	}
+	return awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+}
 
-	perInstances := []*cloudwatch.Dimension{
-		{
-			Name:  aws.String("CacheClusterId"),
-			Value: aws.String(p.CacheClusterID),
-		},
-		{
-			Name:  aws.String("CacheNodeId"),
-			Value: aws.String(p.CacheNodeID),
-		},
+// fetchNodeMetrics fetches the metrics of the nodes keyed by the graphs with the node names
+func (p ECachePlugin) fetchNodeMetrics() (map[string]float64, error) {
+	graphKeys := make(map[string]string)
+	for key, g := range p.graphdef() {
+		for _, m := range g.Metrics {
+			graphKeys[m.Name] = key
+		}
+	}
+	nodeKey := func(n cacheNode, met string) string {
+		return graphKeys[met] + "." + n.Name + "." + met
 	}
 
-	for _, met := range p.CacheMetrics {
-		v, err := getLastPoint(cloudWatch, perInstances, met)
-		if err == nil {
-			stat[met] = v
-		} else {
-			log.Printf("%s: %s", met, err)
+	var queries []awsplugin.MetricQuery
+	for _, n := range p.nodes {
+		for _, met := range p.CacheMetrics {
+			if _, ok := graphKeys[met]; !ok {
+				continue
+			}
+			queries = append(queries, awsplugin.MetricQuery{
+				Key:        nodeKey(n, met),
+				Namespace:  "AWS/ElastiCache",
+				MetricName: met,
+				Dimensions: n.dimensions(),
+				Statistic:  "Average",
+			})
 		}
 	}
+	stat, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
 
+	for _, n := range p.nodes {
+		if n.Shard == "" {
+			continue
+		}
+		for _, met := range shardMetrics {
+			v, ok := stat[nodeKey(n, met)]
+			if !ok {
+				continue
+			}
+			key := "ecache.shard." + met + "." + metricNameRe.ReplaceAllString(n.Shard, "_") + ".Max"
+			if max, ok := stat[key]; !ok || v > max {
+				stat[key] = v
+			}
+		}
+	}
 	return stat, nil
 }
 
-// GraphDefinition graph definition
-func (p ECachePlugin) GraphDefinition() map[string]mp.Graphs {
+func (p ECachePlugin) graphdef() map[string]mp.Graphs {
 	switch p.ElastiCacheType {
 	case "memcached":
 		return graphdefMemcached
@@ -334,34 +418,50 @@ func (p ECachePlugin) GraphDefinition() map[string]mp.Graphs {
 	}
 }
 
+// GraphDefinition graph definition
+func (p ECachePlugin) GraphDefinition() map[string]mp.Graphs {
+	graphdef := p.graphdef()
+	if !p.multiNode() || graphdef == nil {
+		return graphdef
+	}
+
+	wildcard := make(map[string]mp.Graphs, len(graphdef))
+	for key, g := range graphdef {
+		wildcard[key+".#"] = g
+	}
+	for _, n := range p.nodes {
+		if n.Shard != "" && p.ElastiCacheType == "redis" {
+			for key, g := range graphdefShard {
+				wildcard[key] = g
+			}
+			break
+		}
+	}
+	return wildcard
+}
+
 // Do the plugin
 func Do() {
-	optRegion := flag.String("region", "", "AWS Region")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optCacheClusterID := flag.String("cache-cluster-id", "", "Cache Cluster Id")
-	optCacheNodeID := flag.String("cache-node-id", "0001", "Cache Node Id")
+	optCacheNodeID := flag.String("cache-node-id", "0001", "Cache Node Id, or \"*\" for all nodes of the cluster")
+	optReplicationGroupID := flag.String("replication-group-id", "", "Replication Group Id to post the metrics of all nodes")
 	optElastiCacheType := flag.String("elasticache-type", "", "ElastiCache type")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var ecache ECachePlugin
-
-	if *optRegion == "" {
-		ec2metadata := ec2metadata.New(session.New())
-		if ec2metadata.Available() {
-			ecache.Region, _ = ec2metadata.Region()
-		}
-	} else {
-		ecache.Region = *optRegion
-	}
-
-	ecache.Region = *optRegion
-	ecache.AccessKeyID = *optAccessKeyID
-	ecache.SecretAccessKey = *optSecretAccessKey
+	ecache.SessionOptions = sessionOpts
 	ecache.CacheClusterID = *optCacheClusterID
 	ecache.CacheNodeID = *optCacheNodeID
+	ecache.ReplicationGroupID = *optReplicationGroupID
 	ecache.ElastiCacheType = *optElastiCacheType
+	if ecache.ElastiCacheType == "" && ecache.ReplicationGroupID != "" {
+		// the replication groups are of Redis
+		ecache.ElastiCacheType = "redis"
+	}
 	switch ecache.ElastiCacheType {
 	case "memcached":
 		ecache.CacheMetrics = metricsdefMemcached
@@ -372,9 +472,17 @@ func Do() {
 		os.Exit(1)
 	}
 
+	if err := ecache.prepare(); err != nil {
+		log.Fatalln(err)
+	}
+
 	helper := mp.NewMackerelPlugin(ecache)
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
+	} else if ecache.ReplicationGroupID != "" {
+		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-aws-elasticache-%s", ecache.ReplicationGroupID))
+	} else if ecache.CacheNodeID == allNodes {
+		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-aws-elasticache-%s-all", *optCacheClusterID))
 	} else {
 		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-aws-elasticache-%s-%s", *optCacheClusterID, *optCacheNodeID))
 	}
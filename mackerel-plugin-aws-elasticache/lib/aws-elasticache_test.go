@@ -0,0 +1,139 @@
+package mpawselasticache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockElastiCacheClient struct {
+	elasticacheiface.ElastiCacheAPI
+}
+
+func (m *mockElastiCacheClient) DescribeReplicationGroups(input *elasticache.DescribeReplicationGroupsInput) (*elasticache.DescribeReplicationGroupsOutput, error) {
+	member := func(clusterID, role string) *elasticache.NodeGroupMember {
+		return &elasticache.NodeGroupMember{
+			CacheClusterId: aws.String(clusterID),
+			CacheNodeId:    aws.String("0001"),
+			CurrentRole:    aws.String(role),
+		}
+	}
+	return &elasticache.DescribeReplicationGroupsOutput{
+		ReplicationGroups: []*elasticache.ReplicationGroup{{
+			ReplicationGroupId: input.ReplicationGroupId,
+			MemberClusters:     aws.StringSlice([]string{"sessions-0001-001", "sessions-0001-002", "sessions-0002-001", "sessions-0002-002"}),
+			NodeGroups: []*elasticache.NodeGroup{
+				{
+					NodeGroupId:      aws.String("0001"),
+					NodeGroupMembers: []*elasticache.NodeGroupMember{member("sessions-0001-001", "primary"), member("sessions-0001-002", "replica")},
+				},
+				{
+					NodeGroupId:      aws.String("0002"),
+					NodeGroupMembers: []*elasticache.NodeGroupMember{member("sessions-0002-001", "primary")},
+				},
+			},
+		}},
+	}, nil
+}
+
+func (m *mockElastiCacheClient) DescribeCacheClusters(input *elasticache.DescribeCacheClustersInput) (*elasticache.DescribeCacheClustersOutput, error) {
+	return &elasticache.DescribeCacheClustersOutput{
+		CacheClusters: []*elasticache.CacheCluster{{
+			CacheClusterId: input.CacheClusterId,
+			Engine:         aws.String("memcached"),
+			CacheNodes: []*elasticache.CacheNode{
+				{CacheNodeId: aws.String("0001")},
+				{CacheNodeId: aws.String("0002")},
+			},
+		}},
+	}, nil
+}
+
+func TestDescribeReplicationGroup(t *testing.T) {
+	nodes, err := describeReplicationGroup(&mockElastiCacheClient{}, "sessions")
+	assert.Nil(t, err)
+	assert.Equal(t, []cacheNode{
+		{Name: "sessions-0001-001", CacheClusterID: "sessions-0001-001", CacheNodeID: "0001", Shard: "0001"},
+		{Name: "sessions-0001-002", CacheClusterID: "sessions-0001-002", CacheNodeID: "0001", Shard: "0001"},
+		{Name: "sessions-0002-001", CacheClusterID: "sessions-0002-001", CacheNodeID: "0001", Shard: "0002"},
+		{Name: "sessions-0002-002", CacheClusterID: "sessions-0002-002", CacheNodeID: "0001"},
+	}, nodes)
+}
+
+func TestDescribeCacheNodes(t *testing.T) {
+	nodes, err := describeCacheNodes(&mockElastiCacheClient{}, "memcached01")
+	assert.Nil(t, err)
+	assert.Equal(t, []cacheNode{
+		{Name: "0001", CacheClusterID: "memcached01", CacheNodeID: "0001"},
+		{Name: "0002", CacheClusterID: "memcached01", CacheNodeID: "0002"},
+	}, nodes)
+}
+
+// mockCloudWatchClient returns the values of the metrics in values keyed by the cluster ID and the metric name
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+	values map[string]float64
+}
+
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, q := range input.MetricDataQueries {
+		key := aws.StringValue(q.MetricStat.Metric.Dimensions[0].Value) + "." + aws.StringValue(q.MetricStat.Metric.MetricName)
+		v, ok := m.values[key]
+		if !ok {
+			continue
+		}
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         q.Id,
+			Timestamps: []*time.Time{input.EndTime},
+			Values:     []*float64{aws.Float64(v)},
+		})
+	}
+	return output, nil
+}
+
+func TestFetchMetricsReplicationGroup(t *testing.T) {
+	nodes, err := describeReplicationGroup(&mockElastiCacheClient{}, "sessions")
+	assert.Nil(t, err)
+	p := ECachePlugin{
+		ReplicationGroupID: "sessions",
+		ElastiCacheType:    "redis",
+		CacheMetrics:       metricsdefRedis,
+		CloudWatch: &mockCloudWatchClient{values: map[string]float64{
+			"sessions-0001-001.EngineCPUUtilization": 30,
+			"sessions-0001-002.EngineCPUUtilization": 10,
+			"sessions-0001-002.ReplicationLag":       0.5,
+			"sessions-0002-001.GetTypeCmdsLatency":   12.5,
+		}},
+		nodes: nodes,
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		"ecache.EngineCPUUtilization.sessions-0001-001.EngineCPUUtilization": 30,
+		"ecache.EngineCPUUtilization.sessions-0001-002.EngineCPUUtilization": 10,
+		"ecache.ReplicationLag.sessions-0001-002.ReplicationLag":             0.5,
+		"ecache.CommandLatency.sessions-0002-001.GetTypeCmdsLatency":         12.5,
+		"ecache.shard.EngineCPUUtilization.0001.Max":                         30,
+		"ecache.shard.ReplicationLag.0001.Max":                               0.5,
+	}, stat)
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "ecache.CPUUtilization.#")
+	assert.Contains(t, graphdef, "ecache.shard.ReplicationLag.#")
+	assert.NotContains(t, graphdef, "ecache.CPUUtilization")
+}
+
+func TestGraphDefinitionSingleNode(t *testing.T) {
+	p := ECachePlugin{CacheClusterID: "redis01", CacheNodeID: "0001", ElastiCacheType: "redis"}
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "ecache.CPUUtilization")
+	assert.Contains(t, graphdef, "ecache.CommandLatency")
+	assert.NotContains(t, graphdef, "ecache.shard.ReplicationLag.#")
+}
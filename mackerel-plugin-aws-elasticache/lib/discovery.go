@@ -0,0 +1,102 @@
+package mpawselasticache
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
+)
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// cacheNode is a node of the cluster posted as a wildcard of the metric names
+type cacheNode struct {
+	Name           string
+	CacheClusterID string
+	CacheNodeID    string
+	// Shard is the node group ID of the replication group, empty for a cache cluster
+	Shard string
+}
+
+func (n cacheNode) dimensions() []*cloudwatch.Dimension {
+	return []*cloudwatch.Dimension{
+		{
+			Name:  aws.String("CacheClusterId"),
+			Value: aws.String(n.CacheClusterID),
+		},
+		{
+			Name:  aws.String("CacheNodeId"),
+			Value: aws.String(n.CacheNodeID),
+		},
+	}
+}
+
+// describeReplicationGroup returns the nodes of all shards of the Redis replication group.
+// Each node of Redis is a cache cluster which has the only node "0001".
+func describeReplicationGroup(svc elasticacheiface.ElastiCacheAPI, replicationGroupID string) ([]cacheNode, error) {
+	output, err := svc.DescribeReplicationGroups(&elasticache.DescribeReplicationGroupsInput{
+		ReplicationGroupId: aws.String(replicationGroupID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.ReplicationGroups) == 0 {
+		return nil, fmt.Errorf("replication group %s is not found", replicationGroupID)
+	}
+
+	var nodes []cacheNode
+	found := make(map[string]bool)
+	for _, g := range output.ReplicationGroups[0].NodeGroups {
+		for _, m := range g.NodeGroupMembers {
+			clusterID := aws.StringValue(m.CacheClusterId)
+			found[clusterID] = true
+			nodes = append(nodes, cacheNode{
+				Name:           metricNameRe.ReplaceAllString(clusterID, "_"),
+				CacheClusterID: clusterID,
+				CacheNodeID:    aws.StringValue(m.CacheNodeId),
+				Shard:          aws.StringValue(g.NodeGroupId),
+			})
+		}
+	}
+	// the members of the node groups may be omitted while the group is being modified
+	for _, c := range output.ReplicationGroups[0].MemberClusters {
+		clusterID := aws.StringValue(c)
+		if found[clusterID] {
+			continue
+		}
+		nodes = append(nodes, cacheNode{
+			Name:           metricNameRe.ReplaceAllString(clusterID, "_"),
+			CacheClusterID: clusterID,
+			CacheNodeID:    "0001",
+		})
+	}
+	return nodes, nil
+}
+
+// describeCacheNodes returns the nodes of the cache cluster, e.g. of Memcached
+func describeCacheNodes(svc elasticacheiface.ElastiCacheAPI, cacheClusterID string) ([]cacheNode, error) {
+	output, err := svc.DescribeCacheClusters(&elasticache.DescribeCacheClustersInput{
+		CacheClusterId:    aws.String(cacheClusterID),
+		ShowCacheNodeInfo: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.CacheClusters) == 0 {
+		return nil, fmt.Errorf("cache cluster %s is not found", cacheClusterID)
+	}
+
+	var nodes []cacheNode
+	for _, n := range output.CacheClusters[0].CacheNodes {
+		nodeID := aws.StringValue(n.CacheNodeId)
+		nodes = append(nodes, cacheNode{
+			Name:           metricNameRe.ReplaceAllString(nodeID, "_"),
+			CacheClusterID: cacheClusterID,
+			CacheNodeID:    nodeID,
+		})
+	}
+	return nodes, nil
+}
@@ -1,17 +1,13 @@
 package mpawselasticsearch
 
 import (
-	"errors"
 	"flag"
 	"log"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -129,70 +125,24 @@ var graphdef = map[string]mp.Graphs{
 
 // ESPlugin mackerel plugin for aws elasticsearch
 type ESPlugin struct {
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	Domain          string
-	ClientID        string
-	CloudWatch      *cloudwatch.CloudWatch
+	awsplugin.SessionOptions
+	Domain     string
+	ClientID   string
+	CloudWatch *cloudwatch.CloudWatch
 }
 
 const esNameSpace = "AWS/ES"
 
 func (p *ESPlugin) prepare() error {
-	sess, err := session.NewSession()
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
-
-	p.CloudWatch = cloudwatch.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess)
 	return nil
 }
 
-func (p ESPlugin) getLastPoint(dimensions []*cloudwatch.Dimension, metricName *string) (float64, error) {
-	now := time.Now()
-
-	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: dimensions,
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)),
-		EndTime:    aws.Time(now),
-		MetricName: metricName,
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String("Average")},
-		Namespace:  aws.String(esNameSpace),
-	})
-
-	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
-	}
-
-	latest := new(time.Time)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
-		}
-
-		latest = dp.Timestamp
-		latestVal = *dp.Average
-	}
-
-	return latestVal, nil
-}
-
 // FetchMetrics interface for mackerelplugin
 func (p ESPlugin) FetchMetrics() (map[string]float64, error) {
 	dimensionFilters := []*cloudwatch.DimensionFilter{
@@ -211,11 +161,9 @@ func (p ESPlugin) FetchMetrics() (map[string]float64, error) {
 		Dimensions: dimensionFilters,
 	})
 	if err != nil {
-		log.Printf("%s", err)
+		return nil, err
 	}
 
-	stat := make(map[string]float64)
-
 	dimensions := []*cloudwatch.Dimension{
 		{
 			Name:  aws.String("DomainName"),
@@ -226,19 +174,27 @@ func (p ESPlugin) FetchMetrics() (map[string]float64, error) {
 			Value: aws.String(p.ClientID),
 		},
 	}
+	var queries []awsplugin.MetricQuery
 	for _, met := range ret.Metrics {
-		v, err := p.getLastPoint(dimensions, met.MetricName)
-		if err == nil {
-			if *met.MetricName == "MasterFreeStorageSpace" || *met.MetricName == "FreeStorageSpace" {
-				// MBytes -> Bytes
-				v = v * 1024 * 1024
-			}
-			stat[*met.MetricName] = v
-		} else {
-			log.Printf("%s: %s", *met.MetricName, err)
-		}
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        *met.MetricName,
+			Namespace:  esNameSpace,
+			MetricName: *met.MetricName,
+			Dimensions: dimensions,
+			Statistic:  "Average",
+		})
 	}
 
+	stat, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"MasterFreeStorageSpace", "FreeStorageSpace"} {
+		if v, ok := stat[name]; ok {
+			// MBytes -> Bytes
+			stat[name] = v * 1024 * 1024
+		}
+	}
 	return stat, nil
 }
 
@@ -249,30 +205,18 @@ func (p ESPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optRegion := flag.String("region", "", "AWS Region")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optClientID := flag.String("client-id", "", "AWS Client ID")
 	optDomain := flag.String("domain", "", "ES domain name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var es ESPlugin
-
-	if *optRegion == "" {
-		ec2metadata := ec2metadata.New(session.New())
-		if ec2metadata.Available() {
-			es.Region, _ = ec2metadata.Region()
-		}
-	} else {
-		es.Region = *optRegion
-	}
-
-	es.Region = *optRegion
+	es.SessionOptions = sessionOpts
 	es.Domain = *optDomain
 	es.ClientID = *optClientID
-	es.AccessKeyID = *optAccessKeyID
-	es.SecretAccessKey = *optSecretAccessKey
 
 	err := es.prepare()
 	if err != nil {
@@ -7,11 +7,12 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -54,29 +55,41 @@ func (s statType) String() string {
 
 // ELBPlugin elb plugin for mackerel
 type ELBPlugin struct {
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	AZs             []*string
-	CloudWatch      *cloudwatch.CloudWatch
-	Lbname          string
+	awsplugin.SessionOptions
+	AZs        []*string
+	CloudWatch cloudwatchiface.CloudWatchAPI
+	ELBV2      elbv2iface.ELBV2API
+	Lbname     string
+	// Type is the type of the load balancer: classic, application or network
+	Type string
+
+	loadBalancer string
+	targetGroups []targetGroup
 }
 
 func (p *ELBPlugin) prepare() error {
-	sess, err := session.NewSession()
+	switch p.Type {
+	case typeClassic:
+	case typeApplication, typeNetwork:
+		if p.Lbname == "" {
+			return errors.New("-lbname is required for application and network load balancers")
+		}
+	default:
+		return errors.New("-type must be classic, application or network")
+	}
+
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
+	p.CloudWatch = cloudwatch.New(sess)
 
-	p.CloudWatch = cloudwatch.New(sess, config)
+	if p.Type != typeClassic {
+		p.ELBV2 = elbv2.New(sess)
+		p.loadBalancer, p.targetGroups, err = describeLoadBalancer(p.ELBV2, p.Type, p.Lbname)
+		return err
+	}
 
 	ret, err := p.CloudWatch.ListMetrics(&cloudwatch.ListMetricsInput{
 		Namespace: aws.String("AWS/ELB"),
@@ -106,49 +119,25 @@ func (p *ELBPlugin) prepare() error {
 	return nil
 }
 
-func (p ELBPlugin) getLastPoint(dimensions []*cloudwatch.Dimension, metricName string, sTyp statType) (float64, error) {
-	now := time.Now()
-
-	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+func newQuery(key string, dimensions []*cloudwatch.Dimension, metricName string, sTyp statType) awsplugin.MetricQuery {
+	return awsplugin.MetricQuery{
+		Key:        key,
+		Namespace:  "AWS/ELB",
+		MetricName: metricName,
 		Dimensions: dimensions,
-		StartTime:  aws.Time(now.Add(time.Duration(120) * time.Second * -1)), // 2 min (to fetch at least 1 data-point)
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metricName),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String(sTyp.String())},
-		Namespace:  aws.String("AWS/ELB"),
-	})
-	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
-	}
-
-	latest := new(time.Time)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
-		}
-
-		latest = dp.Timestamp
-		switch sTyp {
-		case stAve:
-			latestVal = *dp.Average
-		case stSum:
-			latestVal = *dp.Sum
-		}
+		Statistic:  sTyp.String(),
 	}
-
-	return latestVal, nil
 }
 
 // FetchMetrics fetch elb metrics
 func (p ELBPlugin) FetchMetrics() (map[string]float64, error) {
-	stat := make(map[string]float64)
+	fetcher := awsplugin.NewCloudWatchFetcher(p.CloudWatch)
+	fetcher.Range = 2 * time.Minute // to fetch at least 1 data-point
+	if p.Type == typeApplication || p.Type == typeNetwork {
+		return fetcher.Fetch(p.elbv2Queries())
+	}
+
+	var queries []awsplugin.MetricQuery
 
 	// HostCount per AZ
 	for _, az := range p.AZs {
@@ -166,10 +155,7 @@ func (p ELBPlugin) FetchMetrics() (map[string]float64, error) {
 			d = append(d, d2)
 		}
 		for _, met := range []string{"HealthyHostCount", "UnHealthyHostCount"} {
-			v, err := p.getLastPoint(d, met, stAve)
-			if err == nil {
-				stat[met+"_"+*az] = v
-			}
+			queries = append(queries, newQuery(met+"_"+*az, d, met, stAve))
 		}
 	}
 
@@ -182,23 +168,23 @@ func (p ELBPlugin) FetchMetrics() (map[string]float64, error) {
 		glb = append(glb, g2)
 	}
 
-	v, err := p.getLastPoint(glb, "Latency", stAve)
-	if err == nil {
-		stat["Latency"] = v
-	}
-
+	queries = append(queries, newQuery("Latency", glb, "Latency", stAve))
 	for _, met := range [...]string{"HTTPCode_Backend_2XX", "HTTPCode_Backend_3XX", "HTTPCode_Backend_4XX", "HTTPCode_Backend_5XX"} {
-		v, err := p.getLastPoint(glb, met, stSum)
-		if err == nil {
-			stat[met] = v
-		}
+		queries = append(queries, newQuery(met, glb, met, stSum))
 	}
 
-	return stat, nil
+	return fetcher.Fetch(queries)
 }
 
 // GraphDefinition for Mackerel
 func (p ELBPlugin) GraphDefinition() map[string]mp.Graphs {
+	switch p.Type {
+	case typeApplication:
+		return albGraphdef
+	case typeNetwork:
+		return nlbGraphdef
+	}
+
 	for _, grp := range [...]string{"elb.healthy_host_count", "elb.unhealthy_host_count"} {
 		var namePre string
 		var label string
@@ -227,26 +213,18 @@ func (p ELBPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optLbname := flag.String("lbname", "", "ELB Name")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optType := flag.String("type", typeClassic, "Load balancer type: classic, application or network")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var elb ELBPlugin
-
-	if *optRegion == "" {
-		ec2metadata := ec2metadata.New(session.New())
-		if ec2metadata.Available() {
-			elb.Region, _ = ec2metadata.Region()
-		}
-	} else {
-		elb.Region = *optRegion
-	}
-	elb.AccessKeyID = *optAccessKeyID
-	elb.SecretAccessKey = *optSecretAccessKey
+	elb.SessionOptions = sessionOpts
 	elb.Lbname = *optLbname
+	elb.Type = *optType
 
 	err := elb.prepare()
 	if err != nil {
@@ -0,0 +1,240 @@
+package mpawselb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
+)
+
+// the load balancer types of -type
+const (
+	typeClassic     = "classic"
+	typeApplication = "application"
+	typeNetwork     = "network"
+)
+
+type metricDef struct {
+	Key        string
+	MetricName string
+	Statistic  string
+}
+
+var albMetrics = []metricDef{
+	{Key: "TargetResponseTime", MetricName: "TargetResponseTime", Statistic: "Average"},
+	{Key: "TargetResponseTime_p99", MetricName: "TargetResponseTime", Statistic: "p99"},
+	{Key: "RequestCount", MetricName: "RequestCount", Statistic: stSum.String()},
+	{Key: "HTTPCode_Target_2XX_Count", MetricName: "HTTPCode_Target_2XX_Count", Statistic: stSum.String()},
+	{Key: "HTTPCode_Target_3XX_Count", MetricName: "HTTPCode_Target_3XX_Count", Statistic: stSum.String()},
+	{Key: "HTTPCode_Target_4XX_Count", MetricName: "HTTPCode_Target_4XX_Count", Statistic: stSum.String()},
+	{Key: "HTTPCode_Target_5XX_Count", MetricName: "HTTPCode_Target_5XX_Count", Statistic: stSum.String()},
+	{Key: "HTTPCode_ELB_4XX_Count", MetricName: "HTTPCode_ELB_4XX_Count", Statistic: stSum.String()},
+	{Key: "HTTPCode_ELB_5XX_Count", MetricName: "HTTPCode_ELB_5XX_Count", Statistic: stSum.String()},
+	{Key: "ActiveConnectionCount", MetricName: "ActiveConnectionCount", Statistic: stSum.String()},
+	{Key: "NewConnectionCount", MetricName: "NewConnectionCount", Statistic: stSum.String()},
+	{Key: "RejectedConnectionCount", MetricName: "RejectedConnectionCount", Statistic: stSum.String()},
+	{Key: "ProcessedBytes", MetricName: "ProcessedBytes", Statistic: stSum.String()},
+}
+
+var nlbMetrics = []metricDef{
+	{Key: "ActiveFlowCount", MetricName: "ActiveFlowCount", Statistic: stAve.String()},
+	{Key: "NewFlowCount", MetricName: "NewFlowCount", Statistic: stSum.String()},
+	{Key: "ProcessedBytes", MetricName: "ProcessedBytes", Statistic: stSum.String()},
+	{Key: "TCP_Client_Reset_Count", MetricName: "TCP_Client_Reset_Count", Statistic: stSum.String()},
+	{Key: "TCP_Target_Reset_Count", MetricName: "TCP_Target_Reset_Count", Statistic: stSum.String()},
+	{Key: "TCP_ELB_Reset_Count", MetricName: "TCP_ELB_Reset_Count", Statistic: stSum.String()},
+}
+
+var albGraphdef = map[string]mp.Graphs{
+	"alb.target_response_time": {
+		Label: "ALB Target Response Time",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "TargetResponseTime", Label: "Average"},
+			{Name: "TargetResponseTime_p99", Label: "99th percentile"},
+		},
+	},
+	"alb.requests": {
+		Label: "ALB Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "RequestCount", Label: "Requests"},
+		},
+	},
+	"alb.http_target": {
+		Label: "ALB HTTP Target Count",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "HTTPCode_Target_2XX_Count", Label: "2XX", Stacked: true},
+			{Name: "HTTPCode_Target_3XX_Count", Label: "3XX", Stacked: true},
+			{Name: "HTTPCode_Target_4XX_Count", Label: "4XX", Stacked: true},
+			{Name: "HTTPCode_Target_5XX_Count", Label: "5XX", Stacked: true},
+		},
+	},
+	"alb.http_elb": {
+		Label: "ALB HTTP ELB Count",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "HTTPCode_ELB_4XX_Count", Label: "4XX", Stacked: true},
+			{Name: "HTTPCode_ELB_5XX_Count", Label: "5XX", Stacked: true},
+		},
+	},
+	"alb.connections": {
+		Label: "ALB Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "ActiveConnectionCount", Label: "Active"},
+			{Name: "NewConnectionCount", Label: "New"},
+			{Name: "RejectedConnectionCount", Label: "Rejected"},
+		},
+	},
+	"alb.processed_bytes": {
+		Label: "ALB Processed Bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "ProcessedBytes", Label: "Processed"},
+		},
+	},
+	"alb.target_group.#": {
+		Label: "ALB Target Group Host Count",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "HealthyHostCount", Label: "Healthy", Stacked: true},
+			{Name: "UnHealthyHostCount", Label: "Unhealthy", Stacked: true},
+		},
+	},
+}
+
+var nlbGraphdef = map[string]mp.Graphs{
+	"nlb.flows": {
+		Label: "NLB Flows",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "ActiveFlowCount", Label: "Active"},
+			{Name: "NewFlowCount", Label: "New"},
+		},
+	},
+	"nlb.processed_bytes": {
+		Label: "NLB Processed Bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "ProcessedBytes", Label: "Processed"},
+		},
+	},
+	"nlb.tcp_resets": {
+		Label: "NLB TCP Resets",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "TCP_Client_Reset_Count", Label: "Client", Stacked: true},
+			{Name: "TCP_Target_Reset_Count", Label: "Target", Stacked: true},
+			{Name: "TCP_ELB_Reset_Count", Label: "ELB", Stacked: true},
+		},
+	},
+	"nlb.target_group.#": {
+		Label: "NLB Target Group Host Count",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "HealthyHostCount", Label: "Healthy", Stacked: true},
+			{Name: "UnHealthyHostCount", Label: "Unhealthy", Stacked: true},
+		},
+	},
+}
+
+// targetGroup is a target group of the load balancer
+type targetGroup struct {
+	Name string
+	// Dimension is the value of TargetGroup dimension like "targetgroup/my-targets/73e2d6bc24d8a067"
+	Dimension string
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// dimensionOfARN returns the dimension value of CloudWatch from the ARN of the load balancer or the target group
+func dimensionOfARN(arn string) string {
+	// arn:aws:elasticloadbalancing:region:account:loadbalancer/app/my-load-balancer/50dc6c495c0c9188
+	// arn:aws:elasticloadbalancing:region:account:targetgroup/my-targets/73e2d6bc24d8a067
+	i := strings.LastIndex(arn, ":")
+	dimension := arn[i+1:]
+	return strings.TrimPrefix(dimension, "loadbalancer/")
+}
+
+// describeLoadBalancer returns the dimension value of the load balancer and its target groups
+func describeLoadBalancer(svc elbv2iface.ELBV2API, lbType, name string) (string, []targetGroup, error) {
+	output, err := svc.DescribeLoadBalancers(&elbv2.DescribeLoadBalancersInput{
+		Names: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(output.LoadBalancers) == 0 {
+		return "", nil, fmt.Errorf("load balancer %s is not found", name)
+	}
+	lb := output.LoadBalancers[0]
+	if t := aws.StringValue(lb.Type); t != lbType {
+		return "", nil, fmt.Errorf("load balancer %s is %s, not %s", name, t, lbType)
+	}
+
+	var targetGroups []targetGroup
+	err = svc.DescribeTargetGroupsPages(&elbv2.DescribeTargetGroupsInput{
+		LoadBalancerArn: lb.LoadBalancerArn,
+	}, func(output *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+		for _, tg := range output.TargetGroups {
+			targetGroups = append(targetGroups, targetGroup{
+				Name:      aws.StringValue(tg.TargetGroupName),
+				Dimension: dimensionOfARN(aws.StringValue(tg.TargetGroupArn)),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return dimensionOfARN(aws.StringValue(lb.LoadBalancerArn)), targetGroups, nil
+}
+
+func (p ELBPlugin) elbv2Queries() []awsplugin.MetricQuery {
+	namespace, graphPrefix, metrics := "AWS/ApplicationELB", "alb", albMetrics
+	if p.Type == typeNetwork {
+		namespace, graphPrefix, metrics = "AWS/NetworkELB", "nlb", nlbMetrics
+	}
+	lbDimension := &cloudwatch.Dimension{
+		Name:  aws.String("LoadBalancer"),
+		Value: aws.String(p.loadBalancer),
+	}
+
+	var queries []awsplugin.MetricQuery
+	for _, met := range metrics {
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        met.Key,
+			Namespace:  namespace,
+			MetricName: met.MetricName,
+			Dimensions: []*cloudwatch.Dimension{lbDimension},
+			Statistic:  met.Statistic,
+		})
+	}
+	for _, tg := range p.targetGroups {
+		dimensions := []*cloudwatch.Dimension{
+			{
+				Name:  aws.String("TargetGroup"),
+				Value: aws.String(tg.Dimension),
+			},
+			lbDimension,
+		}
+		for _, met := range []string{"HealthyHostCount", "UnHealthyHostCount"} {
+			queries = append(queries, awsplugin.MetricQuery{
+				Key:        graphPrefix + ".target_group." + metricNameRe.ReplaceAllString(tg.Name, "_") + "." + met,
+				Namespace:  namespace,
+				MetricName: met,
+				Dimensions: dimensions,
+				Statistic:  stAve.String(),
+			})
+		}
+	}
+	return queries
+}
@@ -0,0 +1,115 @@
+package mpawselb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockELBV2Client struct {
+	elbv2iface.ELBV2API
+}
+
+func (m *mockELBV2Client) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	return &elbv2.DescribeLoadBalancersOutput{
+		LoadBalancers: []*elbv2.LoadBalancer{{
+			LoadBalancerArn:  aws.String("arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188"),
+			LoadBalancerName: input.Names[0],
+			Type:             aws.String(elbv2.LoadBalancerTypeEnumApplication),
+		}},
+	}, nil
+}
+
+func (m *mockELBV2Client) DescribeTargetGroupsPages(input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool) error {
+	fn(&elbv2.DescribeTargetGroupsOutput{
+		TargetGroups: []*elbv2.TargetGroup{
+			{
+				TargetGroupArn:  aws.String("arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:targetgroup/web/73e2d6bc24d8a067"),
+				TargetGroupName: aws.String("web"),
+			},
+			{
+				TargetGroupArn:  aws.String("arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:targetgroup/api.v2/8e2d6bc24d8a0673"),
+				TargetGroupName: aws.String("api.v2"),
+			},
+		},
+	}, true)
+	return nil
+}
+
+func TestDescribeLoadBalancer(t *testing.T) {
+	lb, targetGroups, err := describeLoadBalancer(&mockELBV2Client{}, typeApplication, "my-alb")
+	assert.Nil(t, err)
+	assert.Equal(t, "app/my-alb/50dc6c495c0c9188", lb)
+	assert.Equal(t, []targetGroup{
+		{Name: "web", Dimension: "targetgroup/web/73e2d6bc24d8a067"},
+		{Name: "api.v2", Dimension: "targetgroup/api.v2/8e2d6bc24d8a0673"},
+	}, targetGroups)
+
+	_, _, err = describeLoadBalancer(&mockELBV2Client{}, typeNetwork, "my-alb")
+	assert.NotNil(t, err, "the type of the load balancer is checked")
+}
+
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+	queries []*cloudwatch.MetricDataQuery
+}
+
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	m.queries = append(m.queries, input.MetricDataQueries...)
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, q := range input.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         q.Id,
+			Timestamps: []*time.Time{input.EndTime},
+			Values:     []*float64{aws.Float64(3)},
+		})
+	}
+	return output, nil
+}
+
+func TestFetchMetricsApplication(t *testing.T) {
+	cw := &mockCloudWatchClient{}
+	p := ELBPlugin{
+		CloudWatch:   cw,
+		Type:         typeApplication,
+		loadBalancer: "app/my-alb/50dc6c495c0c9188",
+		targetGroups: []targetGroup{{Name: "api.v2", Dimension: "targetgroup/api.v2/8e2d6bc24d8a0673"}},
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, stat["TargetResponseTime_p99"])
+	assert.Equal(t, 3.0, stat["HTTPCode_Target_5XX_Count"])
+	assert.Equal(t, 3.0, stat["RejectedConnectionCount"])
+	assert.Equal(t, 3.0, stat["alb.target_group.api_v2.HealthyHostCount"])
+	assert.Equal(t, 3.0, stat["alb.target_group.api_v2.UnHealthyHostCount"])
+	for _, q := range cw.queries {
+		assert.Equal(t, "AWS/ApplicationELB", aws.StringValue(q.MetricStat.Metric.Namespace))
+	}
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "alb.target_group.#")
+	assert.NotContains(t, graphdef, "elb.latency")
+}
+
+func TestFetchMetricsNetwork(t *testing.T) {
+	cw := &mockCloudWatchClient{}
+	p := ELBPlugin{
+		CloudWatch:   cw,
+		Type:         typeNetwork,
+		loadBalancer: "net/my-nlb/50dc6c495c0c9188",
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, stat["ActiveFlowCount"])
+	assert.Equal(t, 3.0, stat["TCP_ELB_Reset_Count"])
+	for _, q := range cw.queries {
+		assert.Equal(t, "AWS/NetworkELB", aws.StringValue(q.MetricStat.Metric.Namespace))
+	}
+	assert.Contains(t, p.GraphDefinition(), "nlb.tcp_resets")
+}
@@ -7,11 +7,9 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 const (
@@ -28,10 +26,8 @@ type KinesisFirehosePlugin struct {
 	Name   string
 	Prefix string
 
-	AccessKeyID     string
-	SecretAccessKey string
-	Region          string
-	CloudWatch      *cloudwatch.CloudWatch
+	awsplugin.SessionOptions
+	CloudWatch *cloudwatch.CloudWatch
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -43,77 +39,26 @@ func (p KinesisFirehosePlugin) MetricKeyPrefix() string {
 }
 
 func (p *KinesisFirehosePlugin) prepare() error {
-	sess, err := session.NewSession()
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
-
-	p.CloudWatch = cloudwatch.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess)
 
 	return nil
 }
 
-func getLastPointFromCloudWatch(cw cloudwatchiface.CloudWatchAPI, deliveryStreamName string, metric metrics) (*cloudwatch.Datapoint, error) {
-	now := time.Now()
-
+// FetchMetrics fetch the metrics
+func (p KinesisFirehosePlugin) FetchMetrics() (map[string]interface{}, error) {
 	dimensions := []*cloudwatch.Dimension{
 		{
 			Name:  aws.String("DeliveryStreamName"),
-			Value: aws.String(deliveryStreamName),
+			Value: aws.String(p.Name),
 		},
 	}
 
-	response, err := cw.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: dimensions,
-		StartTime:  aws.Time(now.Add(time.Duration(480) * time.Second * -1)), // 8 min, since some metrics are aggregated over 5 min
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metric.CloudWatchName),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String("Average")},
-		Namespace:  aws.String(namespace),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return nil, nil
-	}
-
-	latest := new(time.Time)
-	var latestDp *cloudwatch.Datapoint
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
-		}
-
-		latest = dp.Timestamp
-		latestDp = dp
-	}
-
-	return latestDp, nil
-}
-
-func mergeStatsFromDatapoint(stats map[string]interface{}, dp *cloudwatch.Datapoint, metric metrics) map[string]interface{} {
-	if dp != nil {
-		stats[metric.MackerelName] = *dp.Average
-	}
-	return stats
-}
-
-// FetchMetrics fetch the metrics
-func (p KinesisFirehosePlugin) FetchMetrics() (map[string]interface{}, error) {
-	stats := make(map[string]interface{})
-
+	var queries []awsplugin.MetricQuery
 	for _, met := range [...]metrics{
 		{CloudWatchName: "DeliveryToElasticsearch.Bytes", MackerelName: "DeliveryToElasticsearchBytes"},
 		{CloudWatchName: "DeliveryToElasticsearch.Records", MackerelName: "DeliveryToElasticsearchRecords"},
@@ -139,14 +84,24 @@ func (p KinesisFirehosePlugin) FetchMetrics() (map[string]interface{}, error) {
 		{CloudWatchName: "UpdateDeliveryStream.Latency", MackerelName: "UpdateDeliveryStreamLatency"},
 		{CloudWatchName: "UpdateDeliveryStream.Requests", MackerelName: "UpdateDeliveryStreamRequests"},
 	} {
-		v, err := getLastPointFromCloudWatch(p.CloudWatch, p.Name, met)
-		if err == nil {
-			stats = mergeStatsFromDatapoint(stats, v, met)
-		} else {
-			log.Printf("%s: %s", met, err)
-		}
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        met.MackerelName,
+			Namespace:  namespace,
+			MetricName: met.CloudWatchName,
+			Dimensions: dimensions,
+			Statistic:  "Average",
+			Range:      8 * time.Minute, // since some metrics are aggregated over 5 min
+		})
 	}
 
+	values, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		stats[k] = v
+	}
 	return stats, nil
 }
 
@@ -221,19 +176,16 @@ func (p KinesisFirehosePlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optIdentifier := flag.String("identifier", "", "Delivery Stream Name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optPrefix := flag.String("metric-key-prefix", "kinesis-firehose", "Metric key prefix")
 	flag.Parse()
 
 	var plugin KinesisFirehosePlugin
-
-	plugin.AccessKeyID = *optAccessKeyID
-	plugin.SecretAccessKey = *optSecretAccessKey
-	plugin.Region = *optRegion
+	plugin.SessionOptions = sessionOpts
 	plugin.Name = *optIdentifier
 	plugin.Prefix = *optPrefix
 
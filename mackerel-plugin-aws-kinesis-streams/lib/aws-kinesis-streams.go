@@ -1,17 +1,14 @@
 package mpawskinesisstreams
 
 import (
-	"errors"
 	"flag"
 	"log"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 const (
@@ -32,10 +29,8 @@ type KinesisStreamsPlugin struct {
 	Name   string
 	Prefix string
 
-	AccessKeyID     string
-	SecretAccessKey string
-	Region          string
-	CloudWatch      *cloudwatch.CloudWatch
+	awsplugin.SessionOptions
+	CloudWatch *cloudwatch.CloudWatch
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -48,28 +43,18 @@ func (p KinesisStreamsPlugin) MetricKeyPrefix() string {
 
 // prepare creates CloudWatch instance
 func (p *KinesisStreamsPlugin) prepare() error {
-	sess, err := session.NewSession()
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
-
-	p.CloudWatch = cloudwatch.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess)
 
 	return nil
 }
 
-// getLastPoint fetches a CloudWatch metric and parse
-func (p KinesisStreamsPlugin) getLastPoint(metric metrics) (float64, error) {
-	now := time.Now()
-
+// FetchMetrics fetch the metrics
+func (p KinesisStreamsPlugin) FetchMetrics() (map[string]interface{}, error) {
 	dimensions := []*cloudwatch.Dimension{
 		{
 			Name:  aws.String("StreamName"),
@@ -77,49 +62,7 @@ func (p KinesisStreamsPlugin) getLastPoint(metric metrics) (float64, error) {
 		},
 	}
 
-	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: dimensions,
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)), // 3 min
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metric.CloudWatchName),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String(metric.Type)},
-		Namespace:  aws.String(namespace),
-	})
-	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
-	}
-
-	latest := new(time.Time)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
-		}
-
-		latest = dp.Timestamp
-		switch metric.Type {
-		case metricsTypeAverage:
-			latestVal = *dp.Average
-		case metricsTypeMaximum:
-			latestVal = *dp.Maximum
-		case metricsTypeMinimum:
-			latestVal = *dp.Minimum
-		}
-	}
-
-	return latestVal, nil
-}
-
-// FetchMetrics fetch the metrics
-func (p KinesisStreamsPlugin) FetchMetrics() (map[string]interface{}, error) {
-	stat := make(map[string]interface{})
-
+	var queries []awsplugin.MetricQuery
 	for _, met := range [...]metrics{
 		{CloudWatchName: "GetRecords.Bytes", MackerelName: "GetRecordsBytes", Type: metricsTypeAverage},
 		// Max of IteratorAgeMilliseconds is useful especially when few of iterators are in trouble
@@ -141,12 +84,22 @@ func (p KinesisStreamsPlugin) FetchMetrics() (map[string]interface{}, error) {
 		{CloudWatchName: "ReadProvidionedThroughputExceeded", MackerelName: "ReadThroughputExceeded", Type: metricsTypeAverage},
 		{CloudWatchName: "WriteProvidionedThroughputExceeded", MackerelName: "WriteThroughputExceeded", Type: metricsTypeAverage},
 	} {
-		v, err := p.getLastPoint(met)
-		if err == nil {
-			stat[met.MackerelName] = v
-		} else {
-			log.Printf("%s: %s", met, err)
-		}
+		queries = append(queries, awsplugin.MetricQuery{
+			Key:        met.MackerelName,
+			Namespace:  namespace,
+			MetricName: met.CloudWatchName,
+			Dimensions: dimensions,
+			Statistic:  met.Type,
+		})
+	}
+
+	values, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
+	stat := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		stat[k] = v
 	}
 	return stat, nil
 }
@@ -217,19 +170,16 @@ func (p KinesisStreamsPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optIdentifier := flag.String("identifier", "", "Stream Name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optPrefix := flag.String("metric-key-prefix", "kinesis-streams", "Metric key prefix")
 	flag.Parse()
 
 	var plugin KinesisStreamsPlugin
-
-	plugin.AccessKeyID = *optAccessKeyID
-	plugin.SecretAccessKey = *optSecretAccessKey
-	plugin.Region = *optRegion
+	plugin.SessionOptions = sessionOpts
 	plugin.Name = *optIdentifier
 	plugin.Prefix = *optPrefix
 
@@ -1,17 +1,18 @@
 package mpawslambda
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 const (
@@ -25,7 +26,9 @@ const (
 // has 1 CloudWatch MetricName and corresponding N Mackerel Metrics
 type metricsGroup struct {
 	CloudWatchName string
-	Metrics        []metric
+	// Graph is the name of the graph the metrics belong to
+	Graph   string
+	Metrics []metric
 }
 
 type metric struct {
@@ -36,13 +39,21 @@ type metric struct {
 // LambdaPlugin mackerel plugin for aws Lambda
 type LambdaPlugin struct {
 	FunctionName string
-	Prefix       string
+	// Qualifier is the alias or the version of the function to fetch the metrics of the Resource dimension
+	Qualifier string
+	// FunctionNamePrefix and Tags discover the functions to post the metrics per function
+	FunctionNamePrefix string
+	Tags               map[string]string
+	// Stream adds the metrics of the functions triggered by Kinesis or DynamoDB streams
+	Stream bool
+	Prefix string
 
-	AccessKeyID     string
-	SecretAccessKey string
-	Region          string
+	awsplugin.SessionOptions
 
-	CloudWatch *cloudwatch.CloudWatch
+	CloudWatch cloudwatchiface.CloudWatchAPI
+	Lambda     lambdaiface.LambdaAPI
+
+	functions []string
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -50,138 +61,202 @@ func (p LambdaPlugin) MetricKeyPrefix() string {
 	return p.Prefix
 }
 
+// discovery returns true if the functions are discovered by the name prefix or the tags
+func (p LambdaPlugin) discovery() bool {
+	return p.FunctionNamePrefix != "" || len(p.Tags) > 0
+}
+
+// account returns true if the metrics across all functions in the region are posted
+func (p LambdaPlugin) account() bool {
+	return p.FunctionName == "" && !p.discovery()
+}
+
 // prepare creates CloudWatch instance
 func (p *LambdaPlugin) prepare() error {
+	if p.FunctionName != "" && p.discovery() {
+		return errors.New("-function-name cannot be used with -function-name-prefix or -tag")
+	}
+	if p.Qualifier != "" && p.account() {
+		return errors.New("-qualifier requires -function-name, -function-name-prefix or -tag")
+	}
 
-	sess, err := session.NewSession()
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
+	p.CloudWatch = cloudwatch.New(sess)
 
-	p.CloudWatch = cloudwatch.New(sess, config)
+	if p.discovery() {
+		p.Lambda = lambda.New(sess)
+		p.functions, err = listFunctions(p.Lambda, p.FunctionNamePrefix, p.Tags)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-// getLastPoint fetches a CloudWatch metric and parse
-func getLastPointFromCloudWatch(cw cloudwatchiface.CloudWatchAPI, functionName string, metric metricsGroup) (*cloudwatch.Datapoint, error) {
-	now := time.Now()
-	statsInput := make([]*string, len(metric.Metrics))
-	for i, typ := range metric.Metrics {
-		statsInput[i] = aws.String(typ.Type)
-	}
-	input := &cloudwatch.GetMetricStatisticsInput{
-		// Usually Cloudwatch datapoints delays about 2 mins, so retrieve last 3 mins (with 1 min buffer)
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)),
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metric.CloudWatchName),
-		Period:     aws.Int64(60),
-		Statistics: statsInput,
-		Namespace:  aws.String(namespace),
-	}
-	if functionName != "" {
-		input.Dimensions = []*cloudwatch.Dimension{{
-			Name:  aws.String("FunctionName"),
-			Value: aws.String(functionName),
-		}}
-	}
-	response, err := cw.GetMetricStatistics(input)
-	if err != nil {
-		return nil, err
+// dimensions returns the dimensions of the function, with the Resource dimension of the alias or the version if qualifier is given
+func dimensions(functionName, qualifier string) []*cloudwatch.Dimension {
+	if functionName == "" {
+		return nil
 	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return nil, nil
+	dims := []*cloudwatch.Dimension{{
+		Name:  aws.String("FunctionName"),
+		Value: aws.String(functionName),
+	}}
+	if qualifier != "" {
+		dims = append(dims, &cloudwatch.Dimension{
+			Name:  aws.String("Resource"),
+			Value: aws.String(functionName + ":" + qualifier),
+		})
 	}
+	return dims
+}
 
-	latest := new(time.Time)
-	var latestDp *cloudwatch.Datapoint
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
+// buildQueries returns the queries of the metrics groups. The keys are prefixed with the graph and keyName if keyName is not empty
+func buildQueries(dimensions []*cloudwatch.Dimension, groups []metricsGroup, keyName string) []awsplugin.MetricQuery {
+	var queries []awsplugin.MetricQuery
+	for _, mg := range groups {
+		for _, met := range mg.Metrics {
+			key := met.MackerelName
+			if keyName != "" {
+				key = mg.Graph + "." + keyName + "." + met.MackerelName
+			}
+			queries = append(queries, awsplugin.MetricQuery{
+				Key:        key,
+				Namespace:  namespace,
+				MetricName: mg.CloudWatchName,
+				Dimensions: dimensions,
+				Statistic:  met.Type,
+			})
 		}
+	}
+	return queries
+}
 
-		latest = dp.Timestamp
-		latestDp = dp
+// fetch fetches the latest datapoints of the queries
+func fetch(cw cloudwatchiface.CloudWatchAPI, queries []awsplugin.MetricQuery) (map[string]interface{}, error) {
+	// Usually Cloudwatch datapoints delays about 2 mins, so retrieve last 3 mins (with 1 min buffer)
+	values, err := awsplugin.NewCloudWatchFetcher(cw).Fetch(queries)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		stats[k] = v
 	}
+	return stats, nil
+}
 
-	return latestDp, nil
+// fetchMetrics fetches the latest datapoints of lambdaMetricsGroup
+func fetchMetrics(cw cloudwatchiface.CloudWatchAPI, functionName string) (map[string]interface{}, error) {
+	return fetch(cw, buildQueries(dimensions(functionName, ""), lambdaMetricsGroup, ""))
 }
 
 // TransformMetrics converts some of datapoints to post differences of two metrics
 func transformMetrics(stats map[string]interface{}) map[string]interface{} {
-	// Although stats are interface{}, those values from cloudwatch.Datapoint are guaranteed to be float64.
-	if totalCount, ok := stats["invocations_total"].(float64); ok {
-		if errorCount, ok := stats["invocations_error"].(float64); ok {
-			stats["invocations_success"] = totalCount - errorCount
-		} else {
-			stats["invocations_success"] = totalCount
-		}
-		delete(stats, "invocations_total")
-	}
-	return stats
+	return transformInvocations(stats, "")
 }
 
-func mergeStatsFromDatapoint(stats map[string]interface{}, dp *cloudwatch.Datapoint, mg metricsGroup) map[string]interface{} {
-	if dp != nil {
-		for _, met := range mg.Metrics {
-			switch met.Type {
-			case metricsTypeAverage:
-				stats[met.MackerelName] = *dp.Average
-			case metricsTypeSum:
-				stats[met.MackerelName] = *dp.Sum
-			case metricsTypeMaximum:
-				stats[met.MackerelName] = *dp.Maximum
-			case metricsTypeMinimum:
-				stats[met.MackerelName] = *dp.Minimum
-			}
+// transformInvocations calculates invocations_success of the keys prefixed with keyPrefix
+func transformInvocations(stats map[string]interface{}, keyPrefix string) map[string]interface{} {
+	// Although stats are interface{}, those values from cloudwatch.Datapoint are guaranteed to be float64.
+	if totalCount, ok := stats[keyPrefix+"invocations_total"].(float64); ok {
+		if errorCount, ok := stats[keyPrefix+"invocations_error"].(float64); ok {
+			stats[keyPrefix+"invocations_success"] = totalCount - errorCount
+		} else {
+			stats[keyPrefix+"invocations_success"] = totalCount
 		}
+		delete(stats, keyPrefix+"invocations_total")
 	}
 	return stats
 }
 
 var lambdaMetricsGroup = []metricsGroup{
-	{CloudWatchName: "Invocations", Metrics: []metric{
+	{CloudWatchName: "Invocations", Graph: "invocations", Metrics: []metric{
 		{MackerelName: "invocations_total", Type: metricsTypeSum},
 	}},
-	{CloudWatchName: "Errors", Metrics: []metric{
+	{CloudWatchName: "Errors", Graph: "invocations", Metrics: []metric{
 		{MackerelName: "invocations_error", Type: metricsTypeSum},
 	}},
-	{CloudWatchName: "DeadLetterErrors", Metrics: []metric{
+	{CloudWatchName: "DeadLetterErrors", Graph: "dead_letters", Metrics: []metric{
 		{MackerelName: "dead_letter_errors", Type: metricsTypeSum},
 	}},
-	{CloudWatchName: "Throttles", Metrics: []metric{
+	{CloudWatchName: "Throttles", Graph: "invocations", Metrics: []metric{
 		{MackerelName: "invocations_throttles", Type: metricsTypeSum},
 	}},
-	{CloudWatchName: "Duration", Metrics: []metric{
+	{CloudWatchName: "Duration", Graph: "duration", Metrics: []metric{
 		{MackerelName: "duration_avg", Type: metricsTypeAverage},
 		{MackerelName: "duration_max", Type: metricsTypeMaximum},
 		{MackerelName: "duration_min", Type: metricsTypeMinimum},
 	}},
 }
 
+// accountMetricsGroup is posted only across all functions, since UnreservedConcurrentExecutions has no dimensions
+var accountMetricsGroup = []metricsGroup{
+	{CloudWatchName: "ConcurrentExecutions", Graph: "concurrent_executions", Metrics: []metric{
+		{MackerelName: "concurrent_executions", Type: metricsTypeMaximum},
+	}},
+	{CloudWatchName: "UnreservedConcurrentExecutions", Graph: "concurrent_executions", Metrics: []metric{
+		{MackerelName: "unreserved_concurrent_executions", Type: metricsTypeMaximum},
+	}},
+}
+
+// streamMetricsGroup is for the functions triggered by Kinesis or DynamoDB streams
+var streamMetricsGroup = []metricsGroup{
+	{CloudWatchName: "IteratorAge", Graph: "iterator_age", Metrics: []metric{
+		{MackerelName: "iterator_age_avg", Type: metricsTypeAverage},
+		{MackerelName: "iterator_age_max", Type: metricsTypeMaximum},
+		{MackerelName: "iterator_age_p90", Type: "p90"},
+		{MackerelName: "iterator_age_p99", Type: "p99"},
+	}},
+	{CloudWatchName: "DeadLetterErrors", Graph: "dead_letters_percentile", Metrics: []metric{
+		{MackerelName: "dead_letter_errors_p90", Type: "p90"},
+		{MackerelName: "dead_letter_errors_p99", Type: "p99"},
+	}},
+	{CloudWatchName: "Throttles", Graph: "throttles_percentile", Metrics: []metric{
+		{MackerelName: "invocations_throttles_p90", Type: "p90"},
+		{MackerelName: "invocations_throttles_p99", Type: "p99"},
+	}},
+}
+
+func (p LambdaPlugin) metricsGroups() []metricsGroup {
+	groups := append([]metricsGroup{}, lambdaMetricsGroup...)
+	if p.account() {
+		groups = append(groups, accountMetricsGroup...)
+	}
+	if p.Stream {
+		groups = append(groups, streamMetricsGroup...)
+	}
+	return groups
+}
+
 // FetchMetrics fetch the metrics
 func (p LambdaPlugin) FetchMetrics() (map[string]interface{}, error) {
-	stats := make(map[string]interface{})
-
-	for _, met := range lambdaMetricsGroup {
-		v, err := getLastPointFromCloudWatch(p.CloudWatch, p.FunctionName, met)
-		if err == nil {
-			stats = mergeStatsFromDatapoint(stats, v, met)
-		} else {
-			log.Printf("%s: %s", met, err)
+	groups := p.metricsGroups()
+	if !p.discovery() {
+		stats, err := fetch(p.CloudWatch, buildQueries(dimensions(p.FunctionName, p.Qualifier), groups, ""))
+		if err != nil {
+			return nil, err
 		}
+		return transformMetrics(stats), nil
 	}
-	return transformMetrics(stats), nil
+
+	var queries []awsplugin.MetricQuery
+	for _, f := range p.functions {
+		queries = append(queries, buildQueries(dimensions(f, p.Qualifier), groups, metricNameRe.ReplaceAllString(f, "_"))...)
+	}
+	stats, err := fetch(p.CloudWatch, queries)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range p.functions {
+		transformInvocations(stats, "invocations."+metricNameRe.ReplaceAllString(f, "_")+".")
+	}
+	return stats, nil
 }
 
 // GraphDefinition of LambdaPlugin
@@ -215,26 +290,77 @@ func (p LambdaPlugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	if p.account() {
+		graphdef["concurrent_executions"] = mp.Graphs{
+			Label: (labelPrefix + " Concurrent Executions"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "concurrent_executions", Label: "Concurrent"},
+				{Name: "unreserved_concurrent_executions", Label: "Unreserved Concurrent"},
+			},
+		}
+	}
+	if p.Stream {
+		graphdef["iterator_age"] = mp.Graphs{
+			Label: (labelPrefix + " Iterator Age"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "iterator_age_avg", Label: "Average"},
+				{Name: "iterator_age_max", Label: "Maximum"},
+				{Name: "iterator_age_p90", Label: "90th percentile"},
+				{Name: "iterator_age_p99", Label: "99th percentile"},
+			},
+		}
+		graphdef["dead_letters_percentile"] = mp.Graphs{
+			Label: (labelPrefix + " Dead Letter Errors Percentile"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "dead_letter_errors_p90", Label: "90th percentile"},
+				{Name: "dead_letter_errors_p99", Label: "99th percentile"},
+			},
+		}
+		graphdef["throttles_percentile"] = mp.Graphs{
+			Label: (labelPrefix + " Throttles Percentile"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "invocations_throttles_p90", Label: "90th percentile"},
+				{Name: "invocations_throttles_p99", Label: "99th percentile"},
+			},
+		}
+	}
+	if p.discovery() {
+		// the metrics are posted per function, such as "invocations.<function>.invocations_success"
+		wildcard := make(map[string]mp.Graphs, len(graphdef))
+		for key, g := range graphdef {
+			wildcard[key+".#"] = g
+		}
+		return wildcard
+	}
 	return graphdef
 }
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
-	optRegion := flag.String("region", "", "AWS Region")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optFunctionName := flag.String("function-name", "", "Function Name")
+	optQualifier := flag.String("qualifier", "", "Alias or version of the functions")
+	optFunctionNamePrefix := flag.String("function-name-prefix", "", "Fetch the metrics of the functions whose names start with the prefix")
+	tags := make(tagFilters)
+	flag.Var(tags, "tag", "Fetch the metrics of the functions with the tag `key=value` (can be specified multiple times)")
+	optStream := flag.Bool("stream", false, "Fetch the metrics of the functions triggered by Kinesis or DynamoDB streams")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optPrefix := flag.String("metric-key-prefix", "lambda", "Metric key prefix")
 	flag.Parse()
 
 	var plugin LambdaPlugin
-
-	plugin.AccessKeyID = *optAccessKeyID
-	plugin.SecretAccessKey = *optSecretAccessKey
-	plugin.Region = *optRegion
-
+	plugin.SessionOptions = sessionOpts
 	plugin.FunctionName = *optFunctionName
+	plugin.Qualifier = *optQualifier
+	plugin.FunctionNamePrefix = *optFunctionNamePrefix
+	plugin.Tags = tags
+	plugin.Stream = *optStream
 	plugin.Prefix = *optPrefix
 
 	err := plugin.prepare()
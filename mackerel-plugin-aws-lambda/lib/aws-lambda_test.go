@@ -2,12 +2,15 @@ package mpawslambda
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/stretchr/testify/assert"
 )
@@ -24,14 +27,14 @@ func ExampleLambdaPlugin_GraphDefinition() {
 
 	// Output:
 	// # mackerel-agent-plugin
-	// {"graphs":{"lambda.dead_letters":{"label":"Lambda Dead Letter","unit":"integer","metrics":[{"name":"dead_letter_errors","label":"Errors","stacked":false}]},"lambda.duration":{"label":"Lambda Duration","unit":"float","metrics":[{"name":"duration_avg","label":"Average","stacked":false},{"name":"duration_max","label":"Maximum","stacked":false},{"name":"duration_min","label":"Minimum","stacked":false}]},"lambda.invocations":{"label":"Lambda Invocations","unit":"integer","metrics":[{"name":"invocations_success","label":"Success","stacked":false},{"name":"invocations_error","label":"Error","stacked":false},{"name":"invocations_throttles","label":"Throttles","stacked":false}]}}}
+	// {"graphs":{"lambda.concurrent_executions":{"label":"Lambda Concurrent Executions","unit":"integer","metrics":[{"name":"concurrent_executions","label":"Concurrent","stacked":false},{"name":"unreserved_concurrent_executions","label":"Unreserved Concurrent","stacked":false}]},"lambda.dead_letters":{"label":"Lambda Dead Letter","unit":"integer","metrics":[{"name":"dead_letter_errors","label":"Errors","stacked":false}]},"lambda.duration":{"label":"Lambda Duration","unit":"float","metrics":[{"name":"duration_avg","label":"Average","stacked":false},{"name":"duration_max","label":"Maximum","stacked":false},{"name":"duration_min","label":"Minimum","stacked":false}]},"lambda.invocations":{"label":"Lambda Invocations","unit":"integer","metrics":[{"name":"invocations_success","label":"Success","stacked":false},{"name":"invocations_error","label":"Error","stacked":false},{"name":"invocations_throttles","label":"Throttles","stacked":false}]}}}
 }
 
 func TestPrepare(t *testing.T) {
 	p2 := defaultLambda()
 	p2.Region = "MySuperRegion"
 	p2.prepare()
-	assert.Equal(t, "MySuperRegion", *p2.CloudWatch.Config.Region, "Specified region is used")
+	assert.Equal(t, "MySuperRegion", *p2.CloudWatch.(*cloudwatch.CloudWatch).Config.Region, "Specified region is used")
 
 	// XXX Maybe we should test around AccesKeyID?
 }
@@ -80,155 +83,185 @@ type mockCloudWatchClient struct {
 	RequestedCount int
 }
 
-func (m *mockCloudWatchClient) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
 	m.RequestedCount++
-	// Returns error unless expected payload
 
-	// Check `Dimensions`
-	expectedDimensions := []*cloudwatch.Dimension{
-		{
-			Name:  aws.String("FunctionName"),
-			Value: aws.String("myFunction"),
-		},
-	}
-	if input.Dimensions != nil && !assert.ObjectsAreEqual(expectedDimensions, input.Dimensions) {
-		return nil, errors.New("Unexpected Dimension")
-	}
+	output := new(cloudwatch.GetMetricDataOutput)
+	now := time.Now()
+	for _, q := range input.MetricDataQueries {
+		// Returns error unless expected payload
 
-	// Check `Statistics` for given `MetricName`
-	var expectedStatistics []*string
-	switch *input.MetricName {
-	case "Duration":
-		expectedStatistics = []*string{aws.String("Average"), aws.String("Maximum"), aws.String("Minimum")}
-	default:
-		expectedStatistics = []*string{aws.String("Sum")}
-	}
-	if !assert.ObjectsAreEqual(expectedStatistics, input.Statistics) {
-		return nil, errors.New("Wrong Statistics")
-	}
+		// Check `Dimensions`
+		expectedDimensions := []*cloudwatch.Dimension{
+			{
+				Name:  aws.String("FunctionName"),
+				Value: aws.String("myFunction"),
+			},
+		}
+		dimensions := q.MetricStat.Metric.Dimensions
+		if dimensions != nil && !assert.ObjectsAreEqual(expectedDimensions, dimensions) {
+			return nil, errors.New("Unexpected Dimension")
+		}
 
-	// Construct Mock Response
-	now := time.Now()
-	output := new(cloudwatch.GetMetricStatisticsOutput)
-	output.Label = input.MetricName
-	switch *output.Label {
-	case "Duration":
-		output.Datapoints = []*cloudwatch.Datapoint{
-			{Average: aws.Float64(30.0), Maximum: aws.Float64(50.0), Minimum: aws.Float64(10.0), Timestamp: aws.Time(now)},
-			{Average: aws.Float64(25.0), Maximum: aws.Float64(45.0), Minimum: aws.Float64(5.0), Timestamp: aws.Time(now.Add(time.Duration(60) * time.Second * +1))},
-			{Average: aws.Float64(35.0), Maximum: aws.Float64(55.0), Minimum: aws.Float64(15.0), Timestamp: aws.Time(now.Add(time.Duration(60) * time.Second * -1))},
+		// Construct Mock Response
+		var values []float64
+		switch *q.MetricStat.Metric.MetricName + "." + *q.MetricStat.Stat {
+		case "Duration.Average":
+			values = []float64{25.0, 30.0, 35.0}
+		case "Duration.Maximum":
+			values = []float64{45.0, 50.0, 55.0}
+		case "Duration.Minimum":
+			values = []float64{5.0, 10.0, 15.0}
+		case "Invocations.Sum":
+			values = []float64{150.0, 140.0, 130.0}
+		case "Errors.Sum":
+			values = []float64{30.0, 20.0, 10.0}
+		case "DeadLetterErrors.Sum":
+			// no datapoints
+		case "Throttles.Sum":
+			values = []float64{25.0, 30.0, 35.0}
+		default:
+			return nil, errors.New("Wrong Statistics")
 		}
-	default:
-		output.Datapoints = []*cloudwatch.Datapoint{
-			{Sum: aws.Float64(30.0), Timestamp: aws.Time(now)},
-			{Sum: aws.Float64(25.0), Timestamp: aws.Time(now.Add(time.Duration(60) * time.Second * +1))},
-			{Sum: aws.Float64(35.0), Timestamp: aws.Time(now.Add(time.Duration(60) * time.Second * -1))},
+		result := &cloudwatch.MetricDataResult{Id: q.Id}
+		for i, v := range values {
+			result.Timestamps = append(result.Timestamps, aws.Time(now.Add(time.Duration(-60*i)*time.Second)))
+			result.Values = append(result.Values, aws.Float64(v))
 		}
+		output.MetricDataResults = append(output.MetricDataResults, result)
 	}
 	return output, nil
 }
 
-func TestGetLastPointFromCloudWatch(t *testing.T) {
+func TestFetchMetrics(t *testing.T) {
 	mockCw := &mockCloudWatchClient{}
 
-	dp0, err := getLastPointFromCloudWatch(mockCw, "myFunction",
-		metricsGroup{CloudWatchName: "Throttles", Metrics: []metric{
-			{MackerelName: "invocations_throttles", Type: metricsTypeSum},
-		}})
+	stats, err := fetchMetrics(mockCw, "myFunction")
 	if err != nil {
-		t.Errorf("getLastPointFromCloudWatch fails: %s", err)
+		t.Errorf("fetchMetrics fails: %s", err)
 	} else {
 		assert.Equal(t,
-			&cloudwatch.Datapoint{Sum: aws.Float64(25.0), Timestamp: dp0.Timestamp},
-			dp0,
-			"Can request Single statistics")
+			map[string]interface{}{
+				"invocations_total":     150.0,
+				"invocations_error":     30.0,
+				"invocations_throttles": 25.0,
+				"duration_avg":          25.0,
+				"duration_max":          45.0,
+				"duration_min":          5.0,
+			},
+			stats,
+			"Can fetch the latest datapoints of multiple statistics")
 	}
 
-	dp1, err := getLastPointFromCloudWatch(mockCw, "myFunction",
-		metricsGroup{CloudWatchName: "Duration", Metrics: []metric{
-			{MackerelName: "duration_avg", Type: metricsTypeAverage},
-			{MackerelName: "duration_max", Type: metricsTypeMaximum},
-			{MackerelName: "duration_min", Type: metricsTypeMinimum},
-		}})
+	_, err = fetchMetrics(mockCw, "")
 	if err != nil {
-		t.Errorf("getLastPointFromCloudWatch fails: %s", err)
-	} else {
-		assert.Equal(t,
-			&cloudwatch.Datapoint{Average: aws.Float64(25.0), Maximum: aws.Float64(45.0), Minimum: aws.Float64(5.0), Timestamp: dp1.Timestamp},
-			dp1,
-			"Can request multiple statistics at once")
+		t.Errorf("fetchMetrics fails: %s", err)
 	}
 
-	dp2, err := getLastPointFromCloudWatch(mockCw, "",
-		metricsGroup{CloudWatchName: "Throttles", Metrics: []metric{
-			{MackerelName: "invocations_throttles", Type: metricsTypeSum},
-		}})
-	if err != nil {
-		t.Errorf("getLastPointFromCloudWatch fails: %s", err)
-	} else {
-		assert.Equal(t,
-			&cloudwatch.Datapoint{Sum: aws.Float64(25.0), Timestamp: dp2.Timestamp},
-			dp2,
-			"works even if function-name is empty")
-	}
+	assert.Equal(t, 2, mockCw.RequestedCount, "CloudWatch request is done once per call")
+}
 
-	assert.Equal(t, 3, mockCw.RequestedCount, "CloudWatch request is done once per call")
+type mockLambdaClient struct {
+	lambdaiface.LambdaAPI
 }
 
-func TestMergeStatsFromDatapoint(t *testing.T) {
-	stats := make(map[string]interface{})
-	dp := cloudwatch.Datapoint{
-		Average:   aws.Float64(25.0),
-		Maximum:   aws.Float64(45.0),
-		Minimum:   aws.Float64(5.0),
-		Sum:       aws.Float64(500.0),
-		Timestamp: aws.Time(time.Now()),
+func (m *mockLambdaClient) ListFunctionsPages(input *lambda.ListFunctionsInput, fn func(*lambda.ListFunctionsOutput, bool) bool) error {
+	fn(&lambda.ListFunctionsOutput{
+		Functions: []*lambda.FunctionConfiguration{
+			{FunctionName: aws.String("app-worker"), FunctionArn: aws.String("arn:aws:lambda:ap-northeast-1:123456789012:function:app-worker")},
+			{FunctionName: aws.String("app-api"), FunctionArn: aws.String("arn:aws:lambda:ap-northeast-1:123456789012:function:app-api")},
+			{FunctionName: aws.String("batch"), FunctionArn: aws.String("arn:aws:lambda:ap-northeast-1:123456789012:function:batch")},
+		},
+	}, true)
+	return nil
+}
+
+func (m *mockLambdaClient) ListTags(input *lambda.ListTagsInput) (*lambda.ListTagsOutput, error) {
+	tags := map[string]*string{"service": aws.String("app")}
+	if strings.HasSuffix(aws.StringValue(input.Resource), ":app-api") {
+		tags["role"] = aws.String("api")
 	}
+	return &lambda.ListTagsOutput{Tags: tags}, nil
+}
 
-	stats = mergeStatsFromDatapoint(stats,
-		&dp,
-		metricsGroup{CloudWatchName: "Invocations", Metrics: []metric{
-			{MackerelName: "invocations_total", Type: metricsTypeSum},
-		}})
+func TestListFunctions(t *testing.T) {
+	functions, err := listFunctions(&mockLambdaClient{}, "app-", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app-api", "app-worker"}, functions)
 
-	assert.Equal(t,
-		map[string]interface{}{
-			"invocations_total": 500.0,
-		},
-		stats,
-		"Can merge single stat",
-	)
+	functions, err = listFunctions(&mockLambdaClient{}, "", tagFilters{"service": "app", "role": ""})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"app-api"}, functions)
+}
 
-	stats = mergeStatsFromDatapoint(stats,
-		nil,
-		metricsGroup{CloudWatchName: "DeadLetterErrors", Metrics: []metric{
-			{MackerelName: "dead_letter_errors", Type: metricsTypeSum},
-		}})
+type mockDiscoveryCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+	dimensions [][]*cloudwatch.Dimension
+}
 
-	assert.Equal(t,
-		map[string]interface{}{
-			"invocations_total": 500.0,
-		},
-		stats,
-		"Does not panic on nil",
-	)
-
-	stats = mergeStatsFromDatapoint(stats,
-		&dp,
-		metricsGroup{CloudWatchName: "Duration", Metrics: []metric{
-			{MackerelName: "duration_avg", Type: metricsTypeAverage},
-			{MackerelName: "duration_max", Type: metricsTypeMaximum},
-			{MackerelName: "duration_min", Type: metricsTypeMinimum},
-		}})
+func (m *mockDiscoveryCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	output := new(cloudwatch.GetMetricDataOutput)
+	for _, q := range input.MetricDataQueries {
+		m.dimensions = append(m.dimensions, q.MetricStat.Metric.Dimensions)
+		var value float64
+		switch *q.MetricStat.Metric.MetricName {
+		case "Invocations":
+			value = 100
+		case "Errors":
+			value = 10
+		case "IteratorAge":
+			value = 2000
+		default:
+			value = 1
+		}
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         q.Id,
+			Timestamps: []*time.Time{input.EndTime},
+			Values:     []*float64{aws.Float64(value)},
+		})
+	}
+	return output, nil
+}
 
-	assert.Equal(t,
-		map[string]interface{}{
-			"invocations_total": 500.0,
-			"duration_avg":      25.0,
-			"duration_max":      45.0,
-			"duration_min":      5.0,
-		},
-		stats,
-		"Can merge already existing stats / can merge multiple stats at once",
-	)
+func TestFetchMetricsDiscovery(t *testing.T) {
+	cw := &mockDiscoveryCloudWatchClient{}
+	p := LambdaPlugin{
+		Prefix:             "lambda",
+		FunctionNamePrefix: "app-",
+		Qualifier:          "live",
+		Stream:             true,
+		CloudWatch:         cw,
+		functions:          []string{"app-api", "app-worker"},
+	}
+	stats, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, 90.0, stats["invocations.app-api.invocations_success"])
+	assert.Equal(t, 10.0, stats["invocations.app-worker.invocations_error"])
+	assert.Equal(t, 2000.0, stats["iterator_age.app-worker.iterator_age_p99"])
+	assert.Equal(t, 1.0, stats["throttles_percentile.app-api.invocations_throttles_p90"])
+	_, ok := stats["invocations.app-api.invocations_total"]
+	assert.False(t, ok, "invocations_total is transformed to invocations_success")
+	_, ok = stats["concurrent_executions.app-api.concurrent_executions"]
+	assert.False(t, ok, "the account metrics are not fetched per function")
+	assert.Equal(t, []*cloudwatch.Dimension{
+		{Name: aws.String("FunctionName"), Value: aws.String("app-api")},
+		{Name: aws.String("Resource"), Value: aws.String("app-api:live")},
+	}, cw.dimensions[0])
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "invocations.#")
+	assert.Contains(t, graphdef, "iterator_age.#")
+	assert.NotContains(t, graphdef, "concurrent_executions.#")
+}
+
+func TestFetchMetricsAccount(t *testing.T) {
+	p := LambdaPlugin{
+		Prefix:     "lambda",
+		CloudWatch: &mockDiscoveryCloudWatchClient{},
+	}
+	stats, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, stats["concurrent_executions"])
+	assert.Equal(t, 1.0, stats["unreserved_concurrent_executions"])
+	assert.Equal(t, 90.0, stats["invocations_success"])
 }
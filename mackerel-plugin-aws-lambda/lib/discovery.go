@@ -0,0 +1,87 @@
+package mpawslambda
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// tagFilters is a flag.Value of "-tag key=value", which can be specified multiple times
+type tagFilters map[string]string
+
+func (t tagFilters) String() string {
+	var filters []string
+	for k, v := range t {
+		filters = append(filters, k+"="+v)
+	}
+	sort.Strings(filters)
+	return strings.Join(filters, ",")
+}
+
+// Set adds a filter. The function matches to "key" if it has the tag key with any value
+func (t tagFilters) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if kv[0] == "" {
+		return fmt.Errorf("invalid tag filter: %q", s)
+	}
+	if len(kv) == 1 {
+		t[kv[0]] = ""
+	} else {
+		t[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+func (t tagFilters) match(tags map[string]*string) bool {
+	for key, value := range t {
+		v, ok := tags[key]
+		if !ok || (value != "" && aws.StringValue(v) != value) {
+			return false
+		}
+	}
+	return true
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// listFunctions returns the names of the functions which start with prefix and have the tags
+func listFunctions(svc lambdaiface.LambdaAPI, prefix string, tags tagFilters) ([]string, error) {
+	var arns = make(map[string]string)
+	var names []string
+	err := svc.ListFunctionsPages(&lambda.ListFunctionsInput{}, func(output *lambda.ListFunctionsOutput, lastPage bool) bool {
+		for _, f := range output.Functions {
+			name := aws.StringValue(f.FunctionName)
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			arns[name] = aws.StringValue(f.FunctionArn)
+			names = append(names, name)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ListFunctions does not return the tags, so they are fetched per function
+	if len(tags) > 0 {
+		var tagged []string
+		for _, name := range names {
+			output, err := svc.ListTags(&lambda.ListTagsInput{Resource: aws.String(arns[name])})
+			if err != nil {
+				return nil, err
+			}
+			if tags.match(output.Tags) {
+				tagged = append(tagged, name)
+			}
+		}
+		names = tagged
+	}
+	sort.Strings(names)
+	return names, nil
+}
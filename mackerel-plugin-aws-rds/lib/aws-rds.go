@@ -8,92 +8,125 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 // RDSPlugin mackerel plugin for amazon RDS
 type RDSPlugin struct {
-	Region          string
-	AccessKeyID     string
-	SecretAccessKey string
-	Identifier      string
-	Engine          string
-	Prefix          string
-	LabelPrefix     string
+	awsplugin.SessionOptions
+	Identifier  string
+	Engine      string
+	Prefix      string
+	LabelPrefix string
+
+	// ClusterIdentifier and Tags discover the instances instead of Identifier
+	ClusterIdentifier  string
+	Tags               map[string]string
+	EnhancedMonitoring bool
+
+	RDS            rdsiface.RDSAPI
+	CloudWatch     cloudwatchiface.CloudWatchAPI
+	CloudWatchLogs cloudwatchlogsiface.CloudWatchLogsAPI
+
+	instances []dbInstance
 }
 
-func getLastPoint(cloudWatch *cloudwatch.CloudWatch, dimension *cloudwatch.Dimension, metricName string) (float64, error) {
-	now := time.Now()
-
-	response, err := cloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Dimensions: []*cloudwatch.Dimension{dimension},
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)), // 3 min (to fetch at least 1 data-point)
-		EndTime:    aws.Time(now),
-		MetricName: aws.String(metricName),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String("Average")},
-		Namespace:  aws.String("AWS/RDS"),
-	})
-	if err != nil {
-		return 0, err
+// discovery is whether the metrics are of the multiple instances
+func (p RDSPlugin) discovery() bool {
+	return p.ClusterIdentifier != "" || len(p.Tags) > 0
+}
+
+func (p *RDSPlugin) prepare() error {
+	if p.discovery() && p.Identifier != "" {
+		return errors.New("-identifier cannot be used with -cluster-identifier and -tag")
+	}
+	if !p.discovery() && p.Identifier == "" {
+		return errors.New("-identifier, -cluster-identifier or -tag is required")
 	}
 
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
+	sess, err := p.NewSession()
+	if err != nil {
+		return err
 	}
+	p.RDS = rds.New(sess)
+	p.CloudWatch = cloudwatch.New(sess)
+	p.CloudWatchLogs = cloudwatchlogs.New(sess)
 
-	latest := new(time.Time)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(*latest) {
-			continue
+	if !p.discovery() && !p.EnhancedMonitoring {
+		p.instances = []dbInstance{{Identifier: p.Identifier, Engine: p.Engine}}
+		return nil
+	}
+	p.instances, err = describeInstances(p.RDS, p.Identifier, p.ClusterIdentifier, p.Tags)
+	if err != nil {
+		return err
+	}
+	if len(p.instances) == 0 {
+		return errors.New("no DB instances found")
+	}
+	if p.Engine == "" && !p.discovery() {
+		p.Engine = p.instances[0].Engine
+	}
+	if p.Engine != "" {
+		for i := range p.instances {
+			p.instances[i].Engine = p.Engine
 		}
-
-		latest = dp.Timestamp
-		latestVal = *dp.Average
 	}
-
-	return latestVal, nil
+	return nil
 }
 
 // FetchMetrics interface for mackerel-plugin
 func (p RDSPlugin) FetchMetrics() (map[string]float64, error) {
-	sess, err := session.NewSession()
+	var queries []awsplugin.MetricQuery
+	for _, i := range p.instances {
+		dimensions := []*cloudwatch.Dimension{{
+			Name:  aws.String("DBInstanceIdentifier"),
+			Value: aws.String(i.Identifier),
+		}}
+		for met, graph := range p.rdsMetrics(i.Engine) {
+			key := met
+			if p.discovery() {
+				key = graph + "." + i.metricName() + "." + met
+			}
+			queries = append(queries, awsplugin.MetricQuery{
+				Key:        key,
+				Namespace:  "AWS/RDS",
+				MetricName: met,
+				Dimensions: dimensions,
+				Statistic:  "Average",
+			})
+		}
+	}
+	stat, err := awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
 	if err != nil {
 		return nil, err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	if p.Region != "" {
-		config = config.WithRegion(p.Region)
-	}
-
-	cloudWatch := cloudwatch.New(sess, config)
-
-	stat := make(map[string]float64)
-
-	perInstance := &cloudwatch.Dimension{
-		Name:  aws.String("DBInstanceIdentifier"),
-		Value: aws.String(p.Identifier),
-	}
-
-	for _, met := range p.rdsMetrics() {
-		v, err := getLastPoint(cloudWatch, perInstance, met)
-		if err == nil {
-			stat[met] = v
-		} else {
-			log.Printf("%s: %s", met, err)
+	if p.EnhancedMonitoring {
+		now := time.Now()
+		for _, i := range p.instances {
+			if !i.EnhancedMonitoring {
+				continue
+			}
+			m, err := fetchOSMetrics(p.CloudWatchLogs, i, now)
+			if err != nil {
+				log.Printf("failed to fetch Enhanced Monitoring metrics of %s: %s", i.Identifier, err)
+				continue
+			}
+			if m == nil {
+				continue
+			}
+			for k, v := range m.stats(p.Prefix, i.metricName()) {
+				stat[k] = v
+			}
 		}
 	}
-
 	return stat, nil
 }
 
@@ -192,10 +225,9 @@ func (p RDSPlugin) baseGraphDefs() map[string]mp.Graphs {
 	}
 }
 
-// GraphDefinition interface for mackerel plugin
-func (p RDSPlugin) GraphDefinition() map[string]mp.Graphs {
+func (p RDSPlugin) engineGraphDefs(engine string) map[string]mp.Graphs {
 	graphdef := p.baseGraphDefs()
-	switch p.Engine {
+	switch engine {
 	case "mysql", "mariadb":
 		graphdef = mergeGraphDefs(graphdef, p.mySQLGraphDefinition())
 	case "postgresql":
@@ -206,12 +238,58 @@ func (p RDSPlugin) GraphDefinition() map[string]mp.Graphs {
 	return graphdef
 }
 
+// mergeMetrics returns the graph a with the metrics of b not in a
+func mergeMetrics(a, b mp.Graphs) mp.Graphs {
+	names := make(map[string]bool, len(a.Metrics))
+	metrics := make([]mp.Metrics, 0, len(a.Metrics)+len(b.Metrics))
+	for _, m := range a.Metrics {
+		names[m.Name] = true
+		metrics = append(metrics, m)
+	}
+	for _, m := range b.Metrics {
+		if !names[m.Name] {
+			names[m.Name] = true
+			metrics = append(metrics, m)
+		}
+	}
+	a.Metrics = metrics
+	return a
+}
+
+// GraphDefinition interface for mackerel plugin
+func (p RDSPlugin) GraphDefinition() map[string]mp.Graphs {
+	var graphdef map[string]mp.Graphs
+	if p.discovery() {
+		// the graphs of all the engines of the instances, with the wildcard of the instance identifiers
+		graphdef = make(map[string]mp.Graphs)
+		for _, i := range p.instances {
+			for k, v := range p.engineGraphDefs(i.Engine) {
+				// the engines share some graph names with the different metrics, such as Latency of aurora
+				if g, ok := graphdef[k+".#"]; ok {
+					v = mergeMetrics(g, v)
+				}
+				graphdef[k+".#"] = v
+			}
+		}
+	} else {
+		graphdef = p.engineGraphDefs(p.Engine)
+	}
+	if p.EnhancedMonitoring {
+		graphdef = mergeGraphDefs(graphdef, p.osGraphDefs(p.instances))
+	}
+	return graphdef
+}
+
 // Do the plugin
 func Do() {
-	optRegion := flag.String("region", "", "AWS Region")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optIdentifier := flag.String("identifier", "", "DB Instance Identifier")
+	optClusterIdentifier := flag.String("cluster-identifier", "", "Aurora DB Cluster Identifier to monitor its instances")
+	tags := make(tagFilters)
+	flag.Var(tags, "tag", "Monitor the DB instances with the tag `key=value` (can be specified multiple times)")
+	optEnhancedMonitoring := flag.Bool("enhanced-monitoring", false, "Fetch the OS metrics of Enhanced Monitoring")
 	optEngine := flag.String("engine", "", "RDS Engine")
 	optPrefix := flag.String("metric-key-prefix", "rds", "Metric key prefix")
 	optLabelPrefix := flag.String("metric-label-prefix", "", "Metric Label prefix")
@@ -219,7 +297,8 @@ func Do() {
 	flag.Parse()
 
 	rds := RDSPlugin{
-		Prefix: *optPrefix,
+		SessionOptions: sessionOpts,
+		Prefix:         *optPrefix,
 	}
 	if *optLabelPrefix == "" {
 		if *optPrefix == "rds" {
@@ -231,19 +310,15 @@ func Do() {
 		rds.LabelPrefix = *optLabelPrefix
 	}
 
-	if *optRegion == "" {
-		ec2metadata := ec2metadata.New(session.New())
-		if ec2metadata.Available() {
-			rds.Region, _ = ec2metadata.Region()
-		}
-	} else {
-		rds.Region = *optRegion
-	}
-
 	rds.Identifier = *optIdentifier
-	rds.AccessKeyID = *optAccessKeyID
-	rds.SecretAccessKey = *optSecretAccessKey
 	rds.Engine = *optEngine
+	rds.ClusterIdentifier = *optClusterIdentifier
+	rds.Tags = tags
+	rds.EnhancedMonitoring = *optEnhancedMonitoring
+
+	if err := rds.prepare(); err != nil {
+		log.Fatalln(err)
+	}
 
 	helper := mp.NewMackerelPlugin(rds)
 	helper.Tempfile = *optTempfile
@@ -0,0 +1,197 @@
+package mpawsrds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRDSClient struct {
+	rdsiface.RDSAPI
+	input *rds.DescribeDBInstancesInput
+}
+
+func tag(key, value string) *rds.Tag {
+	return &rds.Tag{Key: aws.String(key), Value: aws.String(value)}
+}
+
+func (m *mockRDSClient) DescribeDBInstancesPages(input *rds.DescribeDBInstancesInput, fn func(*rds.DescribeDBInstancesOutput, bool) bool) error {
+	m.input = input
+	pages := []*rds.DescribeDBInstancesOutput{
+		{DBInstances: []*rds.DBInstance{
+			{
+				DBInstanceIdentifier: aws.String("app-db-2"),
+				Engine:               aws.String("aurora-postgresql"),
+				DbiResourceId:        aws.String("db-BBBB"),
+				MonitoringInterval:   aws.Int64(60),
+				TagList:              []*rds.Tag{tag("service", "app"), tag("env", "production")},
+			},
+			{
+				DBInstanceIdentifier: aws.String("app-db-1"),
+				Engine:               aws.String("postgres"),
+				DbiResourceId:        aws.String("db-AAAA"),
+				MonitoringInterval:   aws.Int64(0),
+				TagList:              []*rds.Tag{tag("service", "app"), tag("env", "production")},
+			},
+		}},
+		{DBInstances: []*rds.DBInstance{
+			{
+				DBInstanceIdentifier: aws.String("batch-db"),
+				Engine:               aws.String("mysql"),
+				DbiResourceId:        aws.String("db-CCCC"),
+				TagList:              []*rds.Tag{tag("service", "batch"), tag("env", "production")},
+			},
+		}},
+	}
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestDescribeInstances(t *testing.T) {
+	svc := &mockRDSClient{}
+	instances, err := describeInstances(svc, "", "", tagFilters{"service": "app", "env": ""})
+	assert.Nil(t, err)
+	assert.Equal(t, []dbInstance{
+		{Identifier: "app-db-1", Engine: "postgresql", ResourceID: "db-AAAA"},
+		{Identifier: "app-db-2", Engine: "aurora", ResourceID: "db-BBBB", EnhancedMonitoring: true},
+	}, instances)
+
+	instances, err = describeInstances(svc, "", "app-cluster", tagFilters{})
+	assert.Nil(t, err)
+	assert.Len(t, instances, 3)
+	assert.Equal(t, "db-cluster-id", aws.StringValue(svc.input.Filters[0].Name))
+	assert.Equal(t, "app-cluster", aws.StringValue(svc.input.Filters[0].Values[0]))
+}
+
+func TestTagFilters(t *testing.T) {
+	tags := make(tagFilters)
+	assert.Nil(t, tags.Set("service=app"))
+	assert.Nil(t, tags.Set("env"))
+	assert.NotNil(t, tags.Set("=app"))
+	assert.Equal(t, "env=,service=app", tags.String())
+}
+
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+}
+
+func (m *mockCloudWatchClient) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, q := range input.MetricDataQueries {
+		result := &cloudwatch.MetricDataResult{Id: q.Id}
+		if aws.StringValue(q.MetricStat.Metric.MetricName) == "CPUUtilization" {
+			result.Timestamps = []*time.Time{input.EndTime}
+			result.Values = []*float64{aws.Float64(12.5)}
+		}
+		output.MetricDataResults = append(output.MetricDataResults, result)
+	}
+	return output, nil
+}
+
+type mockCloudWatchLogsClient struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+}
+
+const osMetricsMessage = `{
+  "engine": "Aurora",
+  "instanceID": "app-db-2",
+  "instanceResourceID": "db-BBBB",
+  "timestamp": "2017-12-01T00:00:00Z",
+  "cpuUtilization": {"guest": 0, "irq": 0.02, "system": 1.5, "wait": 0.3, "idle": 90.1, "user": 7.8, "total": 9.9, "steal": 0.1, "nice": 0.2},
+  "loadAverageMinute": {"one": 0.5, "five": 0.4, "fifteen": 0.3},
+  "memory": {"total": 4000000, "free": 1000000, "cached": 2000000, "buffers": 100000, "active": 2500000, "inactive": 300000},
+  "processList": [
+    {"name": "aurora", "cpuUsedPc": 5.5, "memoryUsedPc": 40.5, "id": 1},
+    {"name": "postgres: app appdb 10.0.0.1(50000) idle", "cpuUsedPc": 1, "memoryUsedPc": 2, "id": 2},
+    {"name": "postgres: app appdb 10.0.0.2(50001) idle", "cpuUsedPc": 2, "memoryUsedPc": 3, "id": 3},
+    {"name": "OS processes", "cpuUsedPc": 0.5, "memoryUsedPc": 1.5, "id": 0}
+  ]
+}`
+
+func (m *mockCloudWatchLogsClient) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if aws.StringValue(input.LogStreamName) != "db-BBBB" {
+		return &cloudwatchlogs.GetLogEventsOutput{}, nil
+	}
+	return &cloudwatchlogs.GetLogEventsOutput{
+		Events: []*cloudwatchlogs.OutputLogEvent{{Message: aws.String(osMetricsMessage)}},
+	}, nil
+}
+
+func TestFetchMetricsDiscovery(t *testing.T) {
+	p := RDSPlugin{
+		Prefix:             "rds",
+		Tags:               map[string]string{"service": "app"},
+		EnhancedMonitoring: true,
+		CloudWatch:         &mockCloudWatchClient{},
+		CloudWatchLogs:     &mockCloudWatchLogsClient{},
+		instances: []dbInstance{
+			{Identifier: "app-db-1", Engine: "postgresql", ResourceID: "db-AAAA", EnhancedMonitoring: true},
+			{Identifier: "app-db-2", Engine: "aurora", ResourceID: "db-BBBB", EnhancedMonitoring: true},
+		},
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, 12.5, stat["rds.CPUUtilization.app-db-1.CPUUtilization"])
+	assert.Equal(t, 12.5, stat["rds.CPUUtilization.app-db-2.CPUUtilization"])
+	assert.Equal(t, 7.8, stat["rds.os.cpu.app-db-2.user"])
+	assert.Equal(t, 0.4, stat["rds.os.loadavg.app-db-2.five"])
+	assert.Equal(t, 4000000.0*1024, stat["rds.os.memory.app-db-2.total"])
+	assert.Equal(t, 5.5, stat["rds.os.process_cpu.app-db-2.aurora"])
+	assert.Equal(t, 3.0, stat["rds.os.process_cpu.app-db-2.postgres"])
+	assert.Equal(t, 5.0, stat["rds.os.process_memory.app-db-2.postgres"])
+	assert.Equal(t, 1.5, stat["rds.os.process_memory.app-db-2.OS_processes"])
+	_, ok := stat["rds.os.cpu.app-db-1.user"]
+	assert.False(t, ok, "no metrics without recent events")
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "rds.CPUUtilization.#")
+	assert.Contains(t, graphdef, "rds.MaximumUsedTransactionIDs.#", "the graphs of postgresql")
+	assert.Contains(t, graphdef, "rds.AuroraReplicaLag.#", "the graphs of aurora")
+	assert.Contains(t, graphdef, "rds.os.cpu.#")
+	assert.Contains(t, graphdef, "rds.os.process_cpu.app-db-2")
+
+	// the graphs of the same name are merged over the engines
+	var latency []string
+	for _, m := range graphdef["rds.Latency.#"].Metrics {
+		latency = append(latency, m.Name)
+	}
+	assert.Contains(t, latency, "ReadLatency", "the latency of postgresql")
+	assert.Contains(t, latency, "WriteLatency", "the latency of postgresql")
+	assert.Contains(t, latency, "SelectLatency", "the latency of aurora")
+	assert.Contains(t, latency, "CommitLatency", "the latency of aurora")
+	assert.Len(t, latency, len(unique(latency)))
+}
+
+func unique(names []string) map[string]bool {
+	m := make(map[string]bool)
+	for _, n := range names {
+		m[n] = true
+	}
+	return m
+}
+
+func TestFetchMetricsSingle(t *testing.T) {
+	p := RDSPlugin{
+		Prefix:     "rds",
+		Identifier: "db",
+		Engine:     "mysql",
+		CloudWatch: &mockCloudWatchClient{},
+		instances:  []dbInstance{{Identifier: "db", Engine: "mysql"}},
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"CPUUtilization": 12.5}, stat)
+	assert.Contains(t, p.GraphDefinition(), "rds.BinLogDiskUsage")
+}
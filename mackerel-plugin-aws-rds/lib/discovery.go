@@ -0,0 +1,121 @@
+package mpawsrds
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+)
+
+// tagFilters is a flag.Value of "-tag key=value", which can be specified multiple times
+type tagFilters map[string]string
+
+func (t tagFilters) String() string {
+	var filters []string
+	for k, v := range t {
+		filters = append(filters, k+"="+v)
+	}
+	sort.Strings(filters)
+	return strings.Join(filters, ",")
+}
+
+// Set adds a filter. The instance matches to "key" if it has the tag key with any value
+func (t tagFilters) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if kv[0] == "" {
+		return fmt.Errorf("invalid tag filter: %q", s)
+	}
+	if len(kv) == 1 {
+		t[kv[0]] = ""
+	} else {
+		t[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+func (t tagFilters) match(tags []*rds.Tag) bool {
+	for key, value := range t {
+		found := false
+		for _, tag := range tags {
+			if aws.StringValue(tag.Key) == key && (value == "" || aws.StringValue(tag.Value) == value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// dbInstance is a monitored DB instance
+type dbInstance struct {
+	Identifier string
+	// Engine is the engine name of the plugin: mysql, mariadb, postgresql or aurora
+	Engine string
+	// ResourceID is the log stream name of Enhanced Monitoring
+	ResourceID string
+	// EnhancedMonitoring is whether Enhanced Monitoring is enabled on the instance
+	EnhancedMonitoring bool
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// metricName returns the identifier usable as a part of metric names
+func (i dbInstance) metricName() string {
+	return metricNameRe.ReplaceAllString(i.Identifier, "_")
+}
+
+// pluginEngine converts the engine of RDS API to the one of -engine
+func pluginEngine(engine string) string {
+	switch {
+	case strings.HasPrefix(engine, "aurora"):
+		return "aurora"
+	case engine == "postgres":
+		return "postgresql"
+	}
+	return engine
+}
+
+// describeInstances returns the DB instances of the identifier,
+// or the instances in the Aurora cluster and/or with the tags when identifier is empty
+func describeInstances(svc rdsiface.RDSAPI, identifier, clusterIdentifier string, tags tagFilters) ([]dbInstance, error) {
+	input := &rds.DescribeDBInstancesInput{}
+	if identifier != "" {
+		input.DBInstanceIdentifier = aws.String(identifier)
+	}
+	if clusterIdentifier != "" {
+		input.Filters = []*rds.Filter{{
+			Name:   aws.String("db-cluster-id"),
+			Values: []*string{aws.String(clusterIdentifier)},
+		}}
+	}
+
+	var instances []dbInstance
+	err := svc.DescribeDBInstancesPages(input, func(output *rds.DescribeDBInstancesOutput, lastPage bool) bool {
+		for _, i := range output.DBInstances {
+			if !tags.match(i.TagList) {
+				continue
+			}
+			instances = append(instances, dbInstance{
+				Identifier:         aws.StringValue(i.DBInstanceIdentifier),
+				Engine:             pluginEngine(aws.StringValue(i.Engine)),
+				ResourceID:         aws.StringValue(i.DbiResourceId),
+				EnhancedMonitoring: aws.Int64Value(i.MonitoringInterval) > 0,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].Identifier < instances[j].Identifier
+	})
+	return instances, nil
+}
@@ -0,0 +1,179 @@
+package mpawsrds
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+// Enhanced Monitoring sends the OS metrics to this log group with the log stream of DbiResourceId
+const osMetricsLogGroup = "RDSOSMetrics"
+
+// the events older than this are ignored, since the monitoring interval is 60 seconds at most
+const osMetricsMaxAge = 5 * time.Minute
+
+// osMetrics is a log event of Enhanced Monitoring. The sizes of memory are in kilobytes.
+type osMetrics struct {
+	CPUUtilization struct {
+		User   float64 `json:"user"`
+		System float64 `json:"system"`
+		Wait   float64 `json:"wait"`
+		Irq    float64 `json:"irq"`
+		Nice   float64 `json:"nice"`
+		Steal  float64 `json:"steal"`
+		Guest  float64 `json:"guest"`
+		Idle   float64 `json:"idle"`
+	} `json:"cpuUtilization"`
+	LoadAverageMinute struct {
+		One     float64 `json:"one"`
+		Five    float64 `json:"five"`
+		Fifteen float64 `json:"fifteen"`
+	} `json:"loadAverageMinute"`
+	Memory struct {
+		Total    float64 `json:"total"`
+		Free     float64 `json:"free"`
+		Cached   float64 `json:"cached"`
+		Buffers  float64 `json:"buffers"`
+		Active   float64 `json:"active"`
+		Inactive float64 `json:"inactive"`
+	} `json:"memory"`
+	ProcessList []struct {
+		Name         string  `json:"name"`
+		CPUUsedPc    float64 `json:"cpuUsedPc"`
+		MemoryUsedPc float64 `json:"memoryUsedPc"`
+	} `json:"processList"`
+}
+
+func parseOSMetrics(message string) (*osMetrics, error) {
+	var m osMetrics
+	if err := json.Unmarshal([]byte(message), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// processName groups the processes like "postgres: app appdb 10.0.0.1(50000) idle" by the command
+func processName(name string) string {
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return metricNameRe.ReplaceAllString(strings.TrimSpace(name), "_")
+}
+
+// stats returns the values keyed like the graphs of osGraphDefs
+func (m *osMetrics) stats(prefix, name string) map[string]float64 {
+	stat := map[string]float64{
+		prefix + ".os.cpu." + name + ".user":        m.CPUUtilization.User,
+		prefix + ".os.cpu." + name + ".system":      m.CPUUtilization.System,
+		prefix + ".os.cpu." + name + ".wait":        m.CPUUtilization.Wait,
+		prefix + ".os.cpu." + name + ".irq":         m.CPUUtilization.Irq,
+		prefix + ".os.cpu." + name + ".nice":        m.CPUUtilization.Nice,
+		prefix + ".os.cpu." + name + ".steal":       m.CPUUtilization.Steal,
+		prefix + ".os.cpu." + name + ".guest":       m.CPUUtilization.Guest,
+		prefix + ".os.cpu." + name + ".idle":        m.CPUUtilization.Idle,
+		prefix + ".os.loadavg." + name + ".one":     m.LoadAverageMinute.One,
+		prefix + ".os.loadavg." + name + ".five":    m.LoadAverageMinute.Five,
+		prefix + ".os.loadavg." + name + ".fifteen": m.LoadAverageMinute.Fifteen,
+		prefix + ".os.memory." + name + ".total":    m.Memory.Total * 1024,
+		prefix + ".os.memory." + name + ".free":     m.Memory.Free * 1024,
+		prefix + ".os.memory." + name + ".cached":   m.Memory.Cached * 1024,
+		prefix + ".os.memory." + name + ".buffers":  m.Memory.Buffers * 1024,
+		prefix + ".os.memory." + name + ".active":   m.Memory.Active * 1024,
+		prefix + ".os.memory." + name + ".inactive": m.Memory.Inactive * 1024,
+	}
+	for _, proc := range m.ProcessList {
+		procName := processName(proc.Name)
+		if procName == "" {
+			continue
+		}
+		stat[prefix+".os.process_cpu."+name+"."+procName] += proc.CPUUsedPc
+		stat[prefix+".os.process_memory."+name+"."+procName] += proc.MemoryUsedPc
+	}
+	return stat
+}
+
+// fetchOSMetrics returns the latest Enhanced Monitoring metrics of the instance, or nil if there are no recent events
+func fetchOSMetrics(svc cloudwatchlogsiface.CloudWatchLogsAPI, instance dbInstance, now time.Time) (*osMetrics, error) {
+	output, err := svc.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(osMetricsLogGroup),
+		LogStreamName: aws.String(instance.ResourceID),
+		StartTime:     aws.Int64(now.Add(-osMetricsMaxAge).UnixNano() / int64(time.Millisecond)),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Events) == 0 {
+		return nil, nil
+	}
+	return parseOSMetrics(aws.StringValue(output.Events[len(output.Events)-1].Message))
+}
+
+func (p RDSPlugin) osGraphDefs(instances []dbInstance) map[string]mp.Graphs {
+	graphdef := map[string]mp.Graphs{
+		p.Prefix + ".os.cpu.#": {
+			Label: p.LabelPrefix + " OS CPU",
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "user", Label: "User", Stacked: true},
+				{Name: "system", Label: "System", Stacked: true},
+				{Name: "wait", Label: "Wait", Stacked: true},
+				{Name: "irq", Label: "IRQ", Stacked: true},
+				{Name: "nice", Label: "Nice", Stacked: true},
+				{Name: "steal", Label: "Steal", Stacked: true},
+				{Name: "guest", Label: "Guest", Stacked: true},
+				{Name: "idle", Label: "Idle", Stacked: true},
+			},
+		},
+		p.Prefix + ".os.loadavg.#": {
+			Label: p.LabelPrefix + " OS Load Average",
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "one", Label: "1min"},
+				{Name: "five", Label: "5min"},
+				{Name: "fifteen", Label: "15min"},
+			},
+		},
+		p.Prefix + ".os.memory.#": {
+			Label: p.LabelPrefix + " OS Memory",
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "total", Label: "Total"},
+				{Name: "free", Label: "Free"},
+				{Name: "cached", Label: "Cached"},
+				{Name: "buffers", Label: "Buffers"},
+				{Name: "active", Label: "Active"},
+				{Name: "inactive", Label: "Inactive"},
+			},
+		},
+	}
+	// the labels of "*" cannot be the process names under the wildcard of the instances,
+	// so the graphs of the processes are defined per instance
+	for _, i := range instances {
+		if !i.EnhancedMonitoring {
+			continue
+		}
+		name := i.metricName()
+		graphdef[p.Prefix+".os.process_cpu."+name] = mp.Graphs{
+			Label: p.LabelPrefix + " OS Process CPU " + i.Identifier,
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Stacked: true},
+			},
+		}
+		graphdef[p.Prefix+".os.process_memory."+name] = mp.Graphs{
+			Label: p.LabelPrefix + " OS Process Memory " + i.Identifier,
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "*", Label: "%1", Stacked: true},
+			},
+		}
+	}
+	return graphdef
+}
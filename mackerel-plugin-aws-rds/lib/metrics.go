@@ -4,13 +4,15 @@ import (
 	mp "github.com/mackerelio/go-mackerel-plugin"
 )
 
-func (p RDSPlugin) rdsMetrics() (metrics []string) {
-	for _, v := range p.GraphDefinition() {
+// rdsMetrics returns the graph names keyed by the CloudWatch metric names of the engine
+func (p RDSPlugin) rdsMetrics(engine string) map[string]string {
+	metrics := make(map[string]string)
+	for k, v := range p.engineGraphDefs(engine) {
 		for _, vv := range v.Metrics {
-			metrics = append(metrics, vv.Name)
+			metrics[vv.Name] = k
 		}
 	}
-	return
+	return metrics
 }
 
 func mergeGraphDefs(a, b map[string]mp.Graphs) map[string]mp.Graphs {
@@ -3,11 +3,14 @@ package mpawsses
 import (
 	"errors"
 	"flag"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/crowdmob/goamz/aws"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
 	mp "github.com/mackerelio/go-mackerel-plugin"
-	ses "github.com/naokibtn/go-ses"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -40,53 +43,65 @@ var graphdef = map[string]mp.Graphs{
 
 // SESPlugin mackerel plugin for Amazon SES
 type SESPlugin struct {
-	Endpoint        string
-	AccessKeyID     string
-	SecretAccessKey string
+	awsplugin.SessionOptions
+	Endpoint string
+}
+
+// regionOfEndpoint extracts the region from the endpoint like "https://email.us-west-2.amazonaws.com"
+func regionOfEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(u.Hostname(), ".")
+	if len(labels) < 3 || labels[0] != "email" {
+		return ""
+	}
+	return labels[1]
 }
 
 // FetchMetrics interface for mackerel plugin
 func (p SESPlugin) FetchMetrics() (map[string]float64, error) {
-	if p.Endpoint == "" {
-		return nil, errors.New("no endpoint")
+	if p.Region == "" && p.Endpoint != "" {
+		p.Region = regionOfEndpoint(p.Endpoint)
 	}
-
-	auth, err := aws.GetAuth(p.AccessKeyID, p.SecretAccessKey, "", time.Now())
+	sess, err := p.NewSession()
 	if err != nil {
 		return nil, err
 	}
-
-	sescfg := ses.Config{
-		AccessKeyID:     auth.AccessKey,
-		SecretAccessKey: auth.SecretKey,
-		SecurityToken:   auth.Token(),
-		Endpoint:        p.Endpoint,
+	if aws.StringValue(sess.Config.Region) == "" {
+		return nil, errors.New("no region")
 	}
+	config := aws.NewConfig()
+	if p.Endpoint != "" {
+		config = config.WithEndpoint(p.Endpoint)
+	}
+	svc := ses.New(sess, config)
 
 	stat := make(map[string]float64)
-	quota, err := sescfg.GetSendQuota()
+	quota, err := svc.GetSendQuota(&ses.GetSendQuotaInput{})
 	if err == nil {
-		stat["SentLast24Hours"] = quota.SentLast24Hours
-		stat["Max24HourSend"] = quota.Max24HourSend
-		stat["MaxSendRate"] = quota.MaxSendRate
+		stat["SentLast24Hours"] = aws.Float64Value(quota.SentLast24Hours)
+		stat["Max24HourSend"] = aws.Float64Value(quota.Max24HourSend)
+		stat["MaxSendRate"] = aws.Float64Value(quota.MaxSendRate)
 	}
 
-	datapoints, err := sescfg.GetSendStatistics()
+	statistics, err := svc.GetSendStatistics(&ses.GetSendStatisticsInput{})
 	if err == nil {
-		latest := ses.SendDataPoint{
-			Timestamp: time.Unix(0, 0),
+		latest := &ses.SendDataPoint{
+			Timestamp: aws.Time(time.Unix(0, 0)),
 		}
 
-		for _, dp := range datapoints {
-			if latest.Timestamp.Before(dp.Timestamp) {
+		for _, dp := range statistics.SendDataPoints {
+			if aws.TimeValue(latest.Timestamp).Before(aws.TimeValue(dp.Timestamp)) {
 				latest = dp
 			}
 		}
 
-		stat["Complaints"] = float64(latest.Complaints)
-		stat["DeliveryAttempts"] = float64(latest.DeliveryAttempts)
-		stat["Bounces"] = float64(latest.Bounces)
-		stat["Rejects"] = float64(latest.Rejects)
+		stat["Complaints"] = float64(aws.Int64Value(latest.Complaints))
+		stat["DeliveryAttempts"] = float64(aws.Int64Value(latest.DeliveryAttempts))
+		stat["Bounces"] = float64(aws.Int64Value(latest.Bounces))
+		stat["Rejects"] = float64(aws.Int64Value(latest.Rejects))
 	}
 
 	return stat, nil
@@ -99,17 +114,16 @@ func (p SESPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
+	var sessionOpts awsplugin.SessionOptions
+	flag.StringVar(&sessionOpts.Region, "region", "", "AWS Region")
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optEndpoint := flag.String("endpoint", "", "AWS Endpoint")
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var ses SESPlugin
-
+	ses.SessionOptions = sessionOpts
 	ses.Endpoint = *optEndpoint
-	ses.AccessKeyID = *optAccessKeyID
-	ses.SecretAccessKey = *optSecretAccessKey
 
 	helper := mp.NewMackerelPlugin(ses)
 	helper.Tempfile = *optTempfile
@@ -1,18 +1,15 @@
 package mpawswaf
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/waf"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/awsplugin"
 )
 
 var graphdef = map[string]mp.Graphs{
@@ -29,27 +26,22 @@ var graphdef = map[string]mp.Graphs{
 
 // WafPlugin mackerel plugin for aws waf
 type WafPlugin struct {
-	AccessKeyID     string
-	SecretAccessKey string
-	WebACLID        string
-	WebACL          string
-	Rules           []string
-	CloudWatch      *cloudwatch.CloudWatch
+	awsplugin.SessionOptions
+	WebACLID   string
+	WebACL     string
+	Rules      []string
+	CloudWatch *cloudwatch.CloudWatch
 }
 
 func (p *WafPlugin) prepare() error {
-	sess, err := session.NewSession()
+	// WAF is a global service whose API and metrics are in us-east-1
+	p.Region = "us-east-1"
+	sess, err := p.NewSession()
 	if err != nil {
 		return err
 	}
 
-	config := aws.NewConfig()
-	if p.AccessKeyID != "" && p.SecretAccessKey != "" {
-		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyID, p.SecretAccessKey, ""))
-	}
-	config = config.WithRegion("us-east-1")
-
-	svc := waf.New(sess, config)
+	svc := waf.New(sess)
 	response, err := svc.GetWebACL(&waf.GetWebACLInput{
 		WebACLId: aws.String(p.WebACLID),
 	})
@@ -70,51 +62,14 @@ func (p *WafPlugin) prepare() error {
 	}
 	p.Rules = rules
 
-	p.CloudWatch = cloudwatch.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess)
 
 	return nil
 }
 
-func (p WafPlugin) getLastPoint(dimensions []*cloudwatch.Dimension, metricName string) (float64, error) {
-	now := time.Now()
-
-	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
-		Namespace:  aws.String("WAF"),
-		MetricName: aws.String(metricName),
-		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)),
-		EndTime:    aws.Time(now),
-		Period:     aws.Int64(60),
-		Statistics: []*string{aws.String("Sum")},
-		Dimensions: dimensions,
-	})
-
-	if err != nil {
-		return 0, err
-	}
-
-	datapoints := response.Datapoints
-	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
-	}
-
-	latest := time.Unix(0, 0)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(latest) {
-			continue
-		}
-
-		latest = *dp.Timestamp
-		latestVal = *dp.Sum
-	}
-
-	return latestVal, nil
-}
-
 // FetchMetrics interface for mackerelplugin
 func (p WafPlugin) FetchMetrics() (map[string]float64, error) {
-	stat := make(map[string]float64)
-
+	var queries []awsplugin.MetricQuery
 	for _, rule := range p.Rules {
 		dimensions := []*cloudwatch.Dimension{
 			{
@@ -128,16 +83,16 @@ func (p WafPlugin) FetchMetrics() (map[string]float64, error) {
 		}
 
 		for _, met := range [...]string{"AllowedRequests", "BlockedRequests", "CountedRequests"} {
-			v, err := p.getLastPoint(dimensions, met)
-			if err == nil {
-				stat[fmt.Sprintf("waf.Requests.%s.%s", rule, met)] = v
-			} else {
-				log.Printf("%s.%s: %s", rule, met, err)
-			}
+			queries = append(queries, awsplugin.MetricQuery{
+				Key:        fmt.Sprintf("waf.Requests.%s.%s", rule, met),
+				Namespace:  "WAF",
+				MetricName: met,
+				Dimensions: dimensions,
+				Statistic:  "Sum",
+			})
 		}
 	}
-
-	return stat, nil
+	return awsplugin.NewCloudWatchFetcher(p.CloudWatch).Fetch(queries)
 }
 
 // GraphDefinition interface for mackerelplugin
@@ -147,17 +102,15 @@ func (p WafPlugin) GraphDefinition() map[string]mp.Graphs {
 
 // Do the plugin
 func Do() {
-	optAccessKeyID := flag.String("access-key-id", "", "AWS Access Key ID")
-	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	var sessionOpts awsplugin.SessionOptions
+	sessionOpts.AddCredentialFlags(flag.CommandLine)
 	optWebACLID := flag.String("web-acl-id", "", "AWS Web ACL ID")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var waf WafPlugin
-
+	waf.SessionOptions = sessionOpts
 	waf.WebACLID = *optWebACLID
-	waf.AccessKeyID = *optAccessKeyID
-	waf.SecretAccessKey = *optSecretAccessKey
 
 	err := waf.prepare()
 	if err != nil {
@@ -0,0 +1,122 @@
+package mpazuremonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultManagementEndpoint = "https://management.azure.com"
+	defaultLoginEndpoint      = "https://login.microsoftonline.com"
+	defaultIMDSEndpoint       = "http://169.254.169.254"
+)
+
+// tokenSource returns the access token of Azure Resource Manager
+type tokenSource interface {
+	Token() (string, error)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// servicePrincipal authenticates with the client secret of the service principal
+type servicePrincipal struct {
+	Client        *http.Client
+	LoginEndpoint string
+	Resource      string
+	TenantID      string
+	ClientID      string
+	ClientSecret  string
+}
+
+// Token requests the token with the client credentials grant
+func (s servicePrincipal) Token() (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", s.ClientID)
+	form.Set("client_secret", s.ClientSecret)
+	form.Set("resource", s.Resource)
+	req, err := http.NewRequest("POST", s.LoginEndpoint+"/"+url.PathEscape(s.TenantID)+"/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestToken(s.Client, req)
+}
+
+// managedIdentity gets the token of the managed identity from Instance Metadata Service
+type managedIdentity struct {
+	Client       *http.Client
+	IMDSEndpoint string
+	Resource     string
+	// ClientID selects the user-assigned identity
+	ClientID string
+}
+
+// Token requests the token to Instance Metadata Service
+func (m managedIdentity) Token() (string, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", m.Resource)
+	if m.ClientID != "" {
+		query.Set("client_id", m.ClientID)
+	}
+	req, err := http.NewRequest("GET", m.IMDSEndpoint+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	return requestToken(m.Client, req)
+}
+
+func requestToken(client *http.Client, req *http.Request) (string, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get the token: %s: %s", res.Status, body)
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("failed to get the token: no access_token in the response")
+	}
+	return token.AccessToken, nil
+}
+
+// getVMResourceID returns the resource ID of the virtual machine running this plugin
+func getVMResourceID(client *http.Client, imdsEndpoint string) (string, error) {
+	req, err := http.NewRequest("GET", imdsEndpoint+"/metadata/instance/compute?api-version=2021-02-01", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get the instance metadata: %s", res.Status)
+	}
+	var compute struct {
+		ResourceID string `json:"resourceId"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&compute); err != nil {
+		return "", err
+	}
+	return compute.ResourceID, nil
+}
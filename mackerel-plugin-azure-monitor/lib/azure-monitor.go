@@ -0,0 +1,306 @@
+package mpazuremonitor
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+const (
+	defaultInterval = "PT1M"
+	apiVersion      = "2018-01-01"
+)
+
+// timespans to look back for the latest datapoint per interval
+var timespans = map[string]time.Duration{
+	"PT1M": 10 * time.Minute,
+	"PT5M": 30 * time.Minute,
+	"PT1H": 3 * time.Hour,
+}
+
+// AzureMonitorPlugin mackerel plugin for Azure Monitor
+type AzureMonitorPlugin struct {
+	Prefix             string
+	ResourceType       string
+	ResourceID         string
+	ManagementEndpoint string
+	Client             *http.Client
+
+	auth tokenSource
+	now  func() time.Time
+}
+
+type metricsResponse struct {
+	Value []struct {
+		Name struct {
+			Value string `json:"value"`
+		} `json:"name"`
+		Timeseries []struct {
+			Data []dataPoint `json:"data"`
+		} `json:"timeseries"`
+	} `json:"value"`
+}
+
+type dataPoint struct {
+	TimeStamp time.Time `json:"timeStamp"`
+	Average   *float64  `json:"average"`
+	Total     *float64  `json:"total"`
+	Maximum   *float64  `json:"maximum"`
+	Minimum   *float64  `json:"minimum"`
+	Count     *float64  `json:"count"`
+}
+
+func (d dataPoint) value(aggregation string) *float64 {
+	switch aggregation {
+	case aggregationAverage:
+		return d.Average
+	case aggregationTotal:
+		return d.Total
+	case aggregationMaximum:
+		return d.Maximum
+	}
+	return nil
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p AzureMonitorPlugin) MetricKeyPrefix() string {
+	return p.Prefix
+}
+
+// buildResourceID returns the resource ID of Azure Resource Manager.
+// The SQL databases are the child resources of the servers.
+func buildResourceID(rt resourceType, subscriptionID, resourceGroup, server, name string) (string, error) {
+	if subscriptionID == "" || resourceGroup == "" || name == "" {
+		return "", fmt.Errorf("-resource-id or all of -subscription-id, -resource-group and -name are required")
+	}
+	id := "/subscriptions/" + subscriptionID + "/resourceGroups/" + resourceGroup + "/providers/"
+	if rt.Provider == resourceTypes["sql"].Provider {
+		if server == "" {
+			return "", fmt.Errorf("-server is required for the SQL databases")
+		}
+		return id + "Microsoft.Sql/servers/" + server + "/databases/" + name, nil
+	}
+	return id + rt.Provider + "/" + name, nil
+}
+
+// FetchMetrics fetch the metrics
+func (p AzureMonitorPlugin) FetchMetrics() (map[string]float64, error) {
+	rt := resourceTypes[p.ResourceType]
+	token, err := p.auth.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	// the metrics of the same interval are queried at once
+	groups := make(map[string][]metricDef)
+	for _, m := range rt.Metrics {
+		interval := m.Interval
+		if interval == "" {
+			interval = defaultInterval
+		}
+		groups[interval] = append(groups[interval], m)
+	}
+	intervals := make([]string, 0, len(groups))
+	for interval := range groups {
+		intervals = append(intervals, interval)
+	}
+	sort.Strings(intervals)
+
+	stat := make(map[string]float64)
+	for _, interval := range intervals {
+		res, err := p.getMetrics(token, interval, groups[interval])
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range latestValues(res, groups[interval]) {
+			stat[k] = v
+		}
+	}
+	return stat, nil
+}
+
+func (p AzureMonitorPlugin) getMetrics(token, interval string, metrics []metricDef) (*metricsResponse, error) {
+	var names []string
+	aggregations := make(map[string]bool)
+	for _, m := range metrics {
+		names = append(names, m.AzureName)
+		aggregations[m.Aggregation] = true
+	}
+	var aggregationList []string
+	for a := range aggregations {
+		aggregationList = append(aggregationList, a)
+	}
+	sort.Strings(aggregationList)
+
+	span, ok := timespans[interval]
+	if !ok {
+		span = 10 * time.Minute
+	}
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+	end := now().UTC()
+	start := end.Add(-span)
+
+	query := url.Values{}
+	query.Set("api-version", apiVersion)
+	query.Set("metricnames", strings.Join(names, ","))
+	query.Set("aggregation", strings.Join(aggregationList, ","))
+	query.Set("interval", interval)
+	query.Set("timespan", start.Format(time.RFC3339)+"/"+end.Format(time.RFC3339))
+	req, err := http.NewRequest("GET", p.ManagementEndpoint+p.ResourceID+"/providers/microsoft.insights/metrics?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the metrics: %s: %s", res.Status, body)
+	}
+	var metricsRes metricsResponse
+	if err := json.Unmarshal(body, &metricsRes); err != nil {
+		return nil, err
+	}
+	return &metricsRes, nil
+}
+
+// latestValues returns the latest values of the metrics, skipping the datapoints without values
+func latestValues(res *metricsResponse, metrics []metricDef) map[string]float64 {
+	stat := make(map[string]float64)
+	for _, v := range res.Value {
+		for _, m := range metrics {
+			if !strings.EqualFold(m.AzureName, v.Name.Value) {
+				continue
+			}
+			var latest *dataPoint
+			for _, ts := range v.Timeseries {
+				for i, d := range ts.Data {
+					if d.value(m.Aggregation) == nil {
+						continue
+					}
+					if latest == nil || d.TimeStamp.After(latest.TimeStamp) {
+						latest = &ts.Data[i]
+					}
+				}
+			}
+			if latest == nil {
+				continue
+			}
+			value := *latest.value(m.Aggregation)
+			if m.Scale != 0 {
+				value *= m.Scale
+			}
+			stat[m.MackerelName] = value
+		}
+	}
+	return stat
+}
+
+// GraphDefinition of AzureMonitorPlugin
+func (p AzureMonitorPlugin) GraphDefinition() map[string]mp.Graphs {
+	rt := resourceTypes[p.ResourceType]
+	labelPrefix := rt.Label
+	if p.Prefix != rt.DefaultPrefix {
+		labelPrefix = strings.Title(p.Prefix)
+	}
+	return rt.graphdef(labelPrefix)
+}
+
+func envOr(value, name string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// Do the plugin
+func Do() {
+	optResourceType := flag.String("resource-type", "vm", "Resource type (vm, sql, appservice or storage)")
+	optResourceID := flag.String("resource-id", "", "Resource ID")
+	optSubscriptionID := flag.String("subscription-id", "", "Subscription ID")
+	optResourceGroup := flag.String("resource-group", "", "Resource group name")
+	optServer := flag.String("server", "", "SQL server name (for sql)")
+	optName := flag.String("name", "", "Resource name")
+	optTenantID := flag.String("tenant-id", "", "Tenant ID of the service principal (default $AZURE_TENANT_ID)")
+	optClientID := flag.String("client-id", "", "Client ID of the service principal or the user-assigned managed identity (default $AZURE_CLIENT_ID)")
+	optClientSecret := flag.String("client-secret", "", "Client secret of the service principal (default $AZURE_CLIENT_SECRET)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optPrefix := flag.String("metric-key-prefix", "", "Metric key prefix")
+	flag.Parse()
+
+	rt, ok := resourceTypes[*optResourceType]
+	if !ok {
+		log.Fatalf("unknown resource type: %s", *optResourceType)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var plugin AzureMonitorPlugin
+	plugin.ResourceType = *optResourceType
+	plugin.ManagementEndpoint = defaultManagementEndpoint
+	plugin.Client = client
+	plugin.Prefix = *optPrefix
+	if plugin.Prefix == "" {
+		plugin.Prefix = rt.DefaultPrefix
+	}
+
+	plugin.ResourceID = *optResourceID
+	if plugin.ResourceID == "" {
+		var err error
+		if *optResourceType == "vm" && *optName == "" {
+			// monitor the virtual machine running this plugin
+			plugin.ResourceID, err = getVMResourceID(client, defaultIMDSEndpoint)
+		} else {
+			plugin.ResourceID, err = buildResourceID(rt, *optSubscriptionID, *optResourceGroup, *optServer, *optName)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	clientID := envOr(*optClientID, "AZURE_CLIENT_ID")
+	clientSecret := envOr(*optClientSecret, "AZURE_CLIENT_SECRET")
+	if clientSecret != "" {
+		tenantID := envOr(*optTenantID, "AZURE_TENANT_ID")
+		if tenantID == "" || clientID == "" {
+			log.Fatalln("-tenant-id and -client-id are required for the service principal")
+		}
+		plugin.auth = servicePrincipal{
+			Client:        client,
+			LoginEndpoint: defaultLoginEndpoint,
+			Resource:      defaultManagementEndpoint + "/",
+			TenantID:      tenantID,
+			ClientID:      clientID,
+			ClientSecret:  clientSecret,
+		}
+	} else {
+		plugin.auth = managedIdentity{
+			Client:       client,
+			IMDSEndpoint: defaultIMDSEndpoint,
+			Resource:     defaultManagementEndpoint + "/",
+			ClientID:     clientID,
+		}
+	}
+
+	helper := mp.NewMackerelPlugin(plugin)
+	helper.Tempfile = *optTempfile
+	helper.Run()
+}
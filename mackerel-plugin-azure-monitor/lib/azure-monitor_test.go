@@ -0,0 +1,176 @@
+package mpazuremonitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testToken        = "eyJ0eXAiOiJKV1QiLCJhbGciOiJSUzI1NiJ9.test"
+	testVMID         = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/production/providers/Microsoft.Compute/virtualMachines/web01"
+	testStorageID    = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/production/providers/Microsoft.Storage/storageAccounts/assets"
+	testTenantID     = "22222222-2222-2222-2222-222222222222"
+	testClientID     = "33333333-3333-3333-3333-333333333333"
+	testClientSecret = "secret"
+)
+
+// newFixtureServer serves the recorded responses of Azure in testdata
+func newFixtureServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var fixture string
+		switch {
+		case r.URL.Path == "/"+testTenantID+"/oauth2/token":
+			r.ParseForm()
+			if r.PostForm.Get("grant_type") != "client_credentials" || r.PostForm.Get("client_secret") != testClientSecret {
+				http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+			fixture = "token.json"
+		case r.URL.Path == "/metadata/identity/oauth2/token":
+			if r.Header.Get("Metadata") != "true" {
+				http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+				return
+			}
+			fixture = "token.json"
+		case r.URL.Path == "/metadata/instance/compute":
+			fixture = "compute.json"
+		case strings.HasSuffix(r.URL.Path, "/providers/microsoft.insights/metrics"):
+			if r.Header.Get("Authorization") != "Bearer "+testToken {
+				http.Error(w, `{"code":"AuthenticationFailed"}`, http.StatusUnauthorized)
+				return
+			}
+			switch strings.TrimSuffix(r.URL.Path, "/providers/microsoft.insights/metrics") {
+			case testVMID:
+				fixture = "vm_metrics.json"
+			case testStorageID:
+				fixture = "storage_metrics.json"
+				if r.URL.Query().Get("interval") == "PT1H" {
+					fixture = "storage_capacity.json"
+				}
+			}
+		}
+		if fixture == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, filepath.Join("testdata", fixture))
+	}))
+}
+
+func TestBuildResourceID(t *testing.T) {
+	id, err := buildResourceID(resourceTypes["sql"], "sub", "rg", "server1", "db1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/server1/databases/db1", id)
+
+	id, err = buildResourceID(resourceTypes["appservice"], "sub", "rg", "", "site1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Web/sites/site1", id)
+
+	_, err = buildResourceID(resourceTypes["sql"], "sub", "rg", "", "db1")
+	assert.NotNil(t, err, "the server is required for sql")
+}
+
+func TestToken(t *testing.T) {
+	ts := newFixtureServer(t)
+	defer ts.Close()
+
+	token, err := servicePrincipal{
+		Client:        http.DefaultClient,
+		LoginEndpoint: ts.URL,
+		Resource:      defaultManagementEndpoint + "/",
+		TenantID:      testTenantID,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+	}.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, testToken, token)
+
+	_, err = servicePrincipal{
+		Client:        http.DefaultClient,
+		LoginEndpoint: ts.URL,
+		TenantID:      testTenantID,
+		ClientID:      testClientID,
+		ClientSecret:  "wrong",
+	}.Token()
+	assert.NotNil(t, err)
+
+	token, err = managedIdentity{Client: http.DefaultClient, IMDSEndpoint: ts.URL}.Token()
+	assert.Nil(t, err)
+	assert.Equal(t, testToken, token)
+
+	id, err := getVMResourceID(http.DefaultClient, ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, testVMID, id)
+}
+
+func testPlugin(ts *httptest.Server, resourceType, resourceID string) AzureMonitorPlugin {
+	return AzureMonitorPlugin{
+		Prefix:             resourceTypes[resourceType].DefaultPrefix,
+		ResourceType:       resourceType,
+		ResourceID:         resourceID,
+		ManagementEndpoint: ts.URL,
+		Client:             http.DefaultClient,
+		auth:               managedIdentity{Client: http.DefaultClient, IMDSEndpoint: ts.URL},
+		now: func() time.Time {
+			return time.Date(2021, 6, 1, 8, 1, 0, 0, time.UTC)
+		},
+	}
+}
+
+func TestFetchMetricsVM(t *testing.T) {
+	ts := newFixtureServer(t)
+	defer ts.Close()
+
+	p := testPlugin(ts, "vm", testVMID)
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		"cpu_percentage":        12.25,
+		"cpu_credits_remaining": 288,
+		"cpu_credits_consumed":  0.5,
+		"network_in":            1024,
+		"network_out":           2048,
+		"disk_read_bytes":       0,
+		"disk_write_bytes":      4096,
+		"disk_read_ops":         0,
+		"disk_write_ops":        3.5,
+	}, stat)
+
+	graphdef := p.GraphDefinition()
+	assert.Equal(t, "Azure VM CPU", graphdef["cpu"].Label)
+	for key, g := range graphdef {
+		for _, m := range g.Metrics {
+			_, ok := stat[m.Name]
+			assert.True(t, ok, "%s.%s is fetched", key, m.Name)
+		}
+	}
+}
+
+func TestFetchMetricsStorage(t *testing.T) {
+	ts := newFixtureServer(t)
+	defer ts.Close()
+
+	p := testPlugin(ts, "storage", testStorageID)
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		"used_capacity":          2147483648,
+		"transactions":           42,
+		"ingress":                100,
+		"egress":                 2000,
+		"success_e2e_latency":    8.5,
+		"success_server_latency": 4,
+		"availability":           100,
+	}, stat)
+
+	p.auth = servicePrincipal{Client: http.DefaultClient, LoginEndpoint: ts.URL, TenantID: testTenantID, ClientID: testClientID, ClientSecret: "wrong"}
+	_, err = p.FetchMetrics()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,284 @@
+package mpazuremonitor
+
+import (
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+const (
+	aggregationAverage = "Average"
+	aggregationTotal   = "Total"
+	aggregationMaximum = "Maximum"
+)
+
+// metricDef is an aggregation of an Azure Monitor metric posted as a Mackerel metric
+type metricDef struct {
+	AzureName    string
+	Aggregation  string
+	MackerelName string
+	// Interval is the time grain of the metric, PT1M by default
+	Interval string
+	// Scale converts the value, e.g. from a total per minute to a rate per second
+	Scale float64
+}
+
+type resourceType struct {
+	// Provider is the resource type of Azure Resource Manager
+	Provider string
+	// DefaultPrefix is the default of -metric-key-prefix
+	DefaultPrefix string
+	// Label is the prefix of the graph labels with the default metric key prefix
+	Label    string
+	Metrics  []metricDef
+	graphdef func(labelPrefix string) map[string]mp.Graphs
+}
+
+// https://docs.microsoft.com/azure/azure-monitor/platform/metrics-supported
+var resourceTypes = map[string]resourceType{
+	"vm": {
+		Provider:      "Microsoft.Compute/virtualMachines",
+		DefaultPrefix: "azure-vm",
+		Label:         "Azure VM",
+		Metrics: []metricDef{
+			{AzureName: "Percentage CPU", Aggregation: aggregationAverage, MackerelName: "cpu_percentage"},
+			{AzureName: "CPU Credits Remaining", Aggregation: aggregationAverage, MackerelName: "cpu_credits_remaining"},
+			{AzureName: "CPU Credits Consumed", Aggregation: aggregationAverage, MackerelName: "cpu_credits_consumed"},
+			{AzureName: "Network In Total", Aggregation: aggregationTotal, MackerelName: "network_in", Scale: 1.0 / 60},
+			{AzureName: "Network Out Total", Aggregation: aggregationTotal, MackerelName: "network_out", Scale: 1.0 / 60},
+			{AzureName: "Disk Read Bytes", Aggregation: aggregationTotal, MackerelName: "disk_read_bytes", Scale: 1.0 / 60},
+			{AzureName: "Disk Write Bytes", Aggregation: aggregationTotal, MackerelName: "disk_write_bytes", Scale: 1.0 / 60},
+			{AzureName: "Disk Read Operations/Sec", Aggregation: aggregationAverage, MackerelName: "disk_read_ops"},
+			{AzureName: "Disk Write Operations/Sec", Aggregation: aggregationAverage, MackerelName: "disk_write_ops"},
+		},
+		graphdef: func(labelPrefix string) map[string]mp.Graphs {
+			return map[string]mp.Graphs{
+				"cpu": {
+					Label: labelPrefix + " CPU",
+					Unit:  "percentage",
+					Metrics: []mp.Metrics{
+						{Name: "cpu_percentage", Label: "CPU"},
+					},
+				},
+				"cpu_credits": {
+					Label: labelPrefix + " CPU Credits",
+					Unit:  "float",
+					Metrics: []mp.Metrics{
+						{Name: "cpu_credits_remaining", Label: "Remaining"},
+						{Name: "cpu_credits_consumed", Label: "Consumed"},
+					},
+				},
+				"network": {
+					Label: labelPrefix + " Network",
+					Unit:  "bytes/sec",
+					Metrics: []mp.Metrics{
+						{Name: "network_in", Label: "In"},
+						{Name: "network_out", Label: "Out"},
+					},
+				},
+				"disk_bytes": {
+					Label: labelPrefix + " Disk Bytes",
+					Unit:  "bytes/sec",
+					Metrics: []mp.Metrics{
+						{Name: "disk_read_bytes", Label: "Read"},
+						{Name: "disk_write_bytes", Label: "Write"},
+					},
+				},
+				"disk_ops": {
+					Label: labelPrefix + " Disk Operations",
+					Unit:  "iops",
+					Metrics: []mp.Metrics{
+						{Name: "disk_read_ops", Label: "Read"},
+						{Name: "disk_write_ops", Label: "Write"},
+					},
+				},
+			}
+		},
+	},
+	"sql": {
+		Provider:      "Microsoft.Sql/servers/databases",
+		DefaultPrefix: "azure-sql",
+		Label:         "Azure SQL",
+		Metrics: []metricDef{
+			{AzureName: "cpu_percent", Aggregation: aggregationAverage, MackerelName: "cpu_percent"},
+			{AzureName: "dtu_consumption_percent", Aggregation: aggregationAverage, MackerelName: "dtu_consumption_percent"},
+			{AzureName: "physical_data_read_percent", Aggregation: aggregationAverage, MackerelName: "physical_data_read_percent"},
+			{AzureName: "log_write_percent", Aggregation: aggregationAverage, MackerelName: "log_write_percent"},
+			{AzureName: "workers_percent", Aggregation: aggregationAverage, MackerelName: "workers_percent"},
+			{AzureName: "sessions_percent", Aggregation: aggregationAverage, MackerelName: "sessions_percent"},
+			{AzureName: "storage_percent", Aggregation: aggregationMaximum, MackerelName: "storage_percent"},
+			{AzureName: "storage", Aggregation: aggregationMaximum, MackerelName: "storage"},
+			{AzureName: "connection_successful", Aggregation: aggregationTotal, MackerelName: "connection_successful"},
+			{AzureName: "connection_failed", Aggregation: aggregationTotal, MackerelName: "connection_failed"},
+			{AzureName: "blocked_by_firewall", Aggregation: aggregationTotal, MackerelName: "blocked_by_firewall"},
+			{AzureName: "deadlock", Aggregation: aggregationTotal, MackerelName: "deadlock"},
+		},
+		graphdef: func(labelPrefix string) map[string]mp.Graphs {
+			return map[string]mp.Graphs{
+				"utilization": {
+					Label: labelPrefix + " Utilization",
+					Unit:  "percentage",
+					Metrics: []mp.Metrics{
+						{Name: "cpu_percent", Label: "CPU"},
+						{Name: "dtu_consumption_percent", Label: "DTU"},
+						{Name: "physical_data_read_percent", Label: "Data IO"},
+						{Name: "log_write_percent", Label: "Log IO"},
+						{Name: "workers_percent", Label: "Workers"},
+						{Name: "sessions_percent", Label: "Sessions"},
+					},
+				},
+				"storage_percent": {
+					Label: labelPrefix + " Storage Percentage",
+					Unit:  "percentage",
+					Metrics: []mp.Metrics{
+						{Name: "storage_percent", Label: "Used"},
+					},
+				},
+				"storage": {
+					Label: labelPrefix + " Storage",
+					Unit:  "bytes",
+					Metrics: []mp.Metrics{
+						{Name: "storage", Label: "Used"},
+					},
+				},
+				"connections": {
+					Label: labelPrefix + " Connections",
+					Unit:  "integer",
+					Metrics: []mp.Metrics{
+						{Name: "connection_successful", Label: "Successful", Stacked: true},
+						{Name: "connection_failed", Label: "Failed", Stacked: true},
+						{Name: "blocked_by_firewall", Label: "Blocked by Firewall", Stacked: true},
+					},
+				},
+				"deadlock": {
+					Label: labelPrefix + " Deadlocks",
+					Unit:  "integer",
+					Metrics: []mp.Metrics{
+						{Name: "deadlock", Label: "Deadlocks"},
+					},
+				},
+			}
+		},
+	},
+	"appservice": {
+		Provider:      "Microsoft.Web/sites",
+		DefaultPrefix: "azure-appservice",
+		Label:         "Azure App Service",
+		Metrics: []metricDef{
+			{AzureName: "Requests", Aggregation: aggregationTotal, MackerelName: "requests"},
+			{AzureName: "Http2xx", Aggregation: aggregationTotal, MackerelName: "http_2xx"},
+			{AzureName: "Http3xx", Aggregation: aggregationTotal, MackerelName: "http_3xx"},
+			{AzureName: "Http4xx", Aggregation: aggregationTotal, MackerelName: "http_4xx"},
+			{AzureName: "Http5xx", Aggregation: aggregationTotal, MackerelName: "http_5xx"},
+			{AzureName: "HttpResponseTime", Aggregation: aggregationAverage, MackerelName: "response_time"},
+			{AzureName: "BytesReceived", Aggregation: aggregationTotal, MackerelName: "bytes_received", Scale: 1.0 / 60},
+			{AzureName: "BytesSent", Aggregation: aggregationTotal, MackerelName: "bytes_sent", Scale: 1.0 / 60},
+			{AzureName: "CpuTime", Aggregation: aggregationTotal, MackerelName: "cpu_time"},
+			{AzureName: "MemoryWorkingSet", Aggregation: aggregationAverage, MackerelName: "memory_working_set"},
+		},
+		graphdef: func(labelPrefix string) map[string]mp.Graphs {
+			return map[string]mp.Graphs{
+				"requests": {
+					Label: labelPrefix + " Requests",
+					Unit:  "integer",
+					Metrics: []mp.Metrics{
+						{Name: "requests", Label: "Requests"},
+					},
+				},
+				"http_status": {
+					Label: labelPrefix + " HTTP Status",
+					Unit:  "integer",
+					Metrics: []mp.Metrics{
+						{Name: "http_2xx", Label: "2xx", Stacked: true},
+						{Name: "http_3xx", Label: "3xx", Stacked: true},
+						{Name: "http_4xx", Label: "4xx", Stacked: true},
+						{Name: "http_5xx", Label: "5xx", Stacked: true},
+					},
+				},
+				"response_time": {
+					Label: labelPrefix + " Response Time",
+					Unit:  "float",
+					Metrics: []mp.Metrics{
+						{Name: "response_time", Label: "Average"},
+					},
+				},
+				"bandwidth": {
+					Label: labelPrefix + " Bandwidth",
+					Unit:  "bytes/sec",
+					Metrics: []mp.Metrics{
+						{Name: "bytes_received", Label: "Received"},
+						{Name: "bytes_sent", Label: "Sent"},
+					},
+				},
+				"cpu_time": {
+					Label: labelPrefix + " CPU Time",
+					Unit:  "float",
+					Metrics: []mp.Metrics{
+						{Name: "cpu_time", Label: "CPU Time"},
+					},
+				},
+				"memory": {
+					Label: labelPrefix + " Memory",
+					Unit:  "bytes",
+					Metrics: []mp.Metrics{
+						{Name: "memory_working_set", Label: "Working Set"},
+					},
+				},
+			}
+		},
+	},
+	"storage": {
+		Provider:      "Microsoft.Storage/storageAccounts",
+		DefaultPrefix: "azure-storage",
+		Label:         "Azure Storage",
+		Metrics: []metricDef{
+			// the capacity is aggregated hourly
+			{AzureName: "UsedCapacity", Aggregation: aggregationAverage, MackerelName: "used_capacity", Interval: "PT1H"},
+			{AzureName: "Transactions", Aggregation: aggregationTotal, MackerelName: "transactions"},
+			{AzureName: "Ingress", Aggregation: aggregationTotal, MackerelName: "ingress", Scale: 1.0 / 60},
+			{AzureName: "Egress", Aggregation: aggregationTotal, MackerelName: "egress", Scale: 1.0 / 60},
+			{AzureName: "SuccessE2ELatency", Aggregation: aggregationAverage, MackerelName: "success_e2e_latency"},
+			{AzureName: "SuccessServerLatency", Aggregation: aggregationAverage, MackerelName: "success_server_latency"},
+			{AzureName: "Availability", Aggregation: aggregationAverage, MackerelName: "availability"},
+		},
+		graphdef: func(labelPrefix string) map[string]mp.Graphs {
+			return map[string]mp.Graphs{
+				"capacity": {
+					Label: labelPrefix + " Capacity",
+					Unit:  "bytes",
+					Metrics: []mp.Metrics{
+						{Name: "used_capacity", Label: "Used"},
+					},
+				},
+				"transactions": {
+					Label: labelPrefix + " Transactions",
+					Unit:  "integer",
+					Metrics: []mp.Metrics{
+						{Name: "transactions", Label: "Transactions"},
+					},
+				},
+				"bandwidth": {
+					Label: labelPrefix + " Bandwidth",
+					Unit:  "bytes/sec",
+					Metrics: []mp.Metrics{
+						{Name: "ingress", Label: "Ingress"},
+						{Name: "egress", Label: "Egress"},
+					},
+				},
+				"latency": {
+					Label: labelPrefix + " Latency",
+					Unit:  "float",
+					Metrics: []mp.Metrics{
+						{Name: "success_e2e_latency", Label: "E2E"},
+						{Name: "success_server_latency", Label: "Server"},
+					},
+				},
+				"availability": {
+					Label: labelPrefix + " Availability",
+					Unit:  "percentage",
+					Metrics: []mp.Metrics{
+						{Name: "availability", Label: "Availability"},
+					},
+				},
+			}
+		},
+	},
+}
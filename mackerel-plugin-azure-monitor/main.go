@@ -0,0 +1,7 @@
+package main
+
+import "github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-azure-monitor/lib"
+
+func main() {
+	mpazuremonitor.Do()
+}
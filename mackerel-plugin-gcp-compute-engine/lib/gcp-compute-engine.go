@@ -6,12 +6,15 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
 
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/monitoring/v3"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
@@ -23,13 +26,40 @@ const computeDomain string = "compute.googleapis.com"
 
 // ComputeEnginePlugin is mackerel plugin for Google Compute Engine
 type ComputeEnginePlugin struct {
-	Project           string
-	InstanceName      string
+	Project      string
+	InstanceName string
+	// Zone, InstanceGroup and Labels select the instances to post the metrics per instance
+	// when InstanceName is empty
+	Zone              string
+	InstanceGroup     string
+	Labels            map[string]string
 	MonitoringService *monitoring.Service
 	Option            *Option
 	Tempfile          string
 }
 
+// labelFilters is a flag.Value of "-label key=value", which can be specified multiple times
+type labelFilters map[string]string
+
+func (l labelFilters) String() string {
+	var filters []string
+	for k, v := range l {
+		filters = append(filters, k+"="+v)
+	}
+	sort.Strings(filters)
+	return strings.Join(filters, ",")
+}
+
+// Set adds a filter of the user label
+func (l labelFilters) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("invalid label filter: %q", s)
+	}
+	l[kv[0]] = kv[1]
+	return nil
+}
+
 // Option is optional argument to an API call
 type Option struct {
 	Key string
@@ -96,36 +126,73 @@ var graphdef = map[string]mp.Graphs{
 	},
 }
 
+// multiInstance returns true if the metrics are posted per instance selected by the zone, the group or the labels
+func (p ComputeEnginePlugin) multiInstance() bool {
+	return p.InstanceName == ""
+}
+
 // GraphDefinition is return graphdef
 func (p ComputeEnginePlugin) GraphDefinition() map[string]mp.Graphs {
+	if p.multiInstance() {
+		wildcard := make(map[string]mp.Graphs, len(graphdef))
+		for name, g := range graphdef {
+			wildcard[name+".#"] = g
+		}
+		return wildcard
+	}
 	return graphdef
 }
 
-func getLatestValue(listCall *monitoring.ProjectsTimeSeriesListCall, filter string, startTime string, endTime string, opts *Option) (interface{}, error) {
-	res, err := listCall.Filter(filter).IntervalEndTime(endTime).IntervalStartTime(startTime).Do(*opts)
-
-	if err != nil || res == nil {
-		return 0, err
+func (p ComputeEnginePlugin) callOptions() []googleapi.CallOption {
+	if p.Option == nil || p.Option.Key == "" {
+		return nil
 	}
+	return []googleapi.CallOption{*p.Option}
+}
+
+// getLatestValues returns the sums of the latest points per instance name
+func getLatestValues(listCall *monitoring.ProjectsTimeSeriesListCall, filter string, startTime string, endTime string, opts []googleapi.CallOption) (map[string]interface{}, error) {
+	listCall = listCall.Filter(filter).IntervalEndTime(endTime).IntervalStartTime(startTime)
 
-	var sum interface{}
-	p := res.TimeSeries[0].Points[0].Value
-	if p.Int64Value != nil {
-		sum = uint64(0)
-	} else if p.DoubleValue != nil {
-		sum = float64(0)
+	var timeSeries []*monitoring.TimeSeries
+	for {
+		res, err := listCall.Do(opts...)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			break
+		}
+		timeSeries = append(timeSeries, res.TimeSeries...)
+		if res.NextPageToken == "" {
+			break
+		}
+		listCall = listCall.PageToken(res.NextPageToken)
 	}
+	return sumLatestValues(timeSeries), nil
+}
 
-	for _, series := range res.TimeSeries {
+// sumLatestValues sums the latest points of the time series, e.g. of the disks or the interfaces, per instance name
+func sumLatestValues(timeSeries []*monitoring.TimeSeries) map[string]interface{} {
+	sums := make(map[string]interface{})
+	for _, series := range timeSeries {
+		if len(series.Points) == 0 || series.Points[0].Value == nil {
+			continue
+		}
+		var instance string
+		if series.Metric != nil {
+			instance = series.Metric.Labels["instance_name"]
+		}
 		valuePtr := series.Points[0].Value
 		if valuePtr.Int64Value != nil {
-			sum = sum.(uint64) + uint64(*valuePtr.Int64Value)
+			sum, _ := sums[instance].(uint64)
+			sums[instance] = sum + uint64(*valuePtr.Int64Value)
 		} else if valuePtr.DoubleValue != nil {
-			sum = sum.(float64) + *valuePtr.DoubleValue
+			sum, _ := sums[instance].(float64)
+			sums[instance] = sum + *valuePtr.DoubleValue
 		}
 	}
-
-	return sum, nil
+	return sums
 }
 
 func mkFilter(domain string, metricName string, instance string) string {
@@ -138,13 +205,54 @@ func mkFilter(domain string, metricName string, instance string) string {
 	return filter
 }
 
+var filterStringReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteFilterString quotes the string in the filter of Monitoring, escaping the backslashes and the double quotes
+func quoteFilterString(s string) string {
+	return `"` + filterStringReplacer.Replace(s) + `"`
+}
+
+// mkGroupFilter returns the filter of the instances in the zone and/or the instance group with the user labels
+func mkGroupFilter(domain string, metricName string, zone string, group string, labels map[string]string) string {
+	filter := `metric.type = ` + quoteFilterString(domain+metricName)
+	if zone != "" {
+		filter += ` AND resource.label.zone = ` + quoteFilterString(zone)
+	}
+	if group != "" {
+		filter += ` AND metadata.system_labels.instance_group = ` + quoteFilterString(group)
+	}
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// the key is quoted also, which may not be an identifier
+		filter += ` AND metadata.user_labels.` + quoteFilterString(k) + ` = ` + quoteFilterString(labels[k])
+	}
+	return filter
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// graphOfMetric returns the graph name of the metric name like "utilization"
+func graphOfMetric(name string) string {
+	for graphName, g := range graphdef {
+		for _, m := range g.Metrics {
+			if m.Name == name {
+				return graphName
+			}
+		}
+	}
+	return ""
+}
+
 // FetchMetrics fetches metrics from Google Monitoring API
 func (p ComputeEnginePlugin) FetchMetrics() (map[string]interface{}, error) {
 	now := time.Now()
 	formattedEnd := now.Format(zuluFormat)
 	m, _ := time.ParseDuration(duration)
 	formattedStart := now.Add(-m).Format(zuluFormat)
-	listCall := p.MonitoringService.Projects.TimeSeries.List(p.Project)
 
 	stat := map[string]interface{}{}
 	for _, metricName := range []string{
@@ -160,13 +268,34 @@ func (p ComputeEnginePlugin) FetchMetrics() (map[string]interface{}, error) {
 		"/instance/network/sent_bytes_count",
 		"/instance/network/sent_packets_count",
 	} {
-		value, err := getLatestValue(listCall, mkFilter(computeDomain, metricName, p.InstanceName), formattedStart, formattedEnd, p.Option)
+		splited := strings.Split(metricName, "/")
+		name := splited[len(splited)-1]
+		listCall := p.MonitoringService.Projects.TimeSeries.List(p.Project)
+
+		if !p.multiInstance() {
+			values, err := getLatestValues(listCall, mkFilter(computeDomain, metricName, p.InstanceName), formattedStart, formattedEnd, p.callOptions())
+			if err != nil {
+				log.Printf("Failed to fetch a datapoint for %s: %s\n", metricName, err)
+				continue
+			}
+			if value, ok := values[p.InstanceName]; ok {
+				stat[name] = value
+			}
+			continue
+		}
+
+		values, err := getLatestValues(listCall, mkGroupFilter(computeDomain, metricName, p.Zone, p.InstanceGroup, p.Labels), formattedStart, formattedEnd, p.callOptions())
 		if err != nil {
-			log.Printf("Failed to fetch a datapoint for %s: %s\n", metricName, err)
+			log.Printf("Failed to fetch datapoints for %s: %s\n", metricName, err)
 			continue
 		}
-		splited := strings.Split(metricName, "/")
-		stat[splited[len(splited)-1]] = value
+		graphName := graphOfMetric(name)
+		for instance, value := range values {
+			if instance == "" {
+				continue
+			}
+			stat[graphName+"."+metricNameRe.ReplaceAllString(instance, "_")+"."+name] = value
+		}
 	}
 
 	return stat, nil
@@ -187,6 +316,11 @@ func getMetaData(url string) string {
 		log.Println("Failed to getMetaData:", err)
 		return ""
 	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		log.Println("Failed to getMetaData:", res.Status)
+		return ""
+	}
 
 	b, _ := ioutil.ReadAll(res.Body)
 	return string(b)
@@ -203,6 +337,11 @@ func getProjectID() string {
 }
 
 func getInstanceName() string {
+	// the hostname can be customized, so the name of the instance is preferred
+	if name := getMetaData("http://metadata.google.internal/computeMetadata/v1/instance/name"); name != "" {
+		return name
+	}
+
 	hostName := getMetaData("http://metadata.google.internal/computeMetadata/v1/instance/hostname")
 
 	if hostName == "" {
@@ -212,18 +351,41 @@ func getInstanceName() string {
 	return strings.Split(hostName, ".")[0]
 }
 
+// newHTTPClient returns the client authorized with the service account key file or Application Default Credentials
+func newHTTPClient(ctx context.Context, credentialsFile string) (*http.Client, error) {
+	if credentialsFile == "" {
+		return google.DefaultClient(ctx, monitoring.CloudPlatformScope)
+	}
+	b, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := google.JWTConfigFromJSON(b, monitoring.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	return conf.Client(ctx), nil
+}
+
 // Do the plugin
 func Do() {
 	optProject := flag.String("project", "", "Project Identifier (Name or ID)")
 	optInstanceName := flag.String("instance-name", "", "Instance Name")
 
+	optZone := flag.String("zone", "", "Post the metrics of the instances in the zone")
+	optInstanceGroup := flag.String("instance-group", "", "Post the metrics of the instances in the instance group")
+	labels := make(labelFilters)
+	flag.Var(labels, "label", "Post the metrics of the instances with the label `key=value` (can be specified multiple times)")
+
+	optCredentialsFile := flag.String("credentials-file", "", "Service account key file (Application Default Credentials are used if omitted)")
 	optAPIKey := flag.String("api-key", "", "API key")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 
 	flag.Parse()
 
-	if *optAPIKey == "" {
-		log.Fatalln("-api-key is required")
+	multiInstance := *optZone != "" || *optInstanceGroup != "" || len(labels) > 0
+	if multiInstance && *optInstanceName != "" {
+		log.Fatalln("-instance-name cannot be used with -zone, -instance-group or -label")
 	}
 
 	// Auto detect projectID/instanceName unless specified
@@ -232,17 +394,17 @@ func Do() {
 	if projectID == "" {
 		projectID = getProjectID()
 	}
-	if instanceName == "" {
+	if instanceName == "" && !multiInstance {
 		instanceName = getInstanceName()
 	}
 
-	if projectID == "" || instanceName == "" {
+	if projectID == "" || (instanceName == "" && !multiInstance) {
 		log.Fatalln("Could not get project id and/or instance name")
 	}
 
 	ctx := context.Background()
 
-	client, err := google.DefaultClient(ctx, monitoring.CloudPlatformScope)
+	client, err := newHTTPClient(ctx, *optCredentialsFile)
 	if err != nil {
 		log.Fatalln("Error while preparing Google OAuth client:", err)
 	}
@@ -256,12 +418,17 @@ func Do() {
 		MonitoringService: service,
 		Project:           "projects/" + projectID,
 		InstanceName:      instanceName,
+		Zone:              *optZone,
+		InstanceGroup:     *optInstanceGroup,
+		Labels:            labels,
 		Option:            &Option{Key: *optAPIKey},
 	}
 
 	helper := mp.NewMackerelPlugin(computeEngine)
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
+	} else if multiInstance {
+		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-gcp-compute-engine-%s", projectID))
 	} else {
 		helper.SetTempfileByBasename(fmt.Sprintf("mackerel-plugin-gcp-compute-engine-%s", computeEngine.InstanceName))
 	}
@@ -1,7 +1,10 @@
 package mpgcpcomputeengine
 
 import (
+	"reflect"
 	"testing"
+
+	"google.golang.org/api/monitoring/v3"
 )
 
 func TestGraphDefinition(t *testing.T) {
@@ -15,3 +18,67 @@ func TestGraphDefinition(t *testing.T) {
 		t.Errorf("GraphDefinition(): %d should be %d", len(graphdef), expect)
 	}
 }
+
+func TestGraphDefinitionMultiInstance(t *testing.T) {
+	ce := ComputeEnginePlugin{Zone: "asia-northeast1-a"}
+
+	graphdef := ce.GraphDefinition()
+
+	if _, ok := graphdef["Cpu.Utilization.#"]; !ok {
+		t.Errorf("GraphDefinition(): Cpu.Utilization.# should be defined")
+	}
+}
+
+func TestMkGroupFilter(t *testing.T) {
+	filter := mkGroupFilter(computeDomain, "/instance/cpu/utilization", "asia-northeast1-a", "web", map[string]string{"role": "app", "env": "production"})
+	expect := `metric.type = "compute.googleapis.com/instance/cpu/utilization"` +
+		` AND resource.label.zone = "asia-northeast1-a"` +
+		` AND metadata.system_labels.instance_group = "web"` +
+		` AND metadata.user_labels."env" = "production"` +
+		` AND metadata.user_labels."role" = "app"`
+	if filter != expect {
+		t.Errorf("mkGroupFilter(): %s should be %s", filter, expect)
+	}
+
+	filter = mkGroupFilter(computeDomain, "/instance/cpu/utilization", "", "", map[string]string{"team.name": `say "hi" \ bye`})
+	expect = `metric.type = "compute.googleapis.com/instance/cpu/utilization"` +
+		` AND metadata.user_labels."team.name" = "say \"hi\" \\ bye"`
+	if filter != expect {
+		t.Errorf("mkGroupFilter(): %s should be %s", filter, expect)
+	}
+}
+
+func TestSumLatestValues(t *testing.T) {
+	int64Value := func(v int64) *monitoring.TypedValue { return &monitoring.TypedValue{Int64Value: &v} }
+	series := func(instance string, value *monitoring.TypedValue) *monitoring.TimeSeries {
+		return &monitoring.TimeSeries{
+			Metric: &monitoring.Metric{Labels: map[string]string{"instance_name": instance}},
+			Points: []*monitoring.Point{{Value: value}, {Value: int64Value(1000)}},
+		}
+	}
+	utilization := 0.25
+
+	sums := sumLatestValues([]*monitoring.TimeSeries{
+		series("web-1", int64Value(100)),
+		series("web-1", int64Value(50)),
+		series("web-2", int64Value(10)),
+		series("web-3", &monitoring.TypedValue{DoubleValue: &utilization}),
+	})
+	expect := map[string]interface{}{"web-1": uint64(150), "web-2": uint64(10), "web-3": 0.25}
+	if !reflect.DeepEqual(sums, expect) {
+		t.Errorf("sumLatestValues(): %v should be %v", sums, expect)
+	}
+}
+
+func TestLabelFilters(t *testing.T) {
+	labels := make(labelFilters)
+	if err := labels.Set("env=production"); err != nil {
+		t.Errorf("Set(): %s", err)
+	}
+	if err := labels.Set("env"); err == nil {
+		t.Errorf("Set(): the value should be required")
+	}
+	if labels.String() != "env=production" {
+		t.Errorf("String(): %s should be env=production", labels.String())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -17,20 +18,72 @@ import (
 
 var logger = logging.GetLogger("metrics.plugin.jvm")
 
+// Garbage collectors which can be detected from the JVM flags
+const (
+	collectorSerial     = "serial"
+	collectorParallel   = "parallel"
+	collectorCMS        = "cms"
+	collectorG1         = "g1"
+	collectorZ          = "z"
+	collectorShenandoah = "shenandoah"
+)
+
 // JVMPlugin plugin for JVM
 type JVMPlugin struct {
-	Target    string
-	Lvmid     string
-	JstatPath string
-	JinfoPath string
-	JavaName  string
-	Tempfile  string
+	Target          string
+	Lvmid           string
+	JstatPath       string
+	JinfoPath       string
+	JcmdPath        string
+	JpsPath         string
+	JavaName        string
+	JavaNamePattern *regexp.Regexp
+	Collector       string
+	Tempfile        string
 }
 
 // # jps
 // 26547 NettyServer
 // 6438 Jps
 func fetchLvmidByAppname(appname, target, jpsPath string) (string, error) {
+	jvms, err := fetchJps(target, jpsPath)
+	if err != nil {
+		return "", err
+	}
+	for _, jvm := range jvms {
+		if jvm.name == appname {
+			return jvm.lvmid, nil
+		}
+	}
+	return "", fmt.Errorf("cannot get lvmid from %s (please run with the java process user)", appname)
+}
+
+// fetchLvmidsByAppnamePattern returns lvmids keyed by the name of every JVM matching the pattern
+func fetchLvmidsByAppnamePattern(pattern *regexp.Regexp, target, jpsPath string) (map[string]string, error) {
+	jvms, err := fetchJps(target, jpsPath)
+	if err != nil {
+		return nil, err
+	}
+	lvmids := make(map[string]string)
+	for _, jvm := range jvms {
+		if jvm.name == "Jps" || !pattern.MatchString(jvm.name) {
+			continue
+		}
+		if lvmid, ok := lvmids[jvm.name]; ok {
+			logger.Warningf("Multiple JVMs are named %s. lvmid %s is ignored in favor of %s.", jvm.name, jvm.lvmid, lvmid)
+			continue
+		}
+		lvmids[jvm.name] = jvm.lvmid
+	}
+	return lvmids, nil
+}
+
+type jpsEntry struct {
+	lvmid string
+	name  string
+}
+
+func fetchJps(target, jpsPath string) ([]jpsEntry, error) {
 	stdout, _, exitStatus, err := runTimeoutCommand(jpsPath, target)
 
 	if err == nil && exitStatus.IsTimedOut() {
@@ -38,20 +91,21 @@ func fetchLvmidByAppname(appname, target, jpsPath string) (string, error) {
 	}
 	if err != nil {
 		logger.Errorf("Failed to run exec jps. %s. Please run with the java process user.", err)
-		return "", err
+		return nil, err
 	}
+	return parseJps(stdout), nil
+}
 
-	for _, line := range strings.Split(string(stdout), "\n") {
+func parseJps(out string) []jpsEntry {
+	var jvms []jpsEntry
+	for _, line := range strings.Split(out, "\n") {
 		words := strings.Split(line, " ")
 		if len(words) != 2 {
 			continue
 		}
-		lvmid, name := words[0], words[1]
-		if name == appname {
-			return lvmid, nil
-		}
+		jvms = append(jvms, jpsEntry{lvmid: words[0], name: words[1]})
 	}
-	return "", fmt.Errorf("cannot get lvmid from %s (please run with the java process user)", appname)
+	return jvms
 }
 
 func fetchJstatMetrics(lvmid, option, jstatPath string) (map[string]float64, error) {
@@ -66,11 +120,21 @@ func fetchJstatMetrics(lvmid, option, jstatPath string) (map[string]float64, err
 	}
 
 	lines := strings.Split(string(stdout), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("unexpected output of jstat %s: %q", option, stdout)
+	}
 	keys := strings.Fields(lines[0])
 	values := strings.Fields(lines[1])
 
 	stat := make(map[string]float64)
 	for i, key := range keys {
+		if i >= len(values) {
+			break
+		}
+		// Some columns are "-" when the collector has no such space (e.g. survivors of ZGC)
+		if values[i] == "-" {
+			continue
+		}
 		value, err := strconv.ParseFloat(values[i], 64)
 		if err != nil {
 			logger.Warningf("Failed to parse value. %s", err)
@@ -81,49 +145,157 @@ func fetchJstatMetrics(lvmid, option, jstatPath string) (map[string]float64, err
 	return stat, nil
 }
 
-func calculateMemorySpaceRate(gcStat map[string]float64, m JVMPlugin) (map[string]float64, error) {
+func calculateMemorySpaceRate(gcStat map[string]float64, lvmid, collector, jinfoPath string) (map[string]float64, error) {
 	ret := make(map[string]float64)
 	ret["oldSpaceRate"] = gcStat["OU"] / gcStat["OC"] * 100
 	ret["newSpaceRate"] = (gcStat["S0U"] + gcStat["S1U"] + gcStat["EU"]) / (gcStat["S0C"] + gcStat["S1C"] + gcStat["EC"]) * 100
-	if checkCMSGC(m.Lvmid, m.JinfoPath) {
-		ret["CMSInitiatingOccupancyFraction"] = fetchCMSInitiatingOccupancyFraction(m.Lvmid, m.JinfoPath)
+	switch collector {
+	case collectorCMS:
+		fraction, err := fetchFlagValue(lvmid, jinfoPath, "CMSInitiatingOccupancyFraction")
+		if err != nil {
+			return nil, err
+		}
+		ret["CMSInitiatingOccupancyFraction"] = fraction
+	case collectorG1:
+		percent, err := fetchFlagValue(lvmid, jinfoPath, "InitiatingHeapOccupancyPercent")
+		if err != nil {
+			return nil, err
+		}
+		ret["InitiatingHeapOccupancyPercent"] = percent
 	}
 
 	return ret, nil
 }
 
-func checkCMSGC(lvmid, JinfoPath string) bool {
-	stdout, _, exitStatus, err := runTimeoutCommand(JinfoPath, "-flag", "UseConcMarkSweepGC", lvmid)
+// # jinfo -flags <vmid>
+// VM Flags:
+// -XX:CICompilerCount=3 -XX:InitialHeapSize=264241152 -XX:MaxHeapSize=4200595456 -XX:+UseCompressedOops -XX:+UseG1GC
+func detectCollector(lvmid, jinfoPath string) (string, error) {
+	stdout, _, exitStatus, err := runTimeoutCommand(jinfoPath, "-flags", lvmid)
 
 	if err == nil && exitStatus.IsTimedOut() {
 		err = fmt.Errorf("jinfo command timed out")
-		os.Exit(1)
 	}
 	if err != nil {
 		logger.Errorf("Failed to run exec jinfo. %s. Please run with the java process user.", err)
-		os.Exit(1)
+		return "", err
 	}
-	return strings.Index(string(stdout), "+UseConcMarkSweepGC") != -1
+	return collectorFromFlags(stdout), nil
 }
 
-func fetchCMSInitiatingOccupancyFraction(lvmid, JinfoPath string) float64 {
-	var fraction float64
+func collectorFromFlags(flags string) string {
+	switch {
+	case strings.Contains(flags, "+UseZGC"):
+		return collectorZ
+	case strings.Contains(flags, "+UseShenandoahGC"):
+		return collectorShenandoah
+	case strings.Contains(flags, "+UseG1GC"):
+		return collectorG1
+	case strings.Contains(flags, "+UseConcMarkSweepGC"):
+		return collectorCMS
+	case strings.Contains(flags, "+UseParallelGC"), strings.Contains(flags, "+UseParallelOldGC"):
+		return collectorParallel
+	}
+	return collectorSerial
+}
 
-	stdout, _, exitStatus, err := runTimeoutCommand(JinfoPath, "-flag", "CMSInitiatingOccupancyFraction", lvmid)
+func fetchFlagValue(lvmid, jinfoPath, name string) (float64, error) {
+	stdout, _, exitStatus, err := runTimeoutCommand(jinfoPath, "-flag", name, lvmid)
 
 	if err == nil && exitStatus.IsTimedOut() {
 		err = fmt.Errorf("jinfo command timed out")
 	}
 	if err != nil {
 		logger.Errorf("Failed to run exec jinfo. %s. Please run with the java process user.", err)
-		os.Exit(1)
+		return 0, err
 	}
 
 	out := strings.Trim(string(stdout), "\n")
 	tmp := strings.Split(out, "=")
-	fraction, _ = strconv.ParseFloat(tmp[1], 64)
+	if len(tmp) != 2 {
+		return 0, fmt.Errorf("unexpected output of jinfo -flag %s: %q", name, out)
+	}
+	return strconv.ParseFloat(tmp[1], 64)
+}
+
+// <Java11 G1>
+// # jcmd <vmid> GC.heap_info
+//  garbage-first heap   total 262144K, used 21504K [0x0000000700000000, 0x0000000800000000)
+//   region size 1024K, 21 young (21504K), 0 survivors (0K)
+//  Metaspace       used 6399K, capacity 6531K, committed 6784K, reserved 1056768K
+
+// <Java17 ZGC>
+// # jcmd <vmid> GC.heap_info
+//  ZHeap           used 18M, capacity 256M, max capacity 4096M
+//  Metaspace       used 585K, committed 768K, reserved 1056768K
+
+// <Java17 Shenandoah>
+// # jcmd <vmid> GC.heap_info
+// Shenandoah Heap
+//  4096M max, 4096M soft max, 256M committed, 32512K used
+//  2048 x 2048K regions
+
+var (
+	g1HeapRe         = regexp.MustCompile(`garbage-first heap\s+total (\d+)([KMG]), used (\d+)([KMG])`)
+	g1RegionRe       = regexp.MustCompile(`region size (\d+)([KMG]), (\d+) young \(\d+[KMG]\), (\d+) survivors`)
+	zHeapRe          = regexp.MustCompile(`ZHeap\s+used (\d+)([KMG]), capacity (\d+)([KMG]), max capacity (\d+)([KMG])`)
+	shenandoahHeapRe = regexp.MustCompile(`(\d+)([KMG]) max, (?:\d+[KMG] soft max, )?(\d+)([KMG]) committed, (\d+)([KMG]) used`)
+)
+
+func fetchHeapInfo(lvmid, jcmdPath string) (map[string]float64, error) {
+	stdout, _, exitStatus, err := runTimeoutCommand(jcmdPath, lvmid, "GC.heap_info")
+
+	if err == nil && exitStatus.IsTimedOut() {
+		err = fmt.Errorf("jcmd command timed out")
+	}
+	if err != nil {
+		logger.Errorf("Failed to run exec jcmd. %s. Please run with the java process user.", err)
+		return nil, err
+	}
+	return parseHeapInfo(stdout), nil
+}
+
+// parseHeapInfo returns heap sizes in KB and G1 region counts
+func parseHeapInfo(out string) map[string]float64 {
+	stat := make(map[string]float64)
+	if m := g1HeapRe.FindStringSubmatch(out); m != nil {
+		stat["HeapCapacity"] = toKB(m[1], m[2])
+		stat["HeapUsed"] = toKB(m[3], m[4])
+		if r := g1RegionRe.FindStringSubmatch(out); r != nil {
+			regionSize := toKB(r[1], r[2])
+			if regionSize > 0 {
+				stat["G1RegionsTotal"] = stat["HeapCapacity"] / regionSize
+				stat["G1RegionsUsed"] = stat["HeapUsed"] / regionSize
+			}
+			stat["G1RegionsYoung"], _ = strconv.ParseFloat(r[3], 64)
+			stat["G1RegionsSurvivor"], _ = strconv.ParseFloat(r[4], 64)
+		}
+	}
+	if m := zHeapRe.FindStringSubmatch(out); m != nil {
+		stat["HeapUsed"] = toKB(m[1], m[2])
+		stat["HeapCapacity"] = toKB(m[3], m[4])
+		stat["HeapMax"] = toKB(m[5], m[6])
+	}
+	if m := shenandoahHeapRe.FindStringSubmatch(out); m != nil {
+		stat["HeapMax"] = toKB(m[1], m[2])
+		stat["HeapCapacity"] = toKB(m[3], m[4])
+		stat["HeapUsed"] = toKB(m[5], m[6])
+	}
+	return stat
+}
 
-	return fraction
+func toKB(value, unit string) float64 {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case "M":
+		v *= 1024
+	case "G":
+		v *= 1024 * 1024
+	}
+	return v
 }
 
 func mergeStat(dst, src map[string]float64) {
@@ -170,36 +342,72 @@ func runTimeoutCommand(Path string, Args ...string) (string, string, timeout.Exi
 //  S0C    S1C    S0U    S1U   TT MTT  DSS      EC       EU     YGC     YGCT
 // 3072.0 3072.0    0.0 2848.0  1  15 3072.0 693248.0 626782.2   3463   33.658
 
-// FetchMetrics interface for mackerelplugin
-func (m JVMPlugin) FetchMetrics() (map[string]interface{}, error) {
-	gcStat, err := fetchJstatMetrics(m.Lvmid, "-gc", m.JstatPath)
+// <Java17 ZGC>
+// # jstat -gc <vmid>
+//     S0C         S1C         S0U         S1U          EC           EU           OC           OU          MC         MU       CCSC      CCSU     YGC     YGCT     FGC    FGCT     CGC    CGCT       GCT
+//         -           -           -           -            -            -     262144.0      18432.0     6720.0     6365.8     704.0     569.4        0     0.000     0     0.000    10     0.004     0.004
+
+func isGenerational(collector string) bool {
+	return collector != collectorZ && collector != collectorShenandoah
+}
+
+func fetchStat(lvmid, collector string, m JVMPlugin) (map[string]float64, error) {
+	gcStat, err := fetchJstatMetrics(lvmid, "-gc", m.JstatPath)
 	if err != nil {
 		return nil, err
 	}
-	gcCapacityStat, err := fetchJstatMetrics(m.Lvmid, "-gccapacity", m.JstatPath)
+	gcCapacityStat, err := fetchJstatMetrics(lvmid, "-gccapacity", m.JstatPath)
 	if err != nil {
 		return nil, err
 	}
-	gcNewStat, err := fetchJstatMetrics(m.Lvmid, "-gcnew", m.JstatPath)
-	if err != nil {
-		return nil, err
+
+	stat := make(map[string]float64)
+	mergeStat(stat, gcStat)
+	mergeStat(stat, gcCapacityStat)
+
+	if isGenerational(collector) {
+		gcNewStat, err := fetchJstatMetrics(lvmid, "-gcnew", m.JstatPath)
+		if err != nil {
+			return nil, err
+		}
+		gcOldStat, err := fetchJstatMetrics(lvmid, "-gcold", m.JstatPath)
+		if err != nil {
+			return nil, err
+		}
+		gcSpaceRate, err := calculateMemorySpaceRate(gcStat, lvmid, collector, m.JinfoPath)
+		if err != nil {
+			return nil, err
+		}
+		mergeStat(stat, gcNewStat)
+		mergeStat(stat, gcOldStat)
+		mergeStat(stat, gcSpaceRate)
 	}
-	gcOldStat, err := fetchJstatMetrics(m.Lvmid, "-gcold", m.JstatPath)
-	if err != nil {
-		return nil, err
+
+	switch collector {
+	case collectorG1, collectorZ, collectorShenandoah:
+		// GC.heap_info is available since Java 9, so missing it is not fatal
+		heapInfo, err := fetchHeapInfo(lvmid, m.JcmdPath)
+		if err != nil {
+			logger.Warningf("Failed to fetch heap info of %s. %s", lvmid, err)
+		} else {
+			mergeStat(stat, heapInfo)
+		}
+	}
+
+	return stat, nil
+}
+
+// FetchMetrics interface for mackerelplugin
+func (m JVMPlugin) FetchMetrics() (map[string]interface{}, error) {
+	if m.JavaNamePattern != nil {
+		return m.fetchMetricsByPattern()
 	}
-	gcSpaceRate, err := calculateMemorySpaceRate(gcStat, m)
+
+	stat, err := fetchStat(m.Lvmid, m.Collector, m)
 	if err != nil {
 		return nil, err
 	}
 
-	stat := make(map[string]float64)
-	mergeStat(stat, gcStat)
-	mergeStat(stat, gcCapacityStat)
-	mergeStat(stat, gcNewStat)
-	mergeStat(stat, gcOldStat)
-	mergeStat(stat, gcSpaceRate)
-
 	result := make(map[string]interface{})
 	for k, v := range stat {
 		result[k] = v
@@ -207,87 +415,216 @@ func (m JVMPlugin) FetchMetrics() (map[string]interface{}, error) {
 	return result, nil
 }
 
-// GraphDefinition interface for mackerelplugin
-func (m JVMPlugin) GraphDefinition() map[string]mp.Graphs {
-	rawJavaName := m.JavaName
-	lowerJavaName := strings.ToLower(m.JavaName)
-	return map[string]mp.Graphs{
-		fmt.Sprintf("jvm.%s.gc_events", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s GC events", rawJavaName),
-			Unit:  "integer",
-			Metrics: []mp.Metrics{
-				{Name: "YGC", Label: "Young GC event", Diff: true},
-				{Name: "FGC", Label: "Full GC event", Diff: true},
-			},
+func (m JVMPlugin) fetchMetricsByPattern() (map[string]interface{}, error) {
+	lvmids, err := fetchLvmidsByAppnamePattern(m.JavaNamePattern, m.Target, m.JpsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	for name, lvmid := range lvmids {
+		// The JVM can exit while collecting, so the rest of JVMs are still reported
+		collector, err := detectCollector(lvmid, m.JinfoPath)
+		if err != nil {
+			logger.Warningf("Failed to detect the garbage collector of %s. %s", name, err)
+			continue
+		}
+		stat, err := fetchStat(lvmid, collector, m)
+		if err != nil {
+			logger.Warningf("Failed to fetch metrics of %s. %s", name, err)
+			continue
+		}
+		key := normalizeMetricName(name)
+		for _, graphName := range graphNamesFor(collector) {
+			for _, metric := range graphdef[graphName].Metrics {
+				if v, ok := stat[metric.Name]; ok {
+					result[fmt.Sprintf("jvm.%s.%s.%s", key, graphName, metric.Name)] = v
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+var normalizeMetricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeMetricName(name string) string {
+	return normalizeMetricNameRe.ReplaceAllString(strings.ToLower(name), "_")
+}
+
+// graphdef holds graphs keyed by the last part of the graph name, and their labels follow "JVM <javaname>"
+var graphdef = map[string]mp.Graphs{
+	"gc_events": {
+		Label: "GC events",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "YGC", Label: "Young GC event", Diff: true},
+			{Name: "FGC", Label: "Full GC event", Diff: true},
+		},
+	},
+	"gc_time": {
+		Label: "GC time (sec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "YGCT", Label: "Young GC time", Diff: true},
+			{Name: "FGCT", Label: "Full GC time", Diff: true},
 		},
-		fmt.Sprintf("jvm.%s.gc_time", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s GC time (sec)", rawJavaName),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "YGCT", Label: "Young GC time", Diff: true},
-				{Name: "FGCT", Label: "Full GC time", Diff: true},
-			},
+	},
+	"gc_time_percentage": {
+		Label: "GC time percentage",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			// gc_time_percentage is the percentage of gc time to 60 sec.
+			{Name: "YGCT", Label: "Young GC time", Diff: true, Scale: (100.0 / 60)},
+			{Name: "FGCT", Label: "Full GC time", Diff: true, Scale: (100.0 / 60)},
 		},
-		fmt.Sprintf("jvm.%s.gc_time_percentage", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s GC time percentage", rawJavaName),
-			Unit:  "percentage",
-			Metrics: []mp.Metrics{
-				// gc_time_percentage is the percentage of gc time to 60 sec.
-				{Name: "YGCT", Label: "Young GC time", Diff: true, Scale: (100.0 / 60)},
-				{Name: "FGCT", Label: "Full GC time", Diff: true, Scale: (100.0 / 60)},
-			},
+	},
+	"new_space": {
+		Label: "New Space memory",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "NGCMX", Label: "New max", Diff: false, Scale: 1024},
+			{Name: "NGC", Label: "New current", Diff: false, Scale: 1024},
+			{Name: "EU", Label: "Eden used", Diff: false, Scale: 1024},
+			{Name: "S0U", Label: "Survivor0 used", Diff: false, Scale: 1024},
+			{Name: "S1U", Label: "Survivor1 used", Diff: false, Scale: 1024},
 		},
-		fmt.Sprintf("jvm.%s.new_space", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s New Space memory", rawJavaName),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "NGCMX", Label: "New max", Diff: false, Scale: 1024},
-				{Name: "NGC", Label: "New current", Diff: false, Scale: 1024},
-				{Name: "EU", Label: "Eden used", Diff: false, Scale: 1024},
-				{Name: "S0U", Label: "Survivor0 used", Diff: false, Scale: 1024},
-				{Name: "S1U", Label: "Survivor1 used", Diff: false, Scale: 1024},
-			},
+	},
+	"old_space": {
+		Label: "Old Space memory",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "OGCMX", Label: "Old max", Diff: false, Scale: 1024},
+			{Name: "OGC", Label: "Old current", Diff: false, Scale: 1024},
+			{Name: "OU", Label: "Old used", Diff: false, Scale: 1024},
 		},
-		fmt.Sprintf("jvm.%s.old_space", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s Old Space memory", rawJavaName),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "OGCMX", Label: "Old max", Diff: false, Scale: 1024},
-				{Name: "OGC", Label: "Old current", Diff: false, Scale: 1024},
-				{Name: "OU", Label: "Old used", Diff: false, Scale: 1024},
-			},
+	},
+	"perm_space": {
+		Label: "Permanent Space",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "PGCMX", Label: "Perm max", Diff: false, Scale: 1024},
+			{Name: "PGC", Label: "Perm current", Diff: false, Scale: 1024},
+			{Name: "PU", Label: "Perm used", Diff: false, Scale: 1024},
 		},
-		fmt.Sprintf("jvm.%s.perm_space", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s Permanent Space", rawJavaName),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "PGCMX", Label: "Perm max", Diff: false, Scale: 1024},
-				{Name: "PGC", Label: "Perm current", Diff: false, Scale: 1024},
-				{Name: "PU", Label: "Perm used", Diff: false, Scale: 1024},
-			},
+	},
+	"metaspace": {
+		Label: "Metaspace",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "MCMX", Label: "Metaspace capacity max", Diff: false, Scale: 1024},
+			{Name: "MCMN", Label: "Metaspace capacity min", Diff: false, Scale: 1024},
+			{Name: "MC", Label: "Metaspace capacity", Diff: false, Scale: 1024},
+			{Name: "MU", Label: "Metaspace utilization ", Diff: false, Scale: 1024},
+			{Name: "CCSC", Label: "Compressed Class Space Capacity", Diff: false, Scale: 1024},
+			{Name: "CCSU", Label: "Compressed Class Space Used", Diff: false, Scale: 1024},
 		},
-		fmt.Sprintf("jvm.%s.metaspace", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s Metaspace", rawJavaName),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "MCMX", Label: "Metaspace capacity max", Diff: false, Scale: 1024},
-				{Name: "MCMN", Label: "Metaspace capacity min", Diff: false, Scale: 1024},
-				{Name: "MC", Label: "Metaspace capacity", Diff: false, Scale: 1024},
-				{Name: "MU", Label: "Metaspace utilization ", Diff: false, Scale: 1024},
-				{Name: "CCSC", Label: "Compressed Class Space Capacity", Diff: false, Scale: 1024},
-				{Name: "CCSU", Label: "Compressed Class Space Used", Diff: false, Scale: 1024},
-			},
+	},
+	"memorySpace": {
+		Label: "MemorySpace",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "oldSpaceRate", Label: "GC Old Memory Space", Diff: false},
+			{Name: "newSpaceRate", Label: "GC New Memory Space", Diff: false},
+			{Name: "CMSInitiatingOccupancyFraction", Label: "CMS Initiating Occupancy Fraction", Diff: false},
+			{Name: "InitiatingHeapOccupancyPercent", Label: "G1 Initiating Heap Occupancy Percent", Diff: false},
 		},
-		fmt.Sprintf("jvm.%s.memorySpace", lowerJavaName): {
-			Label: fmt.Sprintf("JVM %s MemorySpace", rawJavaName),
-			Unit:  "float",
-			Metrics: []mp.Metrics{
-				{Name: "oldSpaceRate", Label: "GC Old Memory Space", Diff: false},
-				{Name: "newSpaceRate", Label: "GC New Memory Space", Diff: false},
-				{Name: "CMSInitiatingOccupancyFraction", Label: "CMS Initiating Occupancy Fraction", Diff: false},
-			},
+	},
+	"concurrent_gc_events": {
+		Label: "Concurrent GC events",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "CGC", Label: "Concurrent GC event", Diff: true},
 		},
+	},
+	"concurrent_gc_time": {
+		Label: "Concurrent GC time (sec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "CGCT", Label: "Concurrent GC time", Diff: true},
+		},
+	},
+	"g1_regions": {
+		Label: "G1 Heap Regions",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "G1RegionsTotal", Label: "Total", Diff: false},
+			{Name: "G1RegionsUsed", Label: "Used", Diff: false},
+			{Name: "G1RegionsYoung", Label: "Young", Diff: false},
+			{Name: "G1RegionsSurvivor", Label: "Survivor", Diff: false},
+		},
+	},
+	"zgc_pauses": {
+		Label: "ZGC pauses",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			// ZGC reports its pauses as the concurrent collector of jstat
+			{Name: "CGC", Label: "Pause", Diff: true},
+		},
+	},
+	"zgc_pause_time": {
+		Label: "ZGC pause time (sec)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "CGCT", Label: "Pause time", Diff: true},
+		},
+	},
+	"heap": {
+		Label: "Heap memory",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "HeapMax", Label: "Heap max", Diff: false, Scale: 1024},
+			{Name: "HeapCapacity", Label: "Heap capacity", Diff: false, Scale: 1024},
+			{Name: "HeapUsed", Label: "Heap used", Diff: false, Scale: 1024},
+		},
+	},
+}
+
+var commonGraphNames = []string{"gc_events", "gc_time", "gc_time_percentage", "perm_space", "metaspace"}
+
+var collectorGraphNames = map[string][]string{
+	collectorSerial:     {"new_space", "old_space", "memorySpace"},
+	collectorParallel:   {"new_space", "old_space", "memorySpace"},
+	collectorCMS:        {"new_space", "old_space", "memorySpace"},
+	collectorG1:         {"new_space", "old_space", "memorySpace", "concurrent_gc_events", "concurrent_gc_time", "g1_regions", "heap"},
+	collectorZ:          {"zgc_pauses", "zgc_pause_time", "heap"},
+	collectorShenandoah: {"concurrent_gc_events", "concurrent_gc_time", "heap"},
+}
+
+// graphNamesFor returns the graphs for the collector, or all graphs when the collector is unknown
+func graphNamesFor(collector string) []string {
+	if names, ok := collectorGraphNames[collector]; ok {
+		return append(append([]string{}, commonGraphNames...), names...)
+	}
+	names := make([]string, 0, len(graphdef))
+	for name := range graphdef {
+		names = append(names, name)
+	}
+	return names
+}
+
+// GraphDefinition interface for mackerelplugin
+func (m JVMPlugin) GraphDefinition() map[string]mp.Graphs {
+	keyName := strings.ToLower(m.JavaName)
+	labelPrefix := fmt.Sprintf("JVM %s", m.JavaName)
+	collector := m.Collector
+	if m.JavaNamePattern != nil {
+		// Each JVM matching the pattern may run a different collector
+		keyName = "#"
+		labelPrefix = "JVM"
+		collector = ""
+	}
+
+	graphs := make(map[string]mp.Graphs)
+	for _, name := range graphNamesFor(collector) {
+		g := graphdef[name]
+		graphs[fmt.Sprintf("jvm.%s.%s", keyName, name)] = mp.Graphs{
+			Label:   fmt.Sprintf("%s %s", labelPrefix, g.Label),
+			Unit:    g.Unit,
+			Metrics: g.Metrics,
+		}
 	}
+	return graphs
 }
 
 // Do the plugin
@@ -296,8 +633,10 @@ func Do() {
 	optPort := flag.String("port", "1099", "Port")
 	optJstatPath := flag.String("jstatpath", "/usr/bin/jstat", "jstat path")
 	optJinfoPath := flag.String("jinfopath", "/usr/bin/jinfo", "jinfo path")
+	optJcmdPath := flag.String("jcmdpath", "/usr/bin/jcmd", "jcmd path")
 	optJpsPath := flag.String("jpspath", "/usr/bin/jps", "jps path")
 	optJavaName := flag.String("javaname", "", "Java app name")
+	optJavaNamePattern := flag.String("javanamepattern", "", "Regexp of Java app names to monitor every matching JVM")
 	optPidFile := flag.String("pidfile", "", "pidfile path")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
@@ -306,33 +645,50 @@ func Do() {
 	jvm.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
 	jvm.JstatPath = *optJstatPath
 	jvm.JinfoPath = *optJinfoPath
+	jvm.JcmdPath = *optJcmdPath
+	jvm.JpsPath = *optJpsPath
 
-	if *optJavaName == "" {
-		logger.Errorf("javaname is required (if you use 'pidfile' option, 'javaname' is used as just a prefix of graph label)")
-		flag.PrintDefaults()
-		os.Exit(1)
-	}
-
-	if *optPidFile == "" {
-		lvmid, err := fetchLvmidByAppname(*optJavaName, jvm.Target, *optJpsPath)
+	if *optJavaNamePattern != "" {
+		pattern, err := regexp.Compile(*optJavaNamePattern)
 		if err != nil {
-			logger.Errorf("Failed to fetch lvmid. %s. Please run with the java process user.", err)
+			logger.Errorf("Failed to compile javanamepattern. %s", err)
 			os.Exit(1)
 		}
-		jvm.Lvmid = lvmid
+		jvm.JavaNamePattern = pattern
 	} else {
-		// https://docs.oracle.com/javase/7/docs/technotes/tools/share/jps.html
-		// `The lvmid is typically, but not necessarily, the operating system's process identifier for the JVM process.`
-		pid, err := ioutil.ReadFile(*optPidFile)
+		if *optJavaName == "" {
+			logger.Errorf("javaname or javanamepattern is required (if you use 'pidfile' option, 'javaname' is used as just a prefix of graph label)")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+
+		if *optPidFile == "" {
+			lvmid, err := fetchLvmidByAppname(*optJavaName, jvm.Target, *optJpsPath)
+			if err != nil {
+				logger.Errorf("Failed to fetch lvmid. %s. Please run with the java process user.", err)
+				os.Exit(1)
+			}
+			jvm.Lvmid = lvmid
+		} else {
+			// https://docs.oracle.com/javase/7/docs/technotes/tools/share/jps.html
+			// `The lvmid is typically, but not necessarily, the operating system's process identifier for the JVM process.`
+			pid, err := ioutil.ReadFile(*optPidFile)
+			if err != nil {
+				logger.Errorf("Failed to load pid. %s", err)
+				os.Exit(1)
+			}
+			jvm.Lvmid = strings.Replace(string(pid), "\n", "", 1)
+		}
+
+		collector, err := detectCollector(jvm.Lvmid, jvm.JinfoPath)
 		if err != nil {
-			logger.Errorf("Failed to load pid. %s", err)
+			logger.Errorf("Failed to detect the garbage collector. %s", err)
 			os.Exit(1)
 		}
-		jvm.Lvmid = strings.Replace(string(pid), "\n", "", 1)
+		jvm.Collector = collector
+		jvm.JavaName = *optJavaName
 	}
 
-	jvm.JavaName = *optJavaName
-
 	helper := mp.NewMackerelPlugin(jvm)
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -0,0 +1,114 @@
+package mpjvm
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseJps(t *testing.T) {
+	jvms := parseJps("26547 NettyServer\n6438 Jps\n7001\n")
+	if len(jvms) != 2 {
+		t.Fatalf("parseJps: size should be 2, but '%d'", len(jvms))
+	}
+	if jvms[0].lvmid != "26547" || jvms[0].name != "NettyServer" {
+		t.Errorf("parseJps: unexpected entry %+v", jvms[0])
+	}
+}
+
+func TestCollectorFromFlags(t *testing.T) {
+	cases := map[string]string{
+		"VM Flags:\n-XX:CICompilerCount=3 -XX:+UseCompressedOops -XX:+UseG1GC":   collectorG1,
+		"VM Flags:\n-XX:+UseZGC -XX:ZCollectionInterval=0":                       collectorZ,
+		"VM Flags:\n-XX:+UseShenandoahGC":                                        collectorShenandoah,
+		"VM Flags:\n-XX:+UseConcMarkSweepGC -XX:+UseParNewGC":                    collectorCMS,
+		"VM Flags:\n-XX:InitialHeapSize=264241152 -XX:+UseParallelGC":            collectorParallel,
+		"VM Flags:\n-XX:InitialHeapSize=264241152 -XX:+UseSerialGC":              collectorSerial,
+		"VM Flags:\n-XX:-UseG1GC -XX:InitialHeapSize=264241152 -XX:+UseSerialGC": collectorSerial,
+	}
+	for flags, expected := range cases {
+		if got := collectorFromFlags(flags); got != expected {
+			t.Errorf("collectorFromFlags(%q) should be %s, but '%s'", flags, expected, got)
+		}
+	}
+}
+
+func TestParseHeapInfoG1(t *testing.T) {
+	stat := parseHeapInfo(` garbage-first heap   total 262144K, used 21504K [0x0000000700000000, 0x0000000800000000)
+  region size 1024K, 21 young (21504K), 3 survivors (3072K)
+ Metaspace       used 6399K, capacity 6531K, committed 6784K, reserved 1056768K
+`)
+	expected := map[string]float64{
+		"HeapCapacity":      262144,
+		"HeapUsed":          21504,
+		"G1RegionsTotal":    256,
+		"G1RegionsUsed":     21,
+		"G1RegionsYoung":    21,
+		"G1RegionsSurvivor": 3,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("parseHeapInfo: %s should be %f, but '%f'", k, v, stat[k])
+		}
+	}
+}
+
+func TestParseHeapInfoZ(t *testing.T) {
+	stat := parseHeapInfo(` ZHeap           used 18M, capacity 256M, max capacity 4G
+ Metaspace       used 585K, committed 768K, reserved 1056768K
+`)
+	expected := map[string]float64{
+		"HeapUsed":     18 * 1024,
+		"HeapCapacity": 256 * 1024,
+		"HeapMax":      4 * 1024 * 1024,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("parseHeapInfo: %s should be %f, but '%f'", k, v, stat[k])
+		}
+	}
+}
+
+func TestParseHeapInfoShenandoah(t *testing.T) {
+	stat := parseHeapInfo(`Shenandoah Heap
+ 4096M max, 4096M soft max, 256M committed, 32512K used
+ 2048 x 2048K regions
+`)
+	expected := map[string]float64{
+		"HeapMax":      4096 * 1024,
+		"HeapCapacity": 256 * 1024,
+		"HeapUsed":     32512,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("parseHeapInfo: %s should be %f, but '%f'", k, v, stat[k])
+		}
+	}
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var jvm JVMPlugin
+	jvm.JavaName = "NettyServer"
+	jvm.Collector = collectorZ
+	graphs := jvm.GraphDefinition()
+	if _, ok := graphs["jvm.nettyserver.zgc_pauses"]; !ok {
+		t.Errorf("GraphDefinition: zgc_pauses should be defined for ZGC")
+	}
+	if _, ok := graphs["jvm.nettyserver.old_space"]; ok {
+		t.Errorf("GraphDefinition: old_space should not be defined for ZGC")
+	}
+	if label := graphs["jvm.nettyserver.gc_events"].Label; label != "JVM NettyServer GC events" {
+		t.Errorf("GraphDefinition: unexpected label '%s'", label)
+	}
+
+	jvm.JavaNamePattern = regexp.MustCompile(`Server$`)
+	graphs = jvm.GraphDefinition()
+	if len(graphs) != len(graphdef) {
+		t.Errorf("GraphDefinition: size should be %d, but '%d'", len(graphdef), len(graphs))
+	}
+	for name := range graphs {
+		if !strings.HasPrefix(name, "jvm.#.") {
+			t.Errorf("GraphDefinition: %s should be a wildcard graph", name)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// +build linux
+
+package mplinuxprocessstats
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/golib/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.linux-process-stats")
+
+// clockTicks is USER_HZ, in which CPU times of /proc/<pid>/stat are counted.
+// It is 100 on all Linux architectures supported by Go.
+const clockTicks = 100
+
+var graphdef = map[string]mp.Graphs{
+	"processes.#": {
+		Label: "Processes",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "count", Label: "Count"},
+		},
+	},
+	"cpu.#": {
+		Label: "CPU",
+		Unit:  "percentage",
+		Metrics: []mp.Metrics{
+			// CPU times are seconds, so the percentage is the difference per minute divided by 60 sec.
+			{Name: "user", Label: "User", Diff: true, Stacked: true, Scale: (100.0 / 60)},
+			{Name: "system", Label: "System", Diff: true, Stacked: true, Scale: (100.0 / 60)},
+		},
+	},
+	"memory.#": {
+		Label: "Memory",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "rss", Label: "RSS"},
+			{Name: "pss", Label: "PSS"},
+			{Name: "vsz", Label: "VSZ"},
+		},
+	},
+	"threads.#": {
+		Label: "Threads",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "threads", Label: "Threads"},
+		},
+	},
+	"context_switches.#": {
+		Label: "Context Switches",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "voluntary", Label: "Voluntary", Diff: true, Stacked: true},
+			{Name: "nonvoluntary", Label: "Nonvoluntary", Diff: true, Stacked: true},
+		},
+	},
+	"io.#": {
+		Label: "I/O",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "read_bytes", Label: "Read", Diff: true},
+			{Name: "write_bytes", Label: "Write", Diff: true},
+		},
+	},
+}
+
+// matchGroup selects processes which are aggregated into a group
+type matchGroup struct {
+	name    string
+	comm    string
+	cmdline *regexp.Regexp
+	pidfile string
+}
+
+// LinuxProcessStatsPlugin mackerel plugin
+type LinuxProcessStatsPlugin struct {
+	Prefix   string
+	groups   []matchGroup
+	procPath string
+}
+
+// procStat is resources used by a process
+type procStat struct {
+	utime        float64
+	stime        float64
+	rss          uint64
+	pss          uint64
+	vsz          uint64
+	threads      uint64
+	voluntary    uint64
+	nonvoluntary uint64
+	readBytes    uint64
+	writeBytes   uint64
+}
+
+// MetricKeyPrefix interface for PluginWithPrefix
+func (p LinuxProcessStatsPlugin) MetricKeyPrefix() string {
+	if p.Prefix == "" {
+		p.Prefix = "linux-process-stats"
+	}
+	return p.Prefix
+}
+
+// GraphDefinition interface for mackerelplugin
+func (p LinuxProcessStatsPlugin) GraphDefinition() map[string]mp.Graphs {
+	labelPrefix := strings.Title(p.MetricKeyPrefix())
+	graphs := make(map[string]mp.Graphs)
+	for key, g := range graphdef {
+		graphs[key] = mp.Graphs{
+			Label:   labelPrefix + " " + g.Label,
+			Unit:    g.Unit,
+			Metrics: g.Metrics,
+		}
+	}
+	return graphs
+}
+
+// FetchMetrics interface for mackerelplugin
+func (p LinuxProcessStatsPlugin) FetchMetrics() (map[string]float64, error) {
+	pids, err := p.listPids()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*procStat)
+	metrics := make(map[string]float64)
+	for _, g := range p.groups {
+		key := normalizeMetricName(g.name)
+		// Report the empty group as well so that its graph drops to zero
+		metrics["processes."+key+".count"] = 0
+
+		groupPids, err := p.matchedPids(g, pids)
+		if err != nil {
+			logger.Warningf("Failed to select processes of %s. %s", g.name, err)
+			continue
+		}
+		var sum procStat
+		for _, pid := range groupPids {
+			st, ok := stats[pid]
+			if !ok {
+				st, err = readProcStat(p.procPath, pid)
+				if err != nil {
+					// The process with pid terminates
+					continue
+				}
+				stats[pid] = st
+			}
+			metrics["processes."+key+".count"]++
+			sum.add(st)
+		}
+		metrics["cpu."+key+".user"] = sum.utime
+		metrics["cpu."+key+".system"] = sum.stime
+		metrics["memory."+key+".rss"] = float64(sum.rss)
+		metrics["memory."+key+".pss"] = float64(sum.pss)
+		metrics["memory."+key+".vsz"] = float64(sum.vsz)
+		metrics["threads."+key+".threads"] = float64(sum.threads)
+		metrics["context_switches."+key+".voluntary"] = float64(sum.voluntary)
+		metrics["context_switches."+key+".nonvoluntary"] = float64(sum.nonvoluntary)
+		metrics["io."+key+".read_bytes"] = float64(sum.readBytes)
+		metrics["io."+key+".write_bytes"] = float64(sum.writeBytes)
+	}
+	return metrics, nil
+}
+
+func (s *procStat) add(o *procStat) {
+	s.utime += o.utime
+	s.stime += o.stime
+	s.rss += o.rss
+	s.pss += o.pss
+	s.vsz += o.vsz
+	s.threads += o.threads
+	s.voluntary += o.voluntary
+	s.nonvoluntary += o.nonvoluntary
+	s.readBytes += o.readBytes
+	s.writeBytes += o.writeBytes
+}
+
+func (p LinuxProcessStatsPlugin) listPids() ([]string, error) {
+	d, err := os.Open(p.procPath)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	self := strconv.Itoa(os.Getpid())
+	var pids []string
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err != nil || name == self {
+			continue
+		}
+		pids = append(pids, name)
+	}
+	return pids, nil
+}
+
+func (p LinuxProcessStatsPlugin) matchedPids(g matchGroup, pids []string) ([]string, error) {
+	if g.pidfile != "" {
+		pid, err := ioutil.ReadFile(g.pidfile)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strings.TrimSpace(string(pid))}, nil
+	}
+
+	var matched []string
+	for _, pid := range pids {
+		if g.comm != "" {
+			comm, err := ioutil.ReadFile(filepath.Join(p.procPath, pid, "comm"))
+			if err != nil || strings.TrimSpace(string(comm)) != g.comm {
+				continue
+			}
+		}
+		if g.cmdline != nil {
+			cmdline, err := ioutil.ReadFile(filepath.Join(p.procPath, pid, "cmdline"))
+			if err != nil || !g.cmdline.MatchString(strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))) {
+				continue
+			}
+		}
+		matched = append(matched, pid)
+	}
+	return matched, nil
+}
+
+// readProcStat reads /proc/<pid>/{stat,status,io,smaps_rollup}.
+// io and smaps_rollup are optional since they are readable only by the owner
+// and smaps_rollup is available since Linux 4.14.
+func readProcStat(procPath, pid string) (*procStat, error) {
+	var st procStat
+	stat, err := ioutil.ReadFile(filepath.Join(procPath, pid, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	if err := parseStat(string(stat), &st); err != nil {
+		return nil, err
+	}
+
+	status, err := ioutil.ReadFile(filepath.Join(procPath, pid, "status"))
+	if err != nil {
+		return nil, err
+	}
+	parseStatus(string(status), &st)
+
+	if io, err := ioutil.ReadFile(filepath.Join(procPath, pid, "io")); err == nil {
+		parseIO(string(io), &st)
+	}
+	if smaps, err := ioutil.ReadFile(filepath.Join(procPath, pid, "smaps_rollup")); err == nil {
+		parseSmapsRollup(string(smaps), &st)
+	}
+	return &st, nil
+}
+
+// # cat /proc/<pid>/stat
+// 1234 (nginx: worker) S 1233 1233 1233 0 -1 4202816 2089 0 0 0 12 34 0 0 20 0 1 0 12345 123456789 2345 18446744073709551615 ...
+func parseStat(stat string, st *procStat) error {
+	// comm may contain spaces and parentheses, so fields are counted from the last ')'
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return fmt.Errorf("unexpected format of stat: %q", stat)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 13 {
+		return fmt.Errorf("unexpected format of stat: %q", stat)
+	}
+	// fields[0] is the 3rd field (state), so utime (14th) and stime (15th) are fields[11] and fields[12]
+	utime, err := strconv.ParseFloat(fields[11], 64)
+	if err != nil {
+		return err
+	}
+	stime, err := strconv.ParseFloat(fields[12], 64)
+	if err != nil {
+		return err
+	}
+	st.utime = utime / clockTicks
+	st.stime = stime / clockTicks
+	return nil
+}
+
+// # cat /proc/<pid>/status
+// VmSize:   123456 kB
+// VmRSS:      9380 kB
+// Threads:        1
+// voluntary_ctxt_switches:        150
+// nonvoluntary_ctxt_switches:     545
+func parseStatus(status string, st *procStat) {
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "VmSize:":
+			st.vsz = v * 1024
+		case "VmRSS:":
+			st.rss = v * 1024
+		case "Threads:":
+			st.threads = v
+		case "voluntary_ctxt_switches:":
+			st.voluntary = v
+		case "nonvoluntary_ctxt_switches:":
+			st.nonvoluntary = v
+		}
+	}
+}
+
+// # cat /proc/<pid>/io
+// rchar: 323934931
+// wchar: 323929600
+// read_bytes: 4096
+// write_bytes: 323932160
+func parseIO(io string, st *procStat) {
+	scanner := bufio.NewScanner(strings.NewReader(io))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "read_bytes:":
+			st.readBytes = v
+		case "write_bytes:":
+			st.writeBytes = v
+		}
+	}
+}
+
+// # cat /proc/<pid>/smaps_rollup
+// 00400000-7ffd7e1f5000 ---p 00000000 00:00 0                              [rollup]
+// Rss:                9380 kB
+// Pss:                3012 kB
+func parseSmapsRollup(smaps string, st *procStat) {
+	scanner := bufio.NewScanner(strings.NewReader(smaps))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Pss:" {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			st.pss = v * 1024
+		}
+		return
+	}
+}
+
+var normalizeMetricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeMetricName(name string) string {
+	return normalizeMetricNameRe.ReplaceAllString(name, "_")
+}
+
+type stringSlice []string
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringSlice) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
+// parseGroups builds match groups from -comm, -cmdline and -pidfile options.
+// -cmdline and -pidfile are given as "<group name>=<value>".
+func parseGroups(comms, cmdlines, pidfiles []string) ([]matchGroup, error) {
+	var groups []matchGroup
+	for _, comm := range comms {
+		groups = append(groups, matchGroup{name: comm, comm: comm})
+	}
+	for _, v := range cmdlines {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("cmdline should be <group name>=<regexp>: %s", v)
+		}
+		re, err := regexp.Compile(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, matchGroup{name: kv[0], cmdline: re})
+	}
+	for _, v := range pidfiles {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("pidfile should be <group name>=<path>: %s", v)
+		}
+		groups = append(groups, matchGroup{name: kv[0], pidfile: kv[1]})
+	}
+	return groups, nil
+}
+
+// Do the plugin
+func Do() {
+	var optComms, optCmdlines, optPidfiles stringSlice
+	flag.Var(&optComms, "comm", "Exact command name of processes (/proc/<pid>/comm). It is also the group name. Can be specified multiple times")
+	flag.Var(&optCmdlines, "cmdline", "<group name>=<regexp> to select processes whose command line matches the regexp. Can be specified multiple times")
+	flag.Var(&optPidfiles, "pidfile", "<group name>=<path> to select the process whose pid is written in the file. Can be specified multiple times")
+	optPrefix := flag.String("metric-key-prefix", "linux-process-stats", "Metric key prefix")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	flag.Parse()
+
+	groups, err := parseGroups(optComms, optCmdlines, optPidfiles)
+	if err != nil {
+		logger.Errorf("%s", err)
+		os.Exit(1)
+	}
+	if len(groups) == 0 {
+		logger.Warningf("At least one of comm, cmdline or pidfile is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	plugin := LinuxProcessStatsPlugin{
+		Prefix:   *optPrefix,
+		groups:   groups,
+		procPath: "/proc",
+	}
+	helper := mp.NewMackerelPlugin(plugin)
+	helper.Tempfile = *optTempfile
+	helper.Run()
+}
@@ -0,0 +1,66 @@
+package mplinuxprocessstats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStat(t *testing.T) {
+	var st procStat
+	err := parseStat("102 (ruby (x) y) R 1 102 102 0 -1 4202816 2089 0 0 0 1000 200 0 0 20 0 2 0 12345 123456789 2345", &st)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 10, st.utime)
+	assert.EqualValues(t, 2, st.stime)
+
+	assert.NotNil(t, parseStat("102 (ruby) R 1", &st))
+}
+
+func TestParseGroups(t *testing.T) {
+	groups, err := parseGroups([]string{"nginx"}, []string{"unicorn=^unicorn worker"}, []string{"app=/var/run/app.pid"})
+	assert.Nil(t, err)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "nginx", groups[0].comm)
+	assert.Equal(t, "unicorn", groups[1].name)
+	assert.Equal(t, "/var/run/app.pid", groups[2].pidfile)
+
+	_, err = parseGroups(nil, []string{"^unicorn worker"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestFetchMetrics(t *testing.T) {
+	groups, _ := parseGroups([]string{"nginx", "httpd"}, []string{"unicorn=^unicorn worker", "nginx-worker=^nginx: worker"}, nil)
+	p := LinuxProcessStatsPlugin{
+		groups:   groups,
+		procPath: "testdata/proc",
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, 2, stat["processes.nginx.count"])
+	assert.EqualValues(t, 4, stat["cpu.nginx.user"])
+	assert.EqualValues(t, 2, stat["cpu.nginx.system"])
+	assert.EqualValues(t, 20000*1024, stat["memory.nginx.rss"])
+	assert.EqualValues(t, 250000*1024, stat["memory.nginx.vsz"])
+	assert.EqualValues(t, 3000*1024, stat["memory.nginx.pss"])
+	assert.EqualValues(t, 5, stat["threads.nginx.threads"])
+	assert.EqualValues(t, 200, stat["context_switches.nginx.voluntary"])
+	assert.EqualValues(t, 15, stat["context_switches.nginx.nonvoluntary"])
+	assert.EqualValues(t, 4096, stat["io.nginx.read_bytes"])
+	assert.EqualValues(t, 8192, stat["io.nginx.write_bytes"])
+
+	assert.EqualValues(t, 1, stat["processes.nginx-worker.count"])
+	assert.EqualValues(t, 1, stat["processes.unicorn.count"])
+	assert.EqualValues(t, 10, stat["cpu.unicorn.user"])
+
+	count, ok := stat["processes.httpd.count"]
+	assert.True(t, ok)
+	assert.EqualValues(t, 0, count)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var p LinuxProcessStatsPlugin
+	graphs := p.GraphDefinition()
+	assert.Len(t, graphs, len(graphdef))
+	assert.Equal(t, "Linux-Process-Stats CPU", graphs["cpu.#"].Label)
+}
@@ -0,0 +1,8 @@
+// +build !linux
+
+package mplinuxprocessstats
+
+// Do the plugin
+func Do() {
+	panic("The mackerel-plugin-linux-process-stats does not work on non Linux environment, of course.")
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/mackerelio/mackerel-agent-plugins/mackerel-plugin-linux-process-stats/lib"
+
+func main() {
+	mplinuxprocessstats.Do()
+}
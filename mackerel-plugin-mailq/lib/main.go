@@ -2,23 +2,27 @@ package mpmailq
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
 
 type mailq struct {
-	command string
-	args    []string
-	line    int
-	pattern string
+	command    string
+	args       []string
+	line       int
+	pattern    string
+	detailArgs []string
 }
 
 var mailqFormats = map[string]mailq{
@@ -27,6 +31,8 @@ var mailqFormats = map[string]mailq{
 		args:    []string{"-p"},
 		line:    -1,
 		pattern: `-- \d+ Kbytes in (\d+) Requests\.`,
+		// postqueue -j is available since Postfix 3.1
+		detailArgs: []string{"-j"},
 	},
 	"qmail": {
 		command: "qmail-qstat",
@@ -39,10 +45,15 @@ var mailqFormats = map[string]mailq{
 	},
 }
 
+// Queues of Postfix reported by postqueue -j
+var postfixQueues = []string{"active", "deferred", "hold", "incoming", "maildrop"}
+
 type plugin struct {
 	path                   string
 	mailq                  mailq
 	keyPrefix, labelPrefix string
+	detail                 bool
+	topDomains             int
 }
 
 func (format *mailq) parse(rd io.Reader) (count uint64, err error) {
@@ -59,8 +70,7 @@ func (format *mailq) parse(rd io.Reader) (count uint64, err error) {
 	return
 }
 
-func (p *plugin) fetchMailqCount() (count uint64, err error) {
-
+func (p *plugin) runMailq(args []string, parse func(io.Reader) error) (err error) {
 	var path string
 	if p.path != "" {
 		path = p.path
@@ -73,11 +83,7 @@ func (p *plugin) fetchMailqCount() (count uint64, err error) {
 
 	cmd := exec.Cmd{
 		Path: path,
-		Args: append([]string{p.mailq.command}, p.mailq.args...),
-	}
-
-	if p.path != "" {
-		cmd.Path = p.path
+		Args: append([]string{p.mailq.command}, args...),
 	}
 
 	stdout, err := cmd.StdoutPipe()
@@ -90,7 +96,7 @@ func (p *plugin) fetchMailqCount() (count uint64, err error) {
 		return
 	}
 
-	count, err = p.mailq.parse(stdout)
+	err = parse(stdout)
 	if err != nil {
 		cmd.Wait()
 		return
@@ -100,7 +106,104 @@ func (p *plugin) fetchMailqCount() (count uint64, err error) {
 	return
 }
 
+func (p *plugin) fetchMailqCount() (count uint64, err error) {
+	err = p.runMailq(p.mailq.args, func(rd io.Reader) (err error) {
+		count, err = p.mailq.parse(rd)
+		return
+	})
+	return
+}
+
+// queueStat is the detail of the mail queue
+type queueStat struct {
+	count         uint64
+	queues        map[string]uint64
+	bytes         uint64
+	oldestArrival int64
+	domains       map[string]uint64
+}
+
+// # postqueue -j
+// {"queue_name": "deferred", "queue_id": "C24DC3C5E4", "arrival_time": 1467213545, "message_size": 2602, "forced_expire": false, "sender": "foobar@example.com", "recipients": [{"address": "nyao@mail.invalid", "delay_reason": "connect to mail.invalid[192.0.2.100]:25: Connection timed out"}]}
+type postqueueMessage struct {
+	QueueName   string `json:"queue_name"`
+	ArrivalTime int64  `json:"arrival_time"`
+	MessageSize uint64 `json:"message_size"`
+	Recipients  []struct {
+		Address string `json:"address"`
+	} `json:"recipients"`
+}
+
+func parsePostqueueJSON(rd io.Reader) (*queueStat, error) {
+	stat := &queueStat{
+		queues:  make(map[string]uint64),
+		domains: make(map[string]uint64),
+	}
+	for _, q := range postfixQueues {
+		stat.queues[q] = 0
+	}
+
+	dec := json.NewDecoder(rd)
+	for {
+		var msg postqueueMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		stat.count++
+		stat.queues[msg.QueueName]++
+		stat.bytes += msg.MessageSize
+		if stat.oldestArrival == 0 || msg.ArrivalTime < stat.oldestArrival {
+			stat.oldestArrival = msg.ArrivalTime
+		}
+		for _, r := range msg.Recipients {
+			if i := strings.LastIndex(r.Address, "@"); i >= 0 {
+				stat.domains[strings.ToLower(r.Address[i+1:])]++
+			}
+		}
+	}
+	return stat, nil
+}
+
+func (p *plugin) fetchQueueStat() (stat *queueStat, err error) {
+	err = p.runMailq(p.mailq.detailArgs, func(rd io.Reader) (err error) {
+		stat, err = parsePostqueueJSON(rd)
+		return
+	})
+	return
+}
+
+// topDomains returns at most n domains in descending order of their recipient counts
+func topDomains(domains map[string]uint64, n int) []string {
+	names := make([]string, 0, len(domains))
+	for d := range domains {
+		names = append(names, d)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if domains[names[i]] != domains[names[j]] {
+			return domains[names[i]] > domains[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+var normalizeMetricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeMetricName(name string) string {
+	return normalizeMetricNameRe.ReplaceAllString(name, "_")
+}
+
 func (p *plugin) FetchMetrics() (map[string]interface{}, error) {
+	if p.detail {
+		return p.fetchDetailMetrics(time.Now())
+	}
+
 	count, err := p.fetchMailqCount()
 	if err != nil {
 		return nil, err
@@ -109,8 +212,31 @@ func (p *plugin) FetchMetrics() (map[string]interface{}, error) {
 	return map[string]interface{}{"count": count}, nil
 }
 
+func (p *plugin) fetchDetailMetrics(now time.Time) (map[string]interface{}, error) {
+	stat, err := p.fetchQueueStat()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[string]interface{}{
+		"count":      stat.count,
+		"bytes":      stat.bytes,
+		"oldest_age": uint64(0),
+	}
+	if stat.count > 0 && now.Unix() > stat.oldestArrival {
+		metrics["oldest_age"] = uint64(now.Unix() - stat.oldestArrival)
+	}
+	for q, c := range stat.queues {
+		metrics[q] = c
+	}
+	for _, d := range topDomains(stat.domains, p.topDomains) {
+		metrics[p.keyPrefix+".domain."+normalizeMetricName(d)+".count"] = stat.domains[d]
+	}
+	return metrics, nil
+}
+
 func (p *plugin) GraphDefinition() map[string]mp.Graphs {
-	return map[string]mp.Graphs{
+	graphs := map[string]mp.Graphs{
 		p.keyPrefix: {
 			Label: p.labelPrefix + " Count",
 			Unit:  "integer",
@@ -119,6 +245,41 @@ func (p *plugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	if !p.detail {
+		return graphs
+	}
+
+	var queueMetrics []mp.Metrics
+	for _, q := range postfixQueues {
+		queueMetrics = append(queueMetrics, mp.Metrics{Name: q, Label: q, Type: "uint64", Stacked: true})
+	}
+	graphs[p.keyPrefix+".queue"] = mp.Graphs{
+		Label:   p.labelPrefix + " Queue",
+		Unit:    "integer",
+		Metrics: queueMetrics,
+	}
+	graphs[p.keyPrefix+".bytes"] = mp.Graphs{
+		Label: p.labelPrefix + " Bytes",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "bytes", Label: "bytes", Type: "uint64"},
+		},
+	}
+	graphs[p.keyPrefix+".oldest_age"] = mp.Graphs{
+		Label: p.labelPrefix + " Oldest Message Age (sec)",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "oldest_age", Label: "oldest age", Type: "uint64"},
+		},
+	}
+	graphs[p.keyPrefix+".domain.#"] = mp.Graphs{
+		Label: p.labelPrefix + " Recipients by Domain",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "count", Label: "count", Type: "uint64"},
+		},
+	}
+	return graphs
 }
 
 func getNthLine(rd io.Reader, nth int) (string, error) {
@@ -178,6 +339,8 @@ func Do() {
 	tempfile := flag.String("tempfile", "", "path to tempfile")
 	keyPrefix := flag.String("metric-key-prefix", "mailq", "prefix to metric key")
 	labelPrefix := flag.String("metric-label-prefix", "Mailq", "prefix to metric label")
+	detail := flag.Bool("detail", false, "collect per-queue counts, bytes, the oldest message age and recipient domains (postfix 3.1 or later only)")
+	domains := flag.Int("top-domains", 10, "number of recipient domains to report with -detail")
 
 	flag.Parse()
 
@@ -185,12 +348,20 @@ func Do() {
 		fmt.Fprintf(os.Stderr, "Unknown MTA: %s\n", *mta)
 		flag.PrintDefaults()
 		os.Exit(1)
+	} else if *detail && format.detailArgs == nil {
+		fmt.Fprintf(os.Stderr, "-detail is not supported for MTA: %s\n", *mta)
+		os.Exit(1)
+	} else if *domains < 0 {
+		fmt.Fprintf(os.Stderr, "-top-domains must not be negative: %d\n", *domains)
+		os.Exit(1)
 	} else {
 		plugin := &plugin{
 			path:        *command,
 			mailq:       format,
 			keyPrefix:   *keyPrefix,
 			labelPrefix: *labelPrefix,
+			detail:      *detail,
+			topDomains:  *domains,
 		}
 		helper := mp.NewMackerelPlugin(plugin)
 		helper.Tempfile = *tempfile
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetNthLine(t *testing.T) {
@@ -306,3 +307,79 @@ func TestFetchMetricsQmail(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePostqueueJSON(t *testing.T) {
+	output := `{"queue_name": "deferred", "queue_id": "DD0C740001C", "arrival_time": 1457016757, "message_size": 274, "forced_expire": false, "sender": "foobar@example.com", "recipients": [{"address": "nyao@mail.invalid", "delay_reason": "connect to mail.invalid[192.0.2.100]:25: Connection timed out"}, {"address": "wan@Mail.invalid"}]}
+{"queue_name": "active", "queue_id": "C24DC3C5E4", "arrival_time": 1457016700, "message_size": 1000, "forced_expire": false, "sender": "foobar@example.com", "recipients": [{"address": "foo@example.com"}]}
+{"queue_name": "hold", "queue_id": "A12BC3C5E4", "arrival_time": 1457016800, "message_size": 26, "forced_expire": false, "sender": "foobar@example.com", "recipients": [{"address": "bar@example.org"}]}
+`
+
+	stat, err := parsePostqueueJSON(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("Error in parsePostqueueJSON: %s", err.Error())
+	}
+	if stat.count != 3 {
+		t.Errorf("Incorrect count %d", stat.count)
+	}
+	if stat.bytes != 1300 {
+		t.Errorf("Incorrect bytes %d", stat.bytes)
+	}
+	if stat.oldestArrival != 1457016700 {
+		t.Errorf("Incorrect oldest arrival %d", stat.oldestArrival)
+	}
+	if stat.queues["active"] != 1 || stat.queues["deferred"] != 1 || stat.queues["hold"] != 1 {
+		t.Errorf("Incorrect queues %v", stat.queues)
+	}
+	if _, ok := stat.queues["maildrop"]; !ok {
+		t.Errorf("Empty queues are expected to be reported")
+	}
+	if stat.domains["mail.invalid"] != 2 {
+		t.Errorf("Incorrect recipients of mail.invalid %d", stat.domains["mail.invalid"])
+	}
+
+	domains := topDomains(stat.domains, 2)
+	if len(domains) != 2 || domains[0] != "mail.invalid" || domains[1] != "example.com" {
+		t.Errorf("Incorrect top domains %v", domains)
+	}
+}
+
+func TestFetchMetricsPostfixDetail(t *testing.T) {
+	plugin := plugin{
+		mailq:       mailqFormats["postfix"],
+		keyPrefix:   "mailq",
+		labelPrefix: "Mailq",
+		detail:      true,
+		topDomains:  10,
+	}
+
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", "./fixtures:/bin:/usr/bin")
+	defer os.Setenv("PATH", origPath)
+
+	{
+		os.Setenv("TEST_MAILQ_COUNT", "42")
+		defer os.Unsetenv("TEST_MAILQ_COUNT")
+
+		metrics, err := plugin.fetchDetailMetrics(time.Unix(1457016817, 0))
+		if err != nil {
+			t.Errorf("Error %s", err.Error())
+		}
+		if metrics["count"].(uint64) != 42 {
+			t.Errorf("Incorrect value: %d", metrics["count"].(uint64))
+		}
+		if metrics["deferred"].(uint64) != 42 {
+			t.Errorf("Incorrect value: %d", metrics["deferred"].(uint64))
+		}
+		if metrics["oldest_age"].(uint64) != 60 {
+			t.Errorf("Incorrect value: %d", metrics["oldest_age"].(uint64))
+		}
+		if metrics["mailq.domain.mail_invalid.count"].(uint64) != 42 {
+			t.Errorf("Incorrect value: %d", metrics["mailq.domain.mail_invalid.count"].(uint64))
+		}
+	}
+
+	graphs := plugin.GraphDefinition()
+	if _, ok := graphs["mailq.domain.#"]; !ok {
+		t.Errorf("No graph definition for recipient domains")
+	}
+}
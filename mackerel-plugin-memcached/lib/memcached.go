@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 )
@@ -17,8 +19,13 @@ type MemcachedPlugin struct {
 	Socket   string
 	Tempfile string
 	Prefix   string
+	Timeout  uint
+	Slabs    bool
 }
 
+// connStates are states of connections reported by `stats conns`
+var connStates = []string{"new_cmd", "waiting", "read", "parse_cmd", "write", "nread", "swallow", "closing", "mwrite", "closed", "watch"}
+
 // MetricKeyPrefix interface for PluginWithPrefix
 func (m MemcachedPlugin) MetricKeyPrefix() string {
 	if m.Prefix == "" {
@@ -35,12 +42,52 @@ func (m MemcachedPlugin) FetchMetrics() (map[string]interface{}, error) {
 		network = "unix"
 		target = m.Socket
 	}
-	conn, err := net.Dial(network, target)
+	timeout := time.Duration(m.Timeout) * time.Second
+	conn, err := net.DialTimeout(network, target, timeout)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
+	// no timeout is 0 like net.DialTimeout
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
 	fmt.Fprintln(conn, "stats")
-	return m.parseStats(conn)
+	stat, err := m.parseStats(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Slabs {
+		slabs, err := command(conn, "stats slabs")
+		if err != nil {
+			return nil, err
+		}
+		items, err := command(conn, "stats items")
+		if err != nil {
+			return nil, err
+		}
+		mergeStat(stat, parseSlabsStats(slabs, items))
+	}
+
+	if version, ok := stat["version"].(string); ok && isVersionOrLater(version, 1, 6) {
+		conns, err := command(conn, "stats conns")
+		if err != nil {
+			return nil, err
+		}
+		mergeStat(stat, parseConnsStats(conns))
+
+		// extstore is enabled only when its stats are in `stats`
+		if _, ok := stat["extstore_bytes_used"]; ok {
+			extstore, err := command(conn, "stats extstore")
+			if err != nil {
+				return nil, err
+			}
+			mergeStat(stat, parseExtstoreStats(extstore))
+		}
+	}
+	return stat, nil
 }
 
 func (m MemcachedPlugin) parseStats(conn io.Reader) (map[string]interface{}, error) {
@@ -54,6 +101,9 @@ func (m MemcachedPlugin) parseStats(conn io.Reader) (map[string]interface{}, err
 			stat["new_items"] = stat["total_items"]
 			return stat, nil
 		}
+		if isErrorResponse(s) {
+			return nil, fmt.Errorf("failed to fetch stats: %s", s)
+		}
 
 		res := strings.Split(s, " ")
 		if res[0] == "STAT" {
@@ -66,6 +116,167 @@ func (m MemcachedPlugin) parseStats(conn io.Reader) (map[string]interface{}, err
 	return nil, nil
 }
 
+func command(conn io.ReadWriter, cmd string) (map[string]string, error) {
+	fmt.Fprintln(conn, cmd)
+	return readStats(conn)
+}
+
+// readStats reads "STAT <name> <value>" lines until END
+func readStats(rd io.Reader) (map[string]string, error) {
+	scanner := bufio.NewScanner(rd)
+	stat := make(map[string]string)
+
+	for scanner.Scan() {
+		s := scanner.Text()
+		if s == "END" {
+			return stat, nil
+		}
+		if isErrorResponse(s) {
+			return nil, fmt.Errorf("failed to fetch stats: %s", s)
+		}
+
+		res := strings.SplitN(s, " ", 3)
+		if len(res) == 3 && res[0] == "STAT" {
+			stat[res[1]] = res[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func isErrorResponse(s string) bool {
+	return s == "ERROR" || strings.HasPrefix(s, "CLIENT_ERROR") || strings.HasPrefix(s, "SERVER_ERROR")
+}
+
+func isVersionOrLater(version string, major, minor int) bool {
+	v := strings.SplitN(version, ".", 3)
+	if len(v) < 2 {
+		return false
+	}
+	maj, err := strconv.Atoi(v[0])
+	if err != nil {
+		return false
+	}
+	min, err := strconv.Atoi(v[1])
+	if err != nil {
+		return false
+	}
+	return maj > major || (maj == major && min >= minor)
+}
+
+func mergeStat(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// # stats slabs
+// STAT 1:chunk_size 96
+// STAT 1:total_chunks 10922
+// STAT 1:used_chunks 1
+// STAT 1:get_hits 0
+// STAT active_slabs 1
+// # stats items
+// STAT items:1:number 1
+// STAT items:1:age 10
+// STAT items:1:evicted 0
+func parseSlabsStats(slabs, items map[string]string) map[string]interface{} {
+	classes := make(map[string]map[string]float64)
+	add := func(class, field, value string) {
+		if _, err := strconv.Atoi(class); err != nil {
+			return
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return
+		}
+		if classes[class] == nil {
+			classes[class] = make(map[string]float64)
+		}
+		classes[class][field] = v
+	}
+	for k, v := range slabs {
+		kv := strings.SplitN(k, ":", 2)
+		if len(kv) == 2 {
+			add(kv[0], kv[1], v)
+		}
+	}
+	for k, v := range items {
+		kv := strings.SplitN(k, ":", 3)
+		if len(kv) == 3 && kv[0] == "items" {
+			add(kv[1], kv[2], v)
+		}
+	}
+
+	stat := make(map[string]interface{})
+	for class, c := range classes {
+		stat["slab_memory."+class+".total"] = c["total_chunks"] * c["chunk_size"]
+		stat["slab_memory."+class+".used"] = c["used_chunks"] * c["chunk_size"]
+		// mem_requested is moved from `stats slabs` to `stats items` since 1.6
+		stat["slab_memory."+class+".requested"] = c["mem_requested"]
+		stat["slab_items."+class+".number"] = c["number"]
+		stat["slab_age."+class+".age"] = c["age"]
+		stat["slab_age."+class+".evicted_time"] = c["evicted_time"]
+		for _, field := range []string{"evicted", "evicted_nonzero", "outofmemory", "reclaimed", "expired_unfetched", "evicted_unfetched"} {
+			stat["slab_evictions."+class+"."+field] = uint64(c[field])
+		}
+		for _, field := range []string{"get_hits", "cmd_set", "delete_hits", "touch_hits"} {
+			stat["slab_hits."+class+"."+field] = uint64(c[field])
+		}
+		if total := c["total_chunks"]; total > 0 {
+			stat["slab_usage."+class+".used"] = c["used_chunks"] / total * 100
+		}
+	}
+	return stat
+}
+
+// # stats conns
+// STAT 5:addr tcp:0.0.0.0:11211
+// STAT 5:state conn_listening
+// STAT 26:addr tcp:127.0.0.1:51234
+// STAT 26:state conn_parse_cmd
+func parseConnsStats(conns map[string]string) map[string]interface{} {
+	stat := make(map[string]interface{})
+	for _, state := range connStates {
+		stat["conn_state_"+state] = uint64(0)
+	}
+	for k, v := range conns {
+		if !strings.HasSuffix(k, ":state") {
+			continue
+		}
+		state := strings.TrimPrefix(v, "conn_")
+		if n, ok := stat["conn_state_"+state].(uint64); ok {
+			stat["conn_state_"+state] = n + 1
+		}
+	}
+	return stat
+}
+
+// # stats extstore
+// STAT page_size 67108864
+// STAT 0:version 3
+// STAT 0:bytes 67108864
+// STAT 0:bucket 0
+// STAT 0:free_bucket 0
+func parseExtstoreStats(extstore map[string]string) map[string]interface{} {
+	var total, active uint64
+	for k, v := range extstore {
+		if !strings.HasSuffix(k, ":bytes") {
+			continue
+		}
+		total++
+		if b, err := strconv.ParseUint(v, 10, 64); err == nil && b > 0 {
+			active++
+		}
+	}
+	return map[string]interface{}{
+		"extstore_pages_total":  total,
+		"extstore_pages_active": active,
+	}
+}
+
 // GraphDefinition interface for mackerelplugin
 func (m MemcachedPlugin) GraphDefinition() map[string]mp.Graphs {
 	labelPrefix := strings.Title(m.Prefix)
@@ -152,22 +363,118 @@ func (m MemcachedPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "new_items", Label: "New Items", Diff: true, Type: "uint64"},
 			},
 		},
+		"conn_states": {
+			Label:   (labelPrefix + " Connection States"),
+			Unit:    "integer",
+			Metrics: connStateMetrics(),
+		},
+		"extstore_bytes": {
+			Label: (labelPrefix + " Extstore Bytes"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "extstore_limit_maxbytes", Label: "Total", Diff: false, Type: "uint64"},
+				{Name: "extstore_bytes_used", Label: "Used", Diff: false, Type: "uint64"},
+				{Name: "extstore_bytes_fragmented", Label: "Fragmented", Diff: false, Type: "uint64"},
+			},
+		},
+		"extstore_objects": {
+			Label: (labelPrefix + " Extstore Objects"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "extstore_objects_read", Label: "Read", Diff: true, Type: "uint64"},
+				{Name: "extstore_objects_written", Label: "Written", Diff: true, Type: "uint64"},
+				{Name: "extstore_objects_evicted", Label: "Evicted", Diff: true, Type: "uint64"},
+			},
+		},
+		"extstore_pages": {
+			Label: (labelPrefix + " Extstore Pages"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "extstore_pages_total", Label: "Total", Diff: false, Type: "uint64"},
+				{Name: "extstore_pages_active", Label: "Active", Diff: false, Type: "uint64"},
+			},
+		},
+		"slab_memory.#": {
+			Label: (labelPrefix + " Slab Memory"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "total", Label: "Total", Diff: false},
+				{Name: "used", Label: "Used", Diff: false},
+				{Name: "requested", Label: "Requested", Diff: false},
+			},
+		},
+		"slab_usage.#": {
+			Label: (labelPrefix + " Slab Chunk Usage"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "used", Label: "Used", Diff: false},
+			},
+		},
+		"slab_items.#": {
+			Label: (labelPrefix + " Slab Items"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "number", Label: "Items", Diff: false},
+			},
+		},
+		"slab_age.#": {
+			Label: (labelPrefix + " Slab Item Age (sec)"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "age", Label: "Oldest item", Diff: false},
+				{Name: "evicted_time", Label: "Last evicted item", Diff: false},
+			},
+		},
+		"slab_evictions.#": {
+			Label: (labelPrefix + " Slab Evictions"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "evicted", Label: "Evicted", Diff: true, Type: "uint64"},
+				{Name: "evicted_nonzero", Label: "Evicted nonzero", Diff: true, Type: "uint64"},
+				{Name: "evicted_unfetched", Label: "Evicted unfetched", Diff: true, Type: "uint64"},
+				{Name: "expired_unfetched", Label: "Expired unfetched", Diff: true, Type: "uint64"},
+				{Name: "reclaimed", Label: "Reclaimed", Diff: true, Type: "uint64"},
+				{Name: "outofmemory", Label: "Out of memory", Diff: true, Type: "uint64"},
+			},
+		},
+		"slab_hits.#": {
+			Label: (labelPrefix + " Slab Hits"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "get_hits", Label: "Get Hits", Diff: true, Type: "uint64"},
+				{Name: "cmd_set", Label: "Set", Diff: true, Type: "uint64"},
+				{Name: "delete_hits", Label: "Delete Hits", Diff: true, Type: "uint64"},
+				{Name: "touch_hits", Label: "Touch Hits", Diff: true, Type: "uint64"},
+			},
+		},
 	}
 	return graphdef
 }
 
+func connStateMetrics() []mp.Metrics {
+	var metrics []mp.Metrics
+	for _, state := range connStates {
+		metrics = append(metrics, mp.Metrics{Name: "conn_state_" + state, Label: strings.Title(strings.Replace(state, "_", " ", -1)), Diff: false, Stacked: true, Type: "uint64"})
+	}
+	return metrics
+}
+
 // Do the plugin
 func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "11211", "Port")
 	optSocket := flag.String("socket", "", "Server socket (overrides hosts and port)")
 	optPrefix := flag.String("metric-key-prefix", "memcached", "Metric key prefix")
+	optTimeout := flag.Uint("timeout", 5, "Timeout")
+	optSlabs := flag.Bool("slabs", false, "Fetch per slab class stats by `stats slabs` and `stats items`")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
 	var memcached MemcachedPlugin
 
 	memcached.Prefix = *optPrefix
+	memcached.Timeout = *optTimeout
+	memcached.Slabs = *optSlabs
 
 	if *optSocket != "" {
 		memcached.Socket = *optSocket
@@ -13,8 +13,8 @@ func TestGraphDefinition(t *testing.T) {
 	var memcached MemcachedPlugin
 
 	graphdef := memcached.GraphDefinition()
-	if len(graphdef) != 9 {
-		t.Errorf("GetTempfilename: %d should be 8", len(graphdef))
+	if len(graphdef) != 19 {
+		t.Errorf("GetTempfilename: %d should be 19", len(graphdef))
 	}
 }
 
@@ -67,3 +67,109 @@ END
 	assert.EqualValues(t, reflect.TypeOf(stat["get_hits"]).String(), "string")
 	assert.EqualValues(t, stat["get_hits"].(string), "2769383483")
 }
+
+func TestParseError(t *testing.T) {
+	var memcached MemcachedPlugin
+	_, err := memcached.parseStats(bytes.NewBufferString("SERVER_ERROR out of memory\r\n"))
+	assert.NotNil(t, err)
+
+	_, err = readStats(bytes.NewBufferString("ERROR\r\n"))
+	assert.NotNil(t, err)
+
+	_, err = readStats(bytes.NewBufferString("STAT 1:chunk_size 96\r\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseSlabsStats(t *testing.T) {
+	slabs, err := readStats(bytes.NewBufferString(`STAT 1:chunk_size 96
+STAT 1:chunks_per_page 10922
+STAT 1:total_pages 1
+STAT 1:total_chunks 10922
+STAT 1:used_chunks 5461
+STAT 1:free_chunks 5461
+STAT 1:get_hits 120
+STAT 1:cmd_set 30
+STAT 1:delete_hits 2
+STAT 1:touch_hits 0
+STAT 2:chunk_size 120
+STAT 2:total_chunks 8738
+STAT 2:used_chunks 0
+STAT active_slabs 2
+STAT total_malloced 2097152
+END
+`))
+	assert.Nil(t, err)
+	items, err := readStats(bytes.NewBufferString(`STAT items:1:number 5000
+STAT items:1:age 3600
+STAT items:1:evicted 10
+STAT items:1:evicted_nonzero 3
+STAT items:1:evicted_time 1200
+STAT items:1:outofmemory 0
+STAT items:1:reclaimed 7
+STAT items:1:expired_unfetched 1
+STAT items:1:evicted_unfetched 4
+STAT items:1:mem_requested 400000
+END
+`))
+	assert.Nil(t, err)
+
+	stat := parseSlabsStats(slabs, items)
+	assert.EqualValues(t, 96*10922, stat["slab_memory.1.total"])
+	assert.EqualValues(t, 96*5461, stat["slab_memory.1.used"])
+	assert.EqualValues(t, 400000, stat["slab_memory.1.requested"])
+	assert.EqualValues(t, 50, stat["slab_usage.1.used"])
+	assert.EqualValues(t, 5000, stat["slab_items.1.number"])
+	assert.EqualValues(t, 3600, stat["slab_age.1.age"])
+	assert.EqualValues(t, 10, stat["slab_evictions.1.evicted"])
+	assert.EqualValues(t, 4, stat["slab_evictions.1.evicted_unfetched"])
+	assert.EqualValues(t, 120, stat["slab_hits.1.get_hits"])
+	assert.EqualValues(t, 120*8738, stat["slab_memory.2.total"])
+	assert.EqualValues(t, 0, stat["slab_usage.2.used"])
+	_, ok := stat["slab_memory.active_slabs.total"]
+	assert.False(t, ok)
+}
+
+func TestParseConnsStats(t *testing.T) {
+	conns, err := readStats(bytes.NewBufferString(`STAT 5:addr tcp:0.0.0.0:11211
+STAT 5:state conn_listening
+STAT 26:addr tcp:127.0.0.1:51234
+STAT 26:listen_addr tcp:0.0.0.0:11211
+STAT 26:state conn_parse_cmd
+STAT 27:addr tcp:127.0.0.1:51235
+STAT 27:state conn_waiting
+STAT 28:addr tcp:127.0.0.1:51236
+STAT 28:state conn_waiting
+END
+`))
+	assert.Nil(t, err)
+
+	stat := parseConnsStats(conns)
+	assert.EqualValues(t, 1, stat["conn_state_parse_cmd"])
+	assert.EqualValues(t, 2, stat["conn_state_waiting"])
+	assert.EqualValues(t, 0, stat["conn_state_read"])
+	_, ok := stat["conn_state_listening"]
+	assert.False(t, ok)
+}
+
+func TestParseExtstoreStats(t *testing.T) {
+	extstore, err := readStats(bytes.NewBufferString(`STAT page_size 67108864
+STAT 0:version 3
+STAT 0:bytes 67108864
+STAT 0:bucket 0
+STAT 1:version 0
+STAT 1:bytes 0
+END
+`))
+	assert.Nil(t, err)
+
+	stat := parseExtstoreStats(extstore)
+	assert.EqualValues(t, 2, stat["extstore_pages_total"])
+	assert.EqualValues(t, 1, stat["extstore_pages_active"])
+}
+
+func TestIsVersionOrLater(t *testing.T) {
+	assert.True(t, isVersionOrLater("1.6.9", 1, 6))
+	assert.True(t, isVersionOrLater("2.0.0", 1, 6))
+	assert.False(t, isVersionOrLater("1.5.22", 1, 6))
+	assert.False(t, isVersionOrLater("unknown", 1, 6))
+}
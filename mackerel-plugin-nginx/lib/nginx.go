@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"log"
 
 	"errors"
 	"net/http"
@@ -43,6 +44,128 @@ var graphdef = map[string]mp.Graphs{
 	},
 }
 
+// the graphs of the server zones and the upstream peers of VTS and nginx Plus
+var zoneGraphdef = map[string]mp.Graphs{
+	"nginx.server_zone.requests.#": {
+		Label: "Nginx Server Zone Requests",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.server_zone.responses.#": {
+		Label: "Nginx Server Zone Responses",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "1xx", Label: "1xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "2xx", Label: "2xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "3xx", Label: "3xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "4xx", Label: "4xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "5xx", Label: "5xx", Diff: true, Stacked: true, Type: "uint64"},
+		},
+	},
+	"nginx.server_zone.traffic.#": {
+		Label: "Nginx Server Zone Traffic",
+		Unit:  "bytes/sec",
+		Metrics: []mp.Metrics{
+			{Name: "in_bytes", Label: "In", Diff: true, Type: "uint64"},
+			{Name: "out_bytes", Label: "Out", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.upstream.state.#": {
+		Label: "Nginx Upstream Peer State",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "up", Label: "Up", Stacked: true},
+			{Name: "down", Label: "Down", Stacked: true},
+			{Name: "unavail", Label: "Unavailable", Stacked: true},
+			{Name: "unhealthy", Label: "Unhealthy", Stacked: true},
+			{Name: "checking", Label: "Checking", Stacked: true},
+			{Name: "draining", Label: "Draining", Stacked: true},
+		},
+	},
+	"nginx.upstream.active.#": {
+		Label: "Nginx Upstream Peer Active Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "active", Label: "Active"},
+		},
+	},
+	"nginx.upstream.requests.#": {
+		Label: "Nginx Upstream Peer Requests",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "requests", Label: "Requests", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.upstream.responses.#": {
+		Label: "Nginx Upstream Peer Responses",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "1xx", Label: "1xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "2xx", Label: "2xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "3xx", Label: "3xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "4xx", Label: "4xx", Diff: true, Stacked: true, Type: "uint64"},
+			{Name: "5xx", Label: "5xx", Diff: true, Stacked: true, Type: "uint64"},
+		},
+	},
+	"nginx.upstream.traffic.#": {
+		Label: "Nginx Upstream Peer Traffic",
+		Unit:  "bytes/sec",
+		Metrics: []mp.Metrics{
+			{Name: "received", Label: "Received", Diff: true, Type: "uint64"},
+			{Name: "sent", Label: "Sent", Diff: true, Type: "uint64"},
+		},
+	},
+	"nginx.upstream.response_time.#": {
+		Label: "Nginx Upstream Peer Response Time (ms)",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "response_time", Label: "Response"},
+			{Name: "header_time", Label: "Header"},
+		},
+	},
+	"nginx.upstream.fails.#": {
+		Label: "Nginx Upstream Peer Fails",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "fails", Label: "Fails", Diff: true, Type: "uint64"},
+			{Name: "health_check_fails", Label: "Health Check Fails", Diff: true, Type: "uint64"},
+			{Name: "unhealthy", Label: "Unhealthy", Diff: true, Type: "uint64"},
+		},
+	},
+}
+
+// the states of the upstream peers of nginx Plus
+var peerStates = []string{"up", "down", "unavail", "unhealthy", "checking", "draining"}
+
+const (
+	modeStubStatus = "stub_status"
+	modeVTS        = "vts"
+	modePlus       = "plus"
+)
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func metricName(name string) string {
+	return metricNameRe.ReplaceAllString(name, "_")
+}
+
+// peerName is the name of the upstream peer in the metric names like "backend-10_0_0_1_80"
+func peerName(upstream, server string) string {
+	return metricName(upstream) + "-" + metricName(server)
+}
+
+func setPeerState(stat map[string]interface{}, peer, state string) {
+	for _, s := range peerStates {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		stat["nginx.upstream.state."+peer+"."+s] = v
+	}
+}
+
 type stringSlice []string
 
 func (s *stringSlice) Set(v string) error {
@@ -58,6 +181,8 @@ func (s *stringSlice) String() string {
 type NginxPlugin struct {
 	URI    string
 	Header stringSlice
+	// Mode is the format of the status, stub_status by default
+	Mode string
 }
 
 // % wget -qO- http://localhost:8080/nginx_status
@@ -68,7 +193,38 @@ type NginxPlugin struct {
 
 // FetchMetrics interface for mackerelplugin
 func (n NginxPlugin) FetchMetrics() (map[string]interface{}, error) {
-	req, err := http.NewRequest("GET", n.URI, nil)
+	switch n.Mode {
+	case modeVTS:
+		body, err := n.get(n.URI)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return n.parseVTS(body)
+	case modePlus:
+		// the URI is the base of the API like http://localhost:8080/api/6
+		bodies := make(map[string]io.Reader, len(plusPaths))
+		for _, path := range plusPaths {
+			body, err := n.get(strings.TrimSuffix(n.URI, "/") + path)
+			if err != nil {
+				return nil, err
+			}
+			defer body.Close()
+			bodies[path] = body
+		}
+		return n.parsePlus(bodies)
+	}
+
+	body, err := n.get(n.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return n.parseStats(body)
+}
+
+func (n NginxPlugin) get(uri string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", uri, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -89,9 +245,11 @@ func (n NginxPlugin) FetchMetrics() (map[string]interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	return n.parseStats(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", uri, resp.Status)
+	}
+	return resp.Body, nil
 }
 
 func (n NginxPlugin) parseStats(body io.Reader) (map[string]interface{}, error) {
@@ -165,7 +323,17 @@ func (n NginxPlugin) parseStats(body io.Reader) (map[string]interface{}, error)
 
 // GraphDefinition interface for mackerelplugin
 func (n NginxPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	if n.Mode != modeVTS && n.Mode != modePlus {
+		return graphdef
+	}
+	graphs := make(map[string]mp.Graphs, len(graphdef)+len(zoneGraphdef))
+	for key, g := range graphdef {
+		graphs[key] = g
+	}
+	for key, g := range zoneGraphdef {
+		graphs[key] = g
+	}
+	return graphs
 }
 
 // Do the plugin
@@ -174,17 +342,36 @@ func Do() {
 	optScheme := flag.String("scheme", "http", "Scheme")
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "8080", "Port")
-	optPath := flag.String("path", "/nginx_status", "Path")
+	optPath := flag.String("path", "", "Path (default \"/nginx_status\", \"/status/format/json\" for vts and \"/api/6\" for plus)")
+	optMode := flag.String("mode", modeStubStatus, "Format of the status: stub_status, vts (nginx-module-vts JSON) or plus (nginx Plus API)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	optHeader := &stringSlice{}
 	flag.Var(optHeader, "header", "Set http header (e.g. \"Host: servername\")")
 	flag.Parse()
 
 	var nginx NginxPlugin
+	nginx.Mode = *optMode
+	path := *optPath
+	switch nginx.Mode {
+	case modeStubStatus:
+		if path == "" {
+			path = "/nginx_status"
+		}
+	case modeVTS:
+		if path == "" {
+			path = "/status/format/json"
+		}
+	case modePlus:
+		if path == "" {
+			path = "/api/6"
+		}
+	default:
+		log.Fatalf("unknown mode: %s", nginx.Mode)
+	}
 	if *optURI != "" {
 		nginx.URI = *optURI
 	} else {
-		nginx.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
+		nginx.URI = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, path)
 	}
 	nginx.Header = *optHeader
 
@@ -3,6 +3,7 @@ package mpnginx
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
 
@@ -36,3 +37,82 @@ Reading: 66 Writing: 16 Waiting: 41
 	assert.EqualValues(t, reflect.TypeOf(stat["accepts"]).String(), "float64")
 	assert.EqualValues(t, stat["accepts"], 1693613501)
 }
+
+func TestGraphDefinitionZones(t *testing.T) {
+	nginx := NginxPlugin{Mode: modeVTS}
+	graphdef := nginx.GraphDefinition()
+	assert.Contains(t, graphdef, "nginx.connections")
+	assert.Contains(t, graphdef, "nginx.server_zone.responses.#")
+	assert.Contains(t, graphdef, "nginx.upstream.state.#")
+}
+
+func TestParseVTS(t *testing.T) {
+	nginx := NginxPlugin{Mode: modeVTS}
+	stub := `{
+  "hostName": "edge01",
+  "nginxVersion": "1.19.6",
+  "connections": {"active": 12, "reading": 0, "writing": 3, "waiting": 9, "accepted": 1842, "handled": 1842, "requests": 10204},
+  "serverZones": {
+    "www.example.com": {"requestCounter": 8120, "inBytes": 2350211, "outBytes": 91877312, "responses": {"1xx": 0, "2xx": 7900, "3xx": 150, "4xx": 60, "5xx": 10}, "requestMsec": 12},
+    "*": {"requestCounter": 10204, "inBytes": 2950211, "outBytes": 101877312, "responses": {"1xx": 0, "2xx": 9900, "3xx": 200, "4xx": 94, "5xx": 10}, "requestMsec": 11}
+  },
+  "upstreamZones": {
+    "backend": [
+      {"server": "10.0.0.1:8080", "requestCounter": 5020, "inBytes": 45877312, "outBytes": 1175211, "responses": {"1xx": 0, "2xx": 4900, "3xx": 100, "4xx": 15, "5xx": 5}, "responseMsec": 23, "weight": 1, "maxFails": 1, "failTimeout": 10, "backup": false, "down": false},
+      {"server": "10.0.0.2:8080", "requestCounter": 0, "inBytes": 0, "outBytes": 0, "responses": {"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0}, "responseMsec": 0, "weight": 1, "maxFails": 1, "failTimeout": 10, "backup": false, "down": true}
+    ]
+  }
+}`
+
+	stat, err := nginx.parseVTS(bytes.NewBufferString(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 12, stat["connections"])
+	assert.EqualValues(t, 10204, stat["requests"])
+	assert.EqualValues(t, 9, stat["waiting"])
+	assert.EqualValues(t, 8120, stat["nginx.server_zone.requests.www_example_com.requests"])
+	assert.EqualValues(t, 10, stat["nginx.server_zone.responses.total.5xx"])
+	assert.EqualValues(t, 91877312, stat["nginx.server_zone.traffic.www_example_com.out_bytes"])
+	assert.EqualValues(t, 1, stat["nginx.upstream.state.backend-10_0_0_1_8080.up"])
+	assert.EqualValues(t, 0, stat["nginx.upstream.state.backend-10_0_0_1_8080.down"])
+	assert.EqualValues(t, 1, stat["nginx.upstream.state.backend-10_0_0_2_8080.down"])
+	assert.EqualValues(t, 4900, stat["nginx.upstream.responses.backend-10_0_0_1_8080.2xx"])
+	assert.EqualValues(t, 23, stat["nginx.upstream.response_time.backend-10_0_0_1_8080.response_time"])
+}
+
+func TestParsePlus(t *testing.T) {
+	nginx := NginxPlugin{Mode: modePlus}
+	bodies := map[string]io.Reader{
+		plusConnectionsPath: bytes.NewBufferString(`{"accepted": 4968119, "dropped": 2, "active": 5, "idle": 117}`),
+		plusRequestsPath:    bytes.NewBufferString(`{"total": 10624511, "current": 4}`),
+		plusServerZonesPath: bytes.NewBufferString(`{
+  "hg.nginx.org": {"processing": 0, "requests": 175276, "responses": {"1xx": 0, "2xx": 162948, "3xx": 10117, "4xx": 2125, "5xx": 86, "total": 175276}, "discarded": 0, "received": 38264360, "sent": 3895093386}
+}`),
+		plusUpstreamsPath: bytes.NewBufferString(`{
+  "trac-backend": {
+    "peers": [
+      {"id": 0, "server": "10.0.0.1:8080", "name": "10.0.0.1:8080", "backup": false, "weight": 1, "state": "up", "active": 2, "requests": 106446, "header_time": 112, "response_time": 120, "responses": {"1xx": 0, "2xx": 104478, "3xx": 1543, "4xx": 392, "5xx": 33, "total": 106446}, "sent": 44897349, "received": 3174588950, "fails": 3, "unavail": 0, "health_checks": {"checks": 26214, "fails": 1, "unhealthy": 0, "last_passed": true}, "downtime": 0, "selected": "2021-06-01T07:59:59Z"},
+      {"id": 1, "server": "10.0.0.2:8080", "name": "10.0.0.2:8080", "backup": true, "weight": 1, "state": "unhealthy", "active": 0, "requests": 0, "responses": {"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0, "total": 0}, "sent": 0, "received": 0, "fails": 0, "unavail": 0, "health_checks": {"checks": 26214, "fails": 26214, "unhealthy": 1, "last_passed": false}, "downtime": 262925617}
+    ],
+    "keepalive": 0,
+    "zombies": 0,
+    "zone": "trac-backend"
+  }
+}`),
+	}
+
+	stat, err := nginx.parsePlus(bodies)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 5, stat["connections"])
+	assert.EqualValues(t, 4968117, stat["handled"])
+	assert.EqualValues(t, 10624511, stat["requests"])
+	assert.EqualValues(t, 117, stat["waiting"])
+	assert.EqualValues(t, 86, stat["nginx.server_zone.responses.hg_nginx_org.5xx"])
+	assert.EqualValues(t, 38264360, stat["nginx.server_zone.traffic.hg_nginx_org.in_bytes"])
+	assert.EqualValues(t, 1, stat["nginx.upstream.state.trac-backend-10_0_0_2_8080.unhealthy"])
+	assert.EqualValues(t, 0, stat["nginx.upstream.state.trac-backend-10_0_0_2_8080.up"])
+	assert.EqualValues(t, 2, stat["nginx.upstream.active.trac-backend-10_0_0_1_8080.active"])
+	assert.EqualValues(t, 120, stat["nginx.upstream.response_time.trac-backend-10_0_0_1_8080.response_time"])
+	assert.EqualValues(t, 1, stat["nginx.upstream.fails.trac-backend-10_0_0_1_8080.health_check_fails"])
+	_, ok := stat["nginx.upstream.response_time.trac-backend-10_0_0_2_8080.response_time"]
+	assert.False(t, ok, "no response time without responses")
+}
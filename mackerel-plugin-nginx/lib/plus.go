@@ -0,0 +1,124 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// the endpoints of nginx Plus API, e.g. /api/6/connections
+const (
+	plusConnectionsPath = "/connections"
+	plusRequestsPath    = "/http/requests"
+	plusServerZonesPath = "/http/server_zones"
+	plusUpstreamsPath   = "/http/upstreams"
+)
+
+var plusPaths = []string{plusConnectionsPath, plusRequestsPath, plusServerZonesPath, plusUpstreamsPath}
+
+type plusConnections struct {
+	Accepted float64 `json:"accepted"`
+	Dropped  float64 `json:"dropped"`
+	Active   float64 `json:"active"`
+	Idle     float64 `json:"idle"`
+}
+
+type plusRequests struct {
+	Total float64 `json:"total"`
+}
+
+type plusResponses struct {
+	Status1xx float64 `json:"1xx"`
+	Status2xx float64 `json:"2xx"`
+	Status3xx float64 `json:"3xx"`
+	Status4xx float64 `json:"4xx"`
+	Status5xx float64 `json:"5xx"`
+}
+
+type plusServerZone struct {
+	Requests  float64       `json:"requests"`
+	Responses plusResponses `json:"responses"`
+	Received  float64       `json:"received"`
+	Sent      float64       `json:"sent"`
+}
+
+type plusUpstream struct {
+	Peers []plusPeer `json:"peers"`
+}
+
+type plusPeer struct {
+	Server       string        `json:"server"`
+	State        string        `json:"state"`
+	Active       float64       `json:"active"`
+	Requests     float64       `json:"requests"`
+	Responses    plusResponses `json:"responses"`
+	Received     float64       `json:"received"`
+	Sent         float64       `json:"sent"`
+	Fails        float64       `json:"fails"`
+	HeaderTime   *float64      `json:"header_time"`
+	ResponseTime *float64      `json:"response_time"`
+	HealthChecks struct {
+		Checks    float64 `json:"checks"`
+		Fails     float64 `json:"fails"`
+		Unhealthy float64 `json:"unhealthy"`
+	} `json:"health_checks"`
+}
+
+func (r plusResponses) setStats(stat map[string]interface{}, keyPrefix string) {
+	vtsResponses(r).setStats(stat, keyPrefix)
+}
+
+// parsePlus parses the responses of the endpoints of nginx Plus API keyed by the paths
+func (n NginxPlugin) parsePlus(bodies map[string]io.Reader) (map[string]interface{}, error) {
+	var conns plusConnections
+	var requests plusRequests
+	var zones map[string]plusServerZone
+	var upstreams map[string]plusUpstream
+	for path, v := range map[string]interface{}{
+		plusConnectionsPath: &conns,
+		plusRequestsPath:    &requests,
+		plusServerZonesPath: &zones,
+		plusUpstreamsPath:   &upstreams,
+	} {
+		if err := json.NewDecoder(bodies[path]).Decode(v); err != nil {
+			return nil, err
+		}
+	}
+
+	stat := make(map[string]interface{})
+	stat["connections"] = conns.Active
+	stat["accepts"] = conns.Accepted
+	stat["handled"] = conns.Accepted - conns.Dropped
+	stat["requests"] = requests.Total
+	stat["waiting"] = conns.Idle
+
+	for name, z := range zones {
+		zone := metricName(name)
+		stat["nginx.server_zone.requests."+zone+".requests"] = z.Requests
+		z.Responses.setStats(stat, "nginx.server_zone.responses."+zone+".")
+		stat["nginx.server_zone.traffic."+zone+".in_bytes"] = z.Received
+		stat["nginx.server_zone.traffic."+zone+".out_bytes"] = z.Sent
+	}
+
+	for upstream, u := range upstreams {
+		for _, p := range u.Peers {
+			peer := peerName(upstream, p.Server)
+			setPeerState(stat, peer, p.State)
+			stat["nginx.upstream.active."+peer+".active"] = p.Active
+			stat["nginx.upstream.requests."+peer+".requests"] = p.Requests
+			p.Responses.setStats(stat, "nginx.upstream.responses."+peer+".")
+			stat["nginx.upstream.traffic."+peer+".received"] = p.Received
+			stat["nginx.upstream.traffic."+peer+".sent"] = p.Sent
+			// the times are absent until the peer responds
+			if p.ResponseTime != nil {
+				stat["nginx.upstream.response_time."+peer+".response_time"] = *p.ResponseTime
+			}
+			if p.HeaderTime != nil {
+				stat["nginx.upstream.response_time."+peer+".header_time"] = *p.HeaderTime
+			}
+			stat["nginx.upstream.fails."+peer+".fails"] = p.Fails
+			stat["nginx.upstream.fails."+peer+".health_check_fails"] = p.HealthChecks.Fails
+			stat["nginx.upstream.fails."+peer+".unhealthy"] = p.HealthChecks.Unhealthy
+		}
+	}
+	return stat, nil
+}
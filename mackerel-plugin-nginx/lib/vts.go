@@ -0,0 +1,100 @@
+package mpnginx
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// vtsStatus is the JSON of nginx-module-vts, e.g. /status/format/json
+type vtsStatus struct {
+	Connections struct {
+		Active   float64 `json:"active"`
+		Reading  float64 `json:"reading"`
+		Writing  float64 `json:"writing"`
+		Waiting  float64 `json:"waiting"`
+		Accepted float64 `json:"accepted"`
+		Handled  float64 `json:"handled"`
+		Requests float64 `json:"requests"`
+	} `json:"connections"`
+	ServerZones   map[string]vtsZone   `json:"serverZones"`
+	UpstreamZones map[string][]vtsPeer `json:"upstreamZones"`
+}
+
+type vtsResponses struct {
+	Status1xx float64 `json:"1xx"`
+	Status2xx float64 `json:"2xx"`
+	Status3xx float64 `json:"3xx"`
+	Status4xx float64 `json:"4xx"`
+	Status5xx float64 `json:"5xx"`
+}
+
+type vtsZone struct {
+	RequestCounter float64      `json:"requestCounter"`
+	InBytes        float64      `json:"inBytes"`
+	OutBytes       float64      `json:"outBytes"`
+	Responses      vtsResponses `json:"responses"`
+}
+
+type vtsPeer struct {
+	Server         string       `json:"server"`
+	RequestCounter float64      `json:"requestCounter"`
+	InBytes        float64      `json:"inBytes"`
+	OutBytes       float64      `json:"outBytes"`
+	Responses      vtsResponses `json:"responses"`
+	ResponseMsec   float64      `json:"responseMsec"`
+	Backup         bool         `json:"backup"`
+	Down           bool         `json:"down"`
+}
+
+func (r vtsResponses) setStats(stat map[string]interface{}, keyPrefix string) {
+	stat[keyPrefix+"1xx"] = r.Status1xx
+	stat[keyPrefix+"2xx"] = r.Status2xx
+	stat[keyPrefix+"3xx"] = r.Status3xx
+	stat[keyPrefix+"4xx"] = r.Status4xx
+	stat[keyPrefix+"5xx"] = r.Status5xx
+}
+
+func (n NginxPlugin) parseVTS(body io.Reader) (map[string]interface{}, error) {
+	var status vtsStatus
+	if err := json.NewDecoder(body).Decode(&status); err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]interface{})
+	stat["connections"] = status.Connections.Active
+	stat["accepts"] = status.Connections.Accepted
+	stat["handled"] = status.Connections.Handled
+	stat["requests"] = status.Connections.Requests
+	stat["reading"] = status.Connections.Reading
+	stat["writing"] = status.Connections.Writing
+	stat["waiting"] = status.Connections.Waiting
+
+	for name, z := range status.ServerZones {
+		zone := metricName(name)
+		if name == "*" {
+			// the sum of all server zones
+			zone = "total"
+		}
+		stat["nginx.server_zone.requests."+zone+".requests"] = z.RequestCounter
+		z.Responses.setStats(stat, "nginx.server_zone.responses."+zone+".")
+		stat["nginx.server_zone.traffic."+zone+".in_bytes"] = z.InBytes
+		stat["nginx.server_zone.traffic."+zone+".out_bytes"] = z.OutBytes
+	}
+
+	for upstream, peers := range status.UpstreamZones {
+		for _, p := range peers {
+			peer := peerName(upstream, p.Server)
+			state := "up"
+			if p.Down {
+				state = "down"
+			}
+			setPeerState(stat, peer, state)
+			stat["nginx.upstream.requests."+peer+".requests"] = p.RequestCounter
+			p.Responses.setStats(stat, "nginx.upstream.responses."+peer+".")
+			stat["nginx.upstream.traffic."+peer+".received"] = p.InBytes
+			stat["nginx.upstream.traffic."+peer+".sent"] = p.OutBytes
+			stat["nginx.upstream.response_time."+peer+".response_time"] = p.ResponseMsec
+		}
+	}
+	return stat, nil
+}
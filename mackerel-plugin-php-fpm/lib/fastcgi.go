@@ -0,0 +1,164 @@
+package mpphpfpm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// https://fast-cgi.github.io/spec
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+	fcgiResponder    = 1
+	fcgiRequestID    = 1
+)
+
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestID     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func writeRecord(w io.Writer, recType uint8, content []byte) error {
+	h := fcgiHeader{
+		Version:       fcgiVersion,
+		Type:          recType,
+		RequestID:     fcgiRequestID,
+		ContentLength: uint16(len(content)),
+		PaddingLength: uint8(-len(content) & 7),
+	}
+	if err := binary.Write(w, binary.BigEndian, h); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, h.PaddingLength))
+	return err
+}
+
+func encodeLength(b *bytes.Buffer, n int) {
+	if n < 128 {
+		b.WriteByte(byte(n))
+		return
+	}
+	binary.Write(b, binary.BigEndian, uint32(n)|1<<31)
+}
+
+func encodeParams(params map[string]string) []byte {
+	var b bytes.Buffer
+	for k, v := range params {
+		encodeLength(&b, len(k))
+		encodeLength(&b, len(v))
+		b.WriteString(k)
+		b.WriteString(v)
+	}
+	return b.Bytes()
+}
+
+// fcgiGet requests the script to the FastCGI server like a GET request of HTTP, and returns the body of the response
+func fcgiGet(network, address, scriptName, query string, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// no timeout is 0 like net.DialTimeout
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	w := bufio.NewWriter(conn)
+	// role and flags, and 5 reserved bytes
+	if err := writeRecord(w, fcgiBeginRequest, []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	params := encodeParams(map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    "GET",
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptName,
+		"REQUEST_URI":       scriptName + "?" + query,
+		"QUERY_STRING":      query,
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+	})
+	if err := writeRecord(w, fcgiParams, params); err != nil {
+		return nil, err
+	}
+	if err := writeRecord(w, fcgiParams, nil); err != nil {
+		return nil, err
+	}
+	if err := writeRecord(w, fcgiStdin, nil); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	for {
+		var h fcgiHeader
+		if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+			return nil, err
+		}
+		content := make([]byte, int(h.ContentLength)+int(h.PaddingLength))
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		content = content[:h.ContentLength]
+		switch h.Type {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		}
+		if h.Type == fcgiEndRequest {
+			break
+		}
+	}
+	return parseCGIResponse(&stdout, stderr.String())
+}
+
+// parseCGIResponse returns the body of the CGI response, or the error for the status other than 200
+func parseCGIResponse(stdout io.Reader, stderr string) ([]byte, error) {
+	r := textproto.NewReader(bufio.NewReader(stdout))
+	header, err := r.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if status := header.Get("Status"); status != "" && !strings.HasPrefix(status, "200") {
+		if stderr != "" {
+			return nil, fmt.Errorf("status %s: %s", status, strings.TrimSpace(stderr))
+		}
+		return nil, fmt.Errorf("status %s", status)
+	}
+	body, err := ioutil.ReadAll(r.R)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		if stderr != "" {
+			return nil, errors.New(strings.TrimSpace(stderr))
+		}
+		return nil, errors.New("empty response")
+	}
+	return body, nil
+}
@@ -2,10 +2,15 @@ package mpphpfpm
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"sort"
 	"time"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
@@ -13,10 +18,17 @@ import (
 
 // PhpFpmPlugin mackerel plugin
 type PhpFpmPlugin struct {
-	URL         string
+	URL string
+	// Socket is the FastCGI address of the pool used instead of URL, a path of the unix socket or "host:port"
+	Socket      string
+	StatusPath  string
 	Prefix      string
 	LabelPrefix string
 	Timeout     uint
+	// Full enables the percentiles of the processes with ?full
+	Full bool
+
+	pools []pool
 }
 
 // PhpFpmStatus struct for PhpFpmPlugin mackerel plugin
@@ -35,6 +47,18 @@ type PhpFpmStatus struct {
 	MaxActiveProcesses uint64 `json:"max active processes"`
 	MaxChildrenReached uint64 `json:"max children reached"`
 	SlowRequests       uint64 `json:"slow requests"`
+	// Processes is reported with ?full
+	Processes []PhpFpmProcess `json:"processes"`
+}
+
+// PhpFpmProcess is the status of the process reported with ?full
+type PhpFpmProcess struct {
+	PID   uint64 `json:"pid"`
+	State string `json:"state"`
+	// RequestDuration is in microseconds, which is of the current request for the running process
+	RequestDuration   uint64  `json:"request duration"`
+	LastRequestCPU    float64 `json:"last request cpu"`
+	LastRequestMemory uint64  `json:"last request memory"`
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -44,7 +68,20 @@ func (p PhpFpmPlugin) MetricKeyPrefix() string {
 
 // GraphDefinition interface for mackerelplugin
 func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
-	return map[string]mp.Graphs{
+	graphdef := p.graphdef()
+	if len(p.pools) == 0 {
+		return graphdef
+	}
+	// the metrics are posted per pool, such as "processes.<pool>.total_processes"
+	wildcard := make(map[string]mp.Graphs, len(graphdef))
+	for key, g := range graphdef {
+		wildcard[key+".#"] = g
+	}
+	return wildcard
+}
+
+func (p PhpFpmPlugin) graphdef() map[string]mp.Graphs {
+	graphdef := map[string]mp.Graphs{
 		"processes": {
 			Label: p.LabelPrefix + " Processes",
 			Unit:  "integer",
@@ -91,16 +128,88 @@ func (p PhpFpmPlugin) GraphDefinition() map[string]mp.Graphs {
 			},
 		},
 	}
+	if p.Full {
+		graphdef["request_duration"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Request Duration (ms)",
+			Unit:    "float",
+			Metrics: percentileMetrics("request_duration"),
+		}
+		graphdef["request_cpu"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Last Request CPU",
+			Unit:    "percentage",
+			Metrics: percentileMetrics("request_cpu"),
+		}
+		graphdef["request_memory"] = mp.Graphs{
+			Label:   p.LabelPrefix + " Last Request Memory",
+			Unit:    "bytes",
+			Metrics: percentileMetrics("request_memory"),
+		}
+	}
+	return graphdef
+}
+
+var percentiles = []int{50, 90, 99}
+
+func percentileMetrics(name string) []mp.Metrics {
+	var metrics []mp.Metrics
+	for _, n := range percentiles {
+		metrics = append(metrics, mp.Metrics{Name: fmt.Sprintf("%s_p%d", name, n), Label: fmt.Sprintf("%dth percentile", n), Diff: false})
+	}
+	return append(metrics, mp.Metrics{Name: name + "_max", Label: "Max", Diff: false})
+}
+
+// setPercentiles sets the percentiles of the values of the processes with the nearest-rank method
+func setPercentiles(stat map[string]interface{}, name string, values []float64) {
+	if len(values) == 0 {
+		return
+	}
+	sort.Float64s(values)
+	for _, n := range percentiles {
+		rank := int(math.Ceil(float64(n) / 100 * float64(len(values))))
+		if rank < 1 {
+			rank = 1
+		}
+		stat[fmt.Sprintf("%s_p%d", name, n)] = values[rank-1]
+	}
+	stat[name+"_max"] = values[len(values)-1]
 }
 
 // FetchMetrics interface for mackerelplugin
 func (p PhpFpmPlugin) FetchMetrics() (map[string]interface{}, error) {
-	status, err := getStatus(p)
-	if err != nil {
-		return nil, fmt.Errorf("Faild to fetch PHP-FPM metrics: %s", err)
+	if len(p.pools) == 0 {
+		status, err := getStatus(p)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch PHP-FPM metrics: %s", err)
+		}
+		return p.statusMetrics(status), nil
 	}
 
-	return map[string]interface{}{
+	graphKeys := make(map[string]string)
+	for key, g := range p.graphdef() {
+		for _, m := range g.Metrics {
+			graphKeys[m.Name] = key
+		}
+	}
+	stat := make(map[string]interface{})
+	for _, pl := range p.pools {
+		status, err := getFastCGIStatus(pl.Network, pl.Address, pl.StatusPath, p.Timeout, p.Full)
+		if err != nil {
+			log.Printf("Failed to fetch PHP-FPM metrics of pool %s: %s", pl.Name, err)
+			continue
+		}
+		name := metricNameRe.ReplaceAllString(pl.Name, "_")
+		for k, v := range p.statusMetrics(status) {
+			stat[graphKeys[k]+"."+name+"."+k] = v
+		}
+	}
+	if len(stat) == 0 {
+		return nil, errors.New("Failed to fetch PHP-FPM metrics of all pools")
+	}
+	return stat, nil
+}
+
+func (p PhpFpmPlugin) statusMetrics(status *PhpFpmStatus) map[string]interface{} {
+	stat := map[string]interface{}{
 		"total_processes":      status.TotalProcesses,
 		"active_processes":     status.ActiveProcesses,
 		"idle_processes":       status.IdleProcesses,
@@ -110,20 +219,47 @@ func (p PhpFpmPlugin) FetchMetrics() (map[string]interface{}, error) {
 		"listen_queue_len":     status.ListenQueueLen,
 		"max_listen_queue":     status.MaxListenQueue,
 		"slow_requests":        status.SlowRequests,
-	}, nil
+	}
+	if p.Full {
+		var durations, cpus, memories []float64
+		for _, proc := range status.Processes {
+			durations = append(durations, float64(proc.RequestDuration)/1000)
+			cpus = append(cpus, proc.LastRequestCPU)
+			memories = append(memories, float64(proc.LastRequestMemory))
+		}
+		setPercentiles(stat, "request_duration", durations)
+		setPercentiles(stat, "request_cpu", cpus)
+		setPercentiles(stat, "request_memory", memories)
+	}
+	return stat
 }
 
 func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
-	url := p.URL
+	if p.Socket != "" {
+		network, address := listenAddress(p.Socket)
+		return getFastCGIStatus(network, address, p.StatusPath, p.Timeout, p.Full)
+	}
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	if p.Full {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += "full"
+	}
 	timeout := time.Duration(time.Duration(p.Timeout) * time.Second)
 	client := http.Client{
 		Timeout: timeout,
 	}
 
-	res, err := client.Get(url)
+	res, err := client.Get(u.String())
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -136,9 +272,30 @@ func getStatus(p PhpFpmPlugin) (*PhpFpmStatus, error) {
 	return status, nil
 }
 
+// getFastCGIStatus requests the status page to the pool with FastCGI
+func getFastCGIStatus(network, address, statusPath string, timeout uint, full bool) (*PhpFpmStatus, error) {
+	query := "json"
+	if full {
+		query += "&full"
+	}
+	body, err := fcgiGet(network, address, statusPath, query, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var status PhpFpmStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
 // Do the plugin
 func Do() {
 	optURL := flag.String("url", "http://localhost/status?json", "PHP-FPM status page URL")
+	optSocket := flag.String("socket", "", "FastCGI address of the pool, unix socket path or host:port (instead of -url)")
+	optStatusPath := flag.String("status-path", "/status", "pm.status_path of the pool for -socket")
+	optConfigDir := flag.String("config-dir", "", "PHP-FPM pool config directory to discover the pools with pm.status_path (e.g. /etc/php-fpm.d)")
+	optFull := flag.Bool("full", false, "Post the percentiles of the request duration, CPU and memory of the processes")
 	optPrefix := flag.String("metric-key-prefix", "php-fpm", "Metric key prefix")
 	optLabelPrefix := flag.String("metric-label-prefix", "PHP-FPM", "Metric label prefix")
 	optTimeout := flag.Uint("timeout", 5, "Timeout")
@@ -147,9 +304,22 @@ func Do() {
 
 	p := PhpFpmPlugin{
 		URL:         *optURL,
+		Socket:      *optSocket,
+		StatusPath:  *optStatusPath,
 		Prefix:      *optPrefix,
 		LabelPrefix: *optLabelPrefix,
 		Timeout:     *optTimeout,
+		Full:        *optFull,
+	}
+	if *optConfigDir != "" {
+		pools, err := discoverPools(*optConfigDir)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(pools) == 0 {
+			log.Fatalf("no pools with pm.status_path are found in %s", *optConfigDir)
+		}
+		p.pools = pools
 	}
 	helper := mp.NewMackerelPlugin(p)
 	helper.Tempfile = *optTempfile
@@ -1,6 +1,14 @@
 package mpphpfpm
 
 import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/jarcoal/httpmock"
@@ -48,3 +56,90 @@ func TestGetStatus(t *testing.T) {
 	assert.EqualValues(t, 3, status.MaxListenQueue)
 	assert.EqualValues(t, 1000, status.SlowRequests)
 }
+
+func TestParsePoolConfig(t *testing.T) {
+	conf := `; pool of the web application
+[www]
+user = nginx
+listen = /run/php-fpm/$pool.sock
+pm = dynamic
+pm.status_path = /status
+
+[admin]
+listen = 127.0.0.1:9001
+pm.status_path = "/fpm-status"
+
+[batch]
+listen = 9002
+; no status page
+`
+	pools, err := parsePoolConfig(strings.NewReader(conf))
+	assert.Nil(t, err)
+	assert.Equal(t, []pool{
+		{Name: "www", Network: "unix", Address: "/run/php-fpm/www.sock", StatusPath: "/status"},
+		{Name: "admin", Network: "tcp", Address: "127.0.0.1:9001", StatusPath: "/fpm-status"},
+	}, pools)
+}
+
+const fullStatus = `{"pool":"www","process manager":"dynamic","start time":1461398921,"start since":1624,"accepted conn":664,
+"listen queue":0,"max listen queue":3,"listen queue len":128,"idle processes":2,"active processes":2,"total processes":4,
+"max active processes":4,"max children reached":0,"slow requests":5,
+"processes":[
+{"pid":101,"state":"Idle","start time":1461398921,"start since":1624,"requests":120,"request duration":1500,"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/var/www/index.php","last request cpu":0.00,"last request memory":2097152},
+{"pid":102,"state":"Running","start time":1461398921,"start since":1624,"requests":98,"request duration":25000000,"request method":"POST","request uri":"/import.php","content length":1024,"user":"-","script":"/var/www/import.php","last request cpu":0.00,"last request memory":0},
+{"pid":103,"state":"Idle","start time":1461398921,"start since":1624,"requests":130,"request duration":3000,"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/var/www/index.php","last request cpu":66.67,"last request memory":4194304},
+{"pid":104,"state":"Running","start time":1461398921,"start since":1624,"requests":110,"request duration":2000,"request method":"GET","request uri":"/status?json&full","content length":0,"user":"-","script":"-","last request cpu":0.00,"last request memory":0}
+]}`
+
+func TestFetchMetricsFastCGI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-php-fpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "www.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "json&full", r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, fullStatus)
+	}))
+
+	p := PhpFpmPlugin{
+		Prefix:  "php-fpm",
+		Timeout: 5,
+		Full:    true,
+		pools: []pool{
+			{Name: "www", Network: "unix", Address: socket, StatusPath: "/status"},
+			{Name: "missing", Network: "unix", Address: socket, StatusPath: "/missing"},
+		},
+	}
+	stat, err := p.FetchMetrics()
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, stat["processes.www.total_processes"])
+	assert.EqualValues(t, 5, stat["slow_requests.www.slow_requests"])
+	assert.EqualValues(t, 2, stat["request_duration.www.request_duration_p50"])
+	assert.EqualValues(t, 25000, stat["request_duration.www.request_duration_p99"])
+	assert.EqualValues(t, 66.67, stat["request_cpu.www.request_cpu_max"])
+	assert.EqualValues(t, 4194304, stat["request_memory.www.request_memory_p90"])
+	_, ok := stat["processes.missing.total_processes"]
+	assert.False(t, ok, "the pool with the wrong status path is skipped")
+
+	p.Timeout = 0
+	stat, err = p.FetchMetrics()
+	assert.Nil(t, err, "no timeout")
+	assert.EqualValues(t, 4, stat["processes.www.total_processes"])
+
+	graphdef := p.GraphDefinition()
+	assert.Contains(t, graphdef, "processes.#")
+	assert.Contains(t, graphdef, "request_duration.#")
+}
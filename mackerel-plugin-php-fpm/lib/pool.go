@@ -0,0 +1,109 @@
+package mpphpfpm
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// pool is a pool of PHP-FPM with the status page
+type pool struct {
+	Name       string
+	Network    string
+	Address    string
+	StatusPath string
+}
+
+// discoverPools lists the pools with pm.status_path in the config files of the directory like /etc/php-fpm.d
+func discoverPools(dir string) ([]pool, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	var pools []pool
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		ps, err := parsePoolConfig(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, ps...)
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return pools[i].Name < pools[j].Name
+	})
+	return pools, nil
+}
+
+// parsePoolConfig parses the pool sections of the INI file of PHP-FPM
+func parsePoolConfig(r io.Reader) ([]pool, error) {
+	var pools []pool
+	var name string
+	values := map[string]string{}
+	flush := func() {
+		if name == "" || name == "global" {
+			return
+		}
+		listen, statusPath := values["listen"], values["pm.status_path"]
+		if listen == "" || statusPath == "" {
+			return
+		}
+		network, address := listenAddress(listen)
+		pools = append(pools, pool{
+			Name:       name,
+			Network:    network,
+			Address:    address,
+			StatusPath: statusPath,
+		})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			flush()
+			name = strings.TrimSpace(line[1 : len(line)-1])
+			values = map[string]string{}
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		values[strings.TrimSpace(kv[0])] = strings.Replace(value, "$pool", name, -1)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return pools, nil
+}
+
+// listenAddress returns the address of the listen directive, which is a path of the unix socket, "ip:port" or "port"
+func listenAddress(listen string) (string, string) {
+	if strings.HasPrefix(listen, "/") {
+		return "unix", listen
+	}
+	if !strings.Contains(listen, ":") {
+		return "tcp", "127.0.0.1:" + listen
+	}
+	// listening on all addresses
+	if strings.HasPrefix(listen, "*:") {
+		return "tcp", "127.0.0.1" + listen[1:]
+	}
+	return "tcp", listen
+}
@@ -0,0 +1,101 @@
+package mppostgres
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// the transaction IDs are wrapped around after 2^31 transactions
+const maxXIDAge = 2147483647
+
+// bgwriterQuery returns the query of the checkpoints and the buffers written, which are moved to pg_stat_checkpointer in PostgreSQL 17
+func bgwriterQuery(version version) string {
+	if version.atLeast(17, 0) {
+		// buffers_backend is moved to pg_stat_io
+		return `select c.num_timed as checkpoints_timed, c.num_requested as checkpoints_req,
+			c.write_time as checkpoint_write_time, c.sync_time as checkpoint_sync_time,
+			c.buffers_written as buffers_checkpoint, b.buffers_clean, null as buffers_backend
+		from pg_stat_checkpointer c, pg_stat_bgwriter b`
+	}
+	return `select checkpoints_timed, checkpoints_req, checkpoint_write_time, checkpoint_sync_time,
+			buffers_checkpoint, buffers_clean, buffers_backend
+		from pg_stat_bgwriter`
+}
+
+func fetchBgwriter(db *sqlx.DB, version version) (map[string]interface{}, error) {
+	var timed, req, writeTime, syncTime, buffersCheckpoint, buffersClean float64
+	var buffersBackend *float64
+	err := db.QueryRow(bgwriterQuery(version)).Scan(&timed, &req, &writeTime, &syncTime, &buffersCheckpoint, &buffersClean, &buffersBackend)
+	if err != nil {
+		logger.Warningf("Failed to select pg_stat_bgwriter. %s", err)
+		return nil, err
+	}
+
+	stat := map[string]interface{}{
+		"checkpoints_timed":     timed,
+		"checkpoints_req":       req,
+		"checkpoint_write_time": writeTime,
+		"checkpoint_sync_time":  syncTime,
+		"buffers_checkpoint":    buffersCheckpoint,
+		"buffers_clean":         buffersClean,
+	}
+	if buffersBackend != nil {
+		stat["buffers_backend"] = *buffersBackend
+	}
+	return stat, nil
+}
+
+// fetchXIDAge fetches the age of the oldest transaction ID not frozen in the databases
+func fetchXIDAge(db *sqlx.DB) (map[string]interface{}, error) {
+	var age float64
+	if err := db.QueryRow(`select max(age(datfrozenxid)) from pg_database`).Scan(&age); err != nil {
+		logger.Warningf("Failed to select the age of datfrozenxid. %s", err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"xid_age":                   age,
+		"xid_remaining":             maxXIDAge - age,
+		"xid_wraparound_percentage": 100.0 * age / maxXIDAge,
+	}, nil
+}
+
+// fetchOldestTransaction fetches the seconds since the oldest transaction in progress started
+func fetchOldestTransaction(db *sqlx.DB) (map[string]interface{}, error) {
+	var age float64
+	err := db.QueryRow(`select coalesce(extract(epoch from max(now() - xact_start)), 0) from pg_stat_activity where xact_start is not null and state <> 'idle'`).Scan(&age)
+	if err != nil {
+		logger.Warningf("Failed to select the oldest transaction. %s", err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"oldest_xact_age": age,
+	}, nil
+}
+
+// fetchTableTuples fetches the tuples of the tables with the most dead tuples in the database connected
+func fetchTableTuples(db *sqlx.DB, limit int) (map[string]interface{}, error) {
+	rows, err := db.Query(`select schemaname, relname, n_live_tup, n_dead_tup from pg_stat_user_tables order by n_dead_tup desc limit $1`, limit)
+	if err != nil {
+		logger.Warningf("Failed to select pg_stat_user_tables. %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	stat := make(map[string]interface{})
+	for rows.Next() {
+		var schema, relname string
+		var live, dead float64
+		if err := rows.Scan(&schema, &relname, &live, &dead); err != nil {
+			logger.Warningf("Failed to scan %s", err)
+			continue
+		}
+		name := normalizeMetricName(schema + "." + relname)
+		stat["table_tuples."+name+".n_live_tup"] = live
+		stat["table_tuples."+name+".n_dead_tup"] = dead
+		ratio := 0.0
+		if live+dead > 0 {
+			ratio = 100.0 * dead / (live + dead)
+		}
+		stat["table_dead_tuple_ratio."+name+".ratio"] = ratio
+	}
+	return stat, nil
+}
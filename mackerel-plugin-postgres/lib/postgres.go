@@ -29,6 +29,8 @@ type PostgresPlugin struct {
 	Timeout  int
 	Tempfile string
 	Option   string
+	// the number of the tables with the most dead tuples posted
+	TopTables int
 }
 
 func fetchStatDatabase(db *sqlx.DB) (map[string]interface{}, error) {
@@ -40,6 +42,8 @@ func fetchStatDatabase(db *sqlx.DB) (map[string]interface{}, error) {
 	}
 
 	type pgStat struct {
+		Datname      *string  `db:"datname"`
+		NumBackends  uint64   `db:"numbackends"`
 		XactCommit   uint64   `db:"xact_commit"`
 		XactRollback uint64   `db:"xact_rollback"`
 		BlksRead     uint64   `db:"blks_read"`
@@ -55,6 +59,7 @@ func fetchStatDatabase(db *sqlx.DB) (map[string]interface{}, error) {
 		TempBytes    *uint64  `db:"temp_bytes"`
 	}
 
+	stat := make(map[string]interface{})
 	totalStat := pgStat{}
 	for rows.Next() {
 		p := pgStat{}
@@ -62,6 +67,26 @@ func fetchStatDatabase(db *sqlx.DB) (map[string]interface{}, error) {
 			logger.Warningf("Failed to scan. %s", err)
 			continue
 		}
+		// the row of the shared objects has no datname since PostgreSQL 12
+		if p.Datname != nil && !isTemplateDatabase(*p.Datname) {
+			name := normalizeMetricName(*p.Datname)
+			stat["db_connections."+name+".numbackends"] = p.NumBackends
+			stat["db_commits."+name+".xact_commit"] = p.XactCommit
+			stat["db_commits."+name+".xact_rollback"] = p.XactRollback
+			stat["db_blocks."+name+".blks_read"] = p.BlksRead
+			stat["db_blocks."+name+".blks_hit"] = p.BlksHit
+			stat["db_rows."+name+".tup_returned"] = p.TupReturned
+			stat["db_rows."+name+".tup_fetched"] = p.TupFetched
+			stat["db_rows."+name+".tup_inserted"] = p.TupInserted
+			stat["db_rows."+name+".tup_updated"] = p.TupUpdated
+			stat["db_rows."+name+".tup_deleted"] = p.TupDeleted
+			if p.Deadlocks != nil {
+				stat["db_deadlocks."+name+".deadlocks"] = *p.Deadlocks
+			}
+			if p.TempBytes != nil {
+				stat["db_tempfile."+name+".temp_bytes"] = *p.TempBytes
+			}
+		}
 		totalStat.XactCommit += p.XactCommit
 		totalStat.XactRollback += p.XactRollback
 		totalStat.BlksRead += p.BlksRead
@@ -100,7 +125,6 @@ func fetchStatDatabase(db *sqlx.DB) (map[string]interface{}, error) {
 			}
 		}
 	}
-	stat := make(map[string]interface{})
 	stat["xact_commit"] = totalStat.XactCommit
 	stat["xact_rollback"] = totalStat.XactRollback
 	stat["blks_read"] = totalStat.BlksRead
@@ -169,25 +193,39 @@ func fetchConnections(db *sqlx.DB, version version) (map[string]interface{}, err
 }
 
 func fetchDatabaseSize(db *sqlx.DB) (map[string]interface{}, error) {
-	rows, err := db.Query("select sum(pg_database_size(datname)) as dbsize from pg_database where has_database_privilege(datname, 'connect')")
+	rows, err := db.Query("select datname, pg_database_size(datname) as dbsize from pg_database where has_database_privilege(datname, 'connect')")
 	if err != nil {
 		logger.Errorf("Failed to select pg_database_size. %s", err)
 		return nil, err
 	}
 
+	stat := make(map[string]interface{})
 	var totalSize float64
 	for rows.Next() {
+		var datname string
 		var dbsize float64
-		if err := rows.Scan(&dbsize); err != nil {
+		if err := rows.Scan(&datname, &dbsize); err != nil {
 			logger.Warningf("Failed to scan %s", err)
 			continue
 		}
 		totalSize += dbsize
+		if !isTemplateDatabase(datname) {
+			stat["db_size."+normalizeMetricName(datname)+".size"] = dbsize
+		}
 	}
+	stat["total_size"] = totalSize
 
-	return map[string]interface{}{
-		"total_size": totalSize,
-	}, nil
+	return stat, nil
+}
+
+func isTemplateDatabase(datname string) bool {
+	return datname == "template0" || datname == "template1"
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+func normalizeMetricName(name string) string {
+	return metricNameRe.ReplaceAllString(name, "_")
 }
 
 var versionRe = regexp.MustCompile("PostgreSQL (\\d+)\\.(\\d+)(\\.(\\d+))? ")
@@ -240,6 +278,11 @@ func fetchVersion(db *sqlx.DB) (version, error) {
 	return res, errors.New("failed to select version()")
 }
 
+// atLeast returns true if the version is the major version or later
+func (v version) atLeast(first, second uint) bool {
+	return v.first > first || v.first == first && v.second >= second
+}
+
 func mergeStat(dst, src map[string]interface{}) {
 	for k, v := range src {
 		dst[k] = v
@@ -288,7 +331,32 @@ func (p PostgresPlugin) FetchMetrics() (map[string]interface{}, error) {
 	mergeStat(stat, statConnections)
 	mergeStat(stat, statDatabaseSize)
 
-	return stat, err
+	// the following metrics are skipped on failure, such as the lack of the privileges
+	// the replication and WAL of the primary are skipped also on the failure of pg_is_in_recovery()
+	if inRecovery, err := fetchInRecovery(db); err == nil && !inRecovery {
+		if statReplication, err := fetchReplication(db, version); err == nil {
+			mergeStat(stat, statReplication)
+		}
+		if statWAL, err := fetchWAL(db, version); err == nil {
+			mergeStat(stat, statWAL)
+		}
+	}
+	if statBgwriter, err := fetchBgwriter(db, version); err == nil {
+		mergeStat(stat, statBgwriter)
+	}
+	if statXID, err := fetchXIDAge(db); err == nil {
+		mergeStat(stat, statXID)
+	}
+	if statOldestXact, err := fetchOldestTransaction(db); err == nil {
+		mergeStat(stat, statOldestXact)
+	}
+	if p.TopTables > 0 {
+		if statTables, err := fetchTableTuples(db, p.TopTables); err == nil {
+			mergeStat(stat, statTables)
+		}
+	}
+
+	return stat, nil
 }
 
 // GraphDefinition interface for mackerelplugin
@@ -365,6 +433,151 @@ func (p PostgresPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "temp_bytes", Label: "Temporary file size (byte)", Diff: true, Stacked: false},
 			},
 		},
+		"db_connections.#": {
+			Label: (labelPrefix + " Connections per Database"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "numbackends", Label: "Backends", Diff: false, Stacked: false},
+			},
+		},
+		"db_commits.#": {
+			Label: (labelPrefix + " Commits per Database"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "xact_commit", Label: "Xact Commit", Diff: true, Stacked: false},
+				{Name: "xact_rollback", Label: "Xact Rollback", Diff: true, Stacked: false},
+			},
+		},
+		"db_blocks.#": {
+			Label: (labelPrefix + " Blocks per Database"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "blks_read", Label: "Blocks Read", Diff: true, Stacked: false},
+				{Name: "blks_hit", Label: "Blocks Hit", Diff: true, Stacked: false},
+			},
+		},
+		"db_rows.#": {
+			Label: (labelPrefix + " Rows per Database"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "tup_returned", Label: "Returned Rows", Diff: true, Stacked: false},
+				{Name: "tup_fetched", Label: "Fetched Rows", Diff: true, Stacked: true},
+				{Name: "tup_inserted", Label: "Inserted Rows", Diff: true, Stacked: true},
+				{Name: "tup_updated", Label: "Updated Rows", Diff: true, Stacked: true},
+				{Name: "tup_deleted", Label: "Deleted Rows", Diff: true, Stacked: true},
+			},
+		},
+		"db_deadlocks.#": {
+			Label: (labelPrefix + " Dead Locks per Database"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "deadlocks", Label: "Deadlocks", Diff: true, Stacked: false},
+			},
+		},
+		"db_tempfile.#": {
+			Label: (labelPrefix + " Temporary file per Database"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "temp_bytes", Label: "Temporary file size (byte)", Diff: true, Stacked: false},
+			},
+		},
+		"db_size.#": {
+			Label: (labelPrefix + " Data Size per Database"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "size", Label: "Size", Diff: false, Stacked: false},
+			},
+		},
+		"replication_lag_bytes.#": {
+			Label: (labelPrefix + " Replication Lag (bytes)"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "write", Label: "Write", Diff: false, Stacked: false},
+				{Name: "flush", Label: "Flush", Diff: false, Stacked: false},
+				{Name: "replay", Label: "Replay", Diff: false, Stacked: false},
+			},
+		},
+		"replication_lag_seconds.#": {
+			Label: (labelPrefix + " Replication Lag (sec)"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "write", Label: "Write", Diff: false, Stacked: false},
+				{Name: "flush", Label: "Flush", Diff: false, Stacked: false},
+				{Name: "replay", Label: "Replay", Diff: false, Stacked: false},
+			},
+		},
+		"wal": {
+			Label: (labelPrefix + " WAL"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "wal_bytes", Label: "WAL Generated", Diff: true, Stacked: false},
+			},
+		},
+		"checkpoints": {
+			Label: (labelPrefix + " Checkpoints"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "checkpoints_timed", Label: "Scheduled", Diff: true, Stacked: false},
+				{Name: "checkpoints_req", Label: "Requested", Diff: true, Stacked: false},
+			},
+		},
+		"checkpoint_time": {
+			Label: (labelPrefix + " Checkpoint time"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "checkpoint_write_time", Label: "Write Time (ms)", Diff: true, Stacked: false},
+				{Name: "checkpoint_sync_time", Label: "Sync Time (ms)", Diff: true, Stacked: false},
+			},
+		},
+		"buffers_written": {
+			Label: (labelPrefix + " Buffers Written"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "buffers_checkpoint", Label: "Checkpoint", Diff: true, Stacked: true},
+				{Name: "buffers_clean", Label: "Background Writer", Diff: true, Stacked: true},
+				{Name: "buffers_backend", Label: "Backend", Diff: true, Stacked: true},
+			},
+		},
+		"xid": {
+			Label: (labelPrefix + " Transaction ID"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "xid_age", Label: "Oldest Age", Diff: false, Stacked: false},
+				{Name: "xid_remaining", Label: "Remaining before Wraparound", Diff: false, Stacked: false},
+			},
+		},
+		"xid_wraparound": {
+			Label: (labelPrefix + " Transaction ID Wraparound"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "xid_wraparound_percentage", Label: "Percentage towards Wraparound", Diff: false, Stacked: false},
+			},
+		},
+		"oldest_transaction": {
+			Label: (labelPrefix + " Oldest Transaction"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "oldest_xact_age", Label: "Age (sec)", Diff: false, Stacked: false},
+			},
+		},
+	}
+
+	if p.TopTables > 0 {
+		graphdef["table_tuples.#"] = mp.Graphs{
+			Label: (labelPrefix + " Table Tuples"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "n_live_tup", Label: "Live Tuples", Diff: false, Stacked: true},
+				{Name: "n_dead_tup", Label: "Dead Tuples", Diff: false, Stacked: true},
+			},
+		}
+		graphdef["table_dead_tuple_ratio.#"] = mp.Graphs{
+			Label: (labelPrefix + " Table Dead Tuple Ratio"),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "ratio", Label: "Dead Tuple Ratio", Diff: false, Stacked: false},
+			},
+		}
 	}
 
 	return graphdef
@@ -381,6 +594,7 @@ func Do() {
 	optSSLmode := flag.String("sslmode", "disable", "Whether or not to use SSL")
 	optConnectTimeout := flag.Int("connect_timeout", 5, "Maximum wait for connection, in seconds.")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optTopTables := flag.Int("top-tables", 0, "Post the tuples of the tables with the most dead tuples up to the number")
 	flag.Parse()
 
 	if *optUser == "" {
@@ -407,6 +621,7 @@ func Do() {
 	postgres.SSLmode = *optSSLmode
 	postgres.Timeout = *optConnectTimeout
 	postgres.Option = option
+	postgres.TopTables = *optTopTables
 
 	helper := mp.NewMackerelPlugin(postgres)
 
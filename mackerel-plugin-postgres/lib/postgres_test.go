@@ -42,3 +42,131 @@ func TestFetchStatDatabase(t *testing.T) {
 		t.Error("should be 77")
 	}
 }
+
+func TestFetchStatDatabasePerDatabase(t *testing.T) {
+	db, _ := sqlx.Connect("testdb", "")
+	defer db.Close()
+
+	columns := []string{"datname", "numbackends", "xact_commit", "xact_rollback", "blks_read", "blks_hit",
+		"tup_returned", "tup_fetched", "tup_inserted", "tup_updated", "tup_deleted", "deadlocks", "temp_bytes"}
+
+	testdb.StubQuery(`SELECT * FROM pg_stat_database`, testdb.RowsFromCSVString(columns, `
+	app.main,3,10,1,30,40,70,80,90,100,110,2,130
+	template1,0,5,0,1,1,1,1,1,1,1,0,0
+	`))
+
+	stat, err := fetchStatDatabase(db)
+	if err != nil {
+		t.Errorf("Expected no error, but got %s instead", err)
+	}
+
+	expected := map[string]interface{}{
+		"db_connections.app_main.numbackends": uint64(3),
+		"db_commits.app_main.xact_commit":     uint64(10),
+		"db_blocks.app_main.blks_hit":         uint64(40),
+		"db_rows.app_main.tup_deleted":        uint64(110),
+		"db_deadlocks.app_main.deadlocks":     uint64(2),
+		"xact_commit":                         uint64(15),
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("%s should be %v, but %v", k, v, stat[k])
+		}
+	}
+	if _, ok := stat["db_commits.template1.xact_commit"]; ok {
+		t.Error("template1 should not be posted")
+	}
+}
+
+func TestFetchReplication(t *testing.T) {
+	db, _ := sqlx.Connect("testdb", "")
+	defer db.Close()
+
+	columns := []string{"application_name", "client_addr", "write_bytes", "flush_bytes", "replay_bytes",
+		"write_seconds", "flush_seconds", "replay_seconds"}
+
+	v10 := version{10, 4, 0}
+	testdb.StubQuery(replicationQuery(v10), testdb.RowsFromCSVString(columns, `
+	walreceiver,10.0.0.2,0,128,4096,0.001,0.002,0.5
+	walreceiver,10.0.0.3,0,0,0,0,0,0
+	`))
+	stat, err := fetchReplication(db, v10)
+	if err != nil {
+		t.Errorf("Expected no error, but got %s instead", err)
+	}
+	expected := map[string]interface{}{
+		"replication_lag_bytes.walreceiver_10_0_0_2.flush":    128.0,
+		"replication_lag_bytes.walreceiver_10_0_0_2.replay":   4096.0,
+		"replication_lag_seconds.walreceiver_10_0_0_2.replay": 0.5,
+		"replication_lag_seconds.walreceiver_10_0_0_3.write":  0.0,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("%s should be %v, but %v", k, v, stat[k])
+		}
+	}
+}
+
+func TestFetchBgwriter(t *testing.T) {
+	db, _ := sqlx.Connect("testdb", "")
+	defer db.Close()
+
+	columns := []string{"checkpoints_timed", "checkpoints_req", "checkpoint_write_time", "checkpoint_sync_time",
+		"buffers_checkpoint", "buffers_clean", "buffers_backend"}
+
+	v16 := version{16, 2, 0}
+	testdb.StubQuery(bgwriterQuery(v16), testdb.RowsFromCSVString(columns, `
+	100,5,12345.5,67.25,2000,300,40
+	`))
+	stat, err := fetchBgwriter(db, v16)
+	if err != nil {
+		t.Errorf("Expected no error, but got %s instead", err)
+	}
+	if stat["checkpoints_req"] != 5.0 || stat["checkpoint_write_time"] != 12345.5 || stat["buffers_backend"] != 40.0 {
+		t.Errorf("the checkpoints are not fetched: %v", stat)
+	}
+}
+
+func TestFetchXIDAge(t *testing.T) {
+	db, _ := sqlx.Connect("testdb", "")
+	defer db.Close()
+
+	testdb.StubQuery(`select max(age(datfrozenxid)) from pg_database`, testdb.RowsFromCSVString([]string{"max"}, `
+	214748364
+	`))
+	stat, err := fetchXIDAge(db)
+	if err != nil {
+		t.Errorf("Expected no error, but got %s instead", err)
+	}
+	if stat["xid_remaining"] != 1932735283.0 {
+		t.Errorf("xid_remaining should be 1932735283, but %v", stat["xid_remaining"])
+	}
+	if p := stat["xid_wraparound_percentage"].(float64); p < 9.99 || p > 10.01 {
+		t.Errorf("xid_wraparound_percentage should be 10, but %v", p)
+	}
+}
+
+func TestFetchTableTuples(t *testing.T) {
+	db, _ := sqlx.Connect("testdb", "")
+	defer db.Close()
+
+	testdb.StubQuery(`select schemaname, relname, n_live_tup, n_dead_tup from pg_stat_user_tables order by n_dead_tup desc limit $1`,
+		testdb.RowsFromCSVString([]string{"schemaname", "relname", "n_live_tup", "n_dead_tup"}, `
+	public,users,300,100
+	public,empty,0,0
+	`))
+	stat, err := fetchTableTuples(db, 2)
+	if err != nil {
+		t.Errorf("Expected no error, but got %s instead", err)
+	}
+	expected := map[string]interface{}{
+		"table_tuples.public_users.n_dead_tup":      100.0,
+		"table_dead_tuple_ratio.public_users.ratio": 25.0,
+		"table_dead_tuple_ratio.public_empty.ratio": 0.0,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("%s should be %v, but %v", k, v, stat[k])
+		}
+	}
+}
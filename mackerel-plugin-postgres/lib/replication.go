@@ -0,0 +1,94 @@
+package mppostgres
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func fetchInRecovery(db *sqlx.DB) (bool, error) {
+	var inRecovery bool
+	if err := db.QueryRow("select pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		logger.Errorf("Failed to select pg_is_in_recovery(). %s", err)
+		return false, err
+	}
+	return inRecovery, nil
+}
+
+// replicationQuery returns the query of the lag of the standbys, where xlog is renamed to wal in PostgreSQL 10
+func replicationQuery(version version) string {
+	if version.atLeast(10, 0) {
+		return `select application_name, coalesce(host(client_addr), 'local') as client_addr,
+			pg_wal_lsn_diff(pg_current_wal_lsn(), write_lsn) as write_bytes,
+			pg_wal_lsn_diff(pg_current_wal_lsn(), flush_lsn) as flush_bytes,
+			pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn) as replay_bytes,
+			coalesce(extract(epoch from write_lag), 0) as write_seconds,
+			coalesce(extract(epoch from flush_lag), 0) as flush_seconds,
+			coalesce(extract(epoch from replay_lag), 0) as replay_seconds
+		from pg_stat_replication`
+	}
+	// the lag in seconds is not available before PostgreSQL 10
+	return `select application_name, coalesce(host(client_addr), 'local') as client_addr,
+			pg_xlog_location_diff(pg_current_xlog_location(), write_location) as write_bytes,
+			pg_xlog_location_diff(pg_current_xlog_location(), flush_location) as flush_bytes,
+			pg_xlog_location_diff(pg_current_xlog_location(), replay_location) as replay_bytes,
+			null as write_seconds, null as flush_seconds, null as replay_seconds
+		from pg_stat_replication`
+}
+
+// fetchReplication fetches the lag of each standby connected to the primary
+func fetchReplication(db *sqlx.DB, version version) (map[string]interface{}, error) {
+	if !version.atLeast(9, 2) {
+		return nil, errors.New("pg_xlog_location_diff is not available")
+	}
+	rows, err := db.Query(replicationQuery(version))
+	if err != nil {
+		logger.Warningf("Failed to select pg_stat_replication. %s", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	stat := make(map[string]interface{})
+	for rows.Next() {
+		var applicationName, clientAddr string
+		// the locations are null without the privilege of pg_read_all_stats
+		var lags [6]*float64
+		if err := rows.Scan(&applicationName, &clientAddr, &lags[0], &lags[1], &lags[2], &lags[3], &lags[4], &lags[5]); err != nil {
+			logger.Warningf("Failed to scan %s", err)
+			continue
+		}
+		// the application_name is "walreceiver" by default
+		name := normalizeMetricName(applicationName + "_" + clientAddr)
+		for i, n := range []string{"write", "flush", "replay"} {
+			if lags[i] != nil {
+				stat["replication_lag_bytes."+name+"."+n] = *lags[i]
+			}
+			if lags[i+3] != nil {
+				stat["replication_lag_seconds."+name+"."+n] = *lags[i+3]
+			}
+		}
+	}
+	return stat, nil
+}
+
+// walQuery returns the query of the position of WAL written in bytes
+func walQuery(version version) string {
+	if version.atLeast(10, 0) {
+		return `select pg_wal_lsn_diff(pg_current_wal_lsn(), '0/0')`
+	}
+	return `select pg_xlog_location_diff(pg_current_xlog_location(), '0/0')`
+}
+
+func fetchWAL(db *sqlx.DB, version version) (map[string]interface{}, error) {
+	if !version.atLeast(9, 2) {
+		return nil, errors.New("pg_xlog_location_diff is not available")
+	}
+	var walBytes float64
+	if err := db.QueryRow(walQuery(version)).Scan(&walBytes); err != nil {
+		logger.Warningf("Failed to select the location of WAL. %s", err)
+		return nil, err
+	}
+	return map[string]interface{}{
+		"wal_bytes": walBytes,
+	}, nil
+}
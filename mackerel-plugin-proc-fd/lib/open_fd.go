@@ -1,59 +1,228 @@
 package mpprocfd
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/mattn/go-pipeline"
 )
 
 // OpenFd interface
 type OpenFd interface {
-	getNumOpenFileDesc() (map[string]uint64, error)
+	getNumOpenFileDesc() (map[string]fdStat, error)
 }
 
 var openFd OpenFd
 
+// fdStat is the number of open file descriptors and its soft limit (0 means unlimited)
+type fdStat struct {
+	open  uint64
+	limit uint64
+}
+
+// ProcessMatcher selects processes. All of the given conditions must be satisfied.
+type ProcessMatcher struct {
+	Process     string
+	Comm        string
+	Cmdline     *regexp.Regexp
+	Pidfile     string
+	SystemdUnit string
+	Cgroup      string
+}
+
 // RealOpenFd struct
 type RealOpenFd struct {
-	process string
+	matcher  ProcessMatcher
+	procPath string
 }
 
-func (o RealOpenFd) getNumOpenFileDesc() (map[string]uint64, error) {
-	fds := make(map[string]uint64)
-
-	// Fetch all pids which contain specified process name
-	out, err := pipeline.Output(
-		[]string{"ps", "aux"},
-		[]string{"grep", o.process},
-		[]string{"grep", "-v", "grep"},
-		[]string{"grep", "-v", "mackerel-plugin-proc-fd"},
-		[]string{"awk", "{print $2}"},
-	)
+func (o RealOpenFd) getNumOpenFileDesc() (map[string]fdStat, error) {
+	pids, err := o.matchedPids()
 	if err != nil {
-		// No matching with p.Process invokes this case
 		return nil, err
 	}
 
-	// List the number of all open files beloging to each pid
-	for _, pid := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		out, err = pipeline.Output(
-			[]string{"ls", "-l", fmt.Sprintf("/proc/%s/fd", pid)},
-			[]string{"grep", "-v", "total"},
-			[]string{"wc", "-l"},
-		)
+	fds := make(map[string]fdStat)
+	for _, pid := range pids {
+		names, err := readDirNames(filepath.Join(o.procPath, pid, "fd"))
 		if err != nil {
-			// The process with pid terminates"
+			if os.IsNotExist(err) {
+				// The process with pid terminates
+				continue
+			}
 			return nil, err
 		}
-
-		num, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 32)
+		limit, err := o.readOpenFilesLimit(pid)
 		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
 			return nil, err
 		}
-		fds[pid] = num
+		fds[pid] = fdStat{open: uint64(len(names)), limit: limit}
+	}
+	if len(fds) == 0 {
+		return nil, fmt.Errorf("no process matched")
 	}
 
 	return fds, nil
 }
+
+func (o RealOpenFd) matchedPids() ([]string, error) {
+	var names []string
+	if o.matcher.Pidfile != "" {
+		pid, err := ioutil.ReadFile(o.matcher.Pidfile)
+		if err != nil {
+			return nil, err
+		}
+		names = []string{strings.TrimSpace(string(pid))}
+	} else {
+		var err error
+		names, err = readDirNames(o.procPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Exclude this plugin and the shell invoking it, whose command lines contain the process name
+	self := map[string]bool{
+		strconv.Itoa(os.Getpid()):  true,
+		strconv.Itoa(os.Getppid()): true,
+	}
+	var pids []string
+	for _, pid := range names {
+		if _, err := strconv.Atoi(pid); err != nil || self[pid] {
+			continue
+		}
+		ok, err := o.match(pid)
+		if err != nil {
+			// The process with pid terminates
+			continue
+		}
+		if ok {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func (o RealOpenFd) match(pid string) (bool, error) {
+	m := o.matcher
+	if m.Comm != "" {
+		comm, err := ioutil.ReadFile(filepath.Join(o.procPath, pid, "comm"))
+		if err != nil {
+			return false, err
+		}
+		if strings.TrimSpace(string(comm)) != m.Comm {
+			return false, nil
+		}
+	}
+	if m.Process != "" || m.Cmdline != nil {
+		out, err := ioutil.ReadFile(filepath.Join(o.procPath, pid, "cmdline"))
+		if err != nil {
+			return false, err
+		}
+		cmdline := strings.TrimSpace(strings.Replace(string(out), "\x00", " ", -1))
+		if m.Process != "" && !strings.Contains(cmdline, m.Process) {
+			return false, nil
+		}
+		if m.Cmdline != nil && !m.Cmdline.MatchString(cmdline) {
+			return false, nil
+		}
+	}
+	if m.SystemdUnit != "" || m.Cgroup != "" {
+		paths, err := o.readCgroupPaths(pid)
+		if err != nil {
+			return false, err
+		}
+		if m.SystemdUnit != "" && !inSystemdUnit(paths, m.SystemdUnit) {
+			return false, nil
+		}
+		if m.Cgroup != "" && !inCgroup(paths, m.Cgroup) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// # cat /proc/<pid>/cgroup
+// 11:memory:/system.slice/keepalived.service
+// 1:name=systemd:/system.slice/keepalived.service
+// 0::/system.slice/keepalived.service
+func (o RealOpenFd) readCgroupPaths(pid string) ([]string, error) {
+	out, err := ioutil.ReadFile(filepath.Join(o.procPath, pid, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		paths = append(paths, fields[2])
+	}
+	return paths, nil
+}
+
+func inSystemdUnit(paths []string, unit string) bool {
+	if !strings.Contains(unit, ".") {
+		unit += ".service"
+	}
+	for _, path := range paths {
+		for _, elem := range strings.Split(path, "/") {
+			if elem == unit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inCgroup(paths []string, cgroup string) bool {
+	cgroup = strings.TrimRight(cgroup, "/")
+	for _, path := range paths {
+		if path == cgroup || strings.HasPrefix(path, cgroup+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// # cat /proc/<pid>/limits
+// Limit                     Soft Limit           Hard Limit           Units
+// Max open files            1024                 4096                 files
+func (o RealOpenFd) readOpenFilesLimit(pid string) (uint64, error) {
+	f, err := os.Open(filepath.Join(o.procPath, pid, "limits"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) < 1 || fields[0] == "unlimited" {
+			return 0, nil
+		}
+		return strconv.ParseUint(fields[0], 10, 64)
+	}
+	return 0, scanner.Err()
+}
+
+func readDirNames(path string) ([]string, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
@@ -4,7 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/mackerelio/golib/logging"
@@ -28,14 +30,21 @@ func (p ProcfdPlugin) FetchMetrics() (map[string]interface{}, error) {
 
 	stat := make(map[string]interface{})
 
-	// Compute maximum open file descriptor
+	// Compute maximum open file descriptor and its utilization of RLIMIT_NOFILE
 	var maxFD uint64
+	var maxUsage float64
 	for _, fd := range fds {
-		if fd > maxFD {
-			maxFD = fd
+		if fd.open > maxFD {
+			maxFD = fd.open
+		}
+		if fd.limit > 0 {
+			if usage := float64(fd.open) / float64(fd.limit) * 100; usage > maxUsage {
+				maxUsage = usage
+			}
 		}
 	}
 	stat["max_fd"] = maxFD
+	stat["max_fd_usage"] = maxUsage
 
 	return stat, nil
 }
@@ -50,6 +59,13 @@ func (p ProcfdPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "max_fd", Label: "Maximum opening fd", Diff: false, Type: "uint64"},
 			},
 		},
+		fmt.Sprintf("proc-fd.%s.usage", p.NormalizedProcess): {
+			Label: fmt.Sprintf("Opening fd usage by %s", p.NormalizedProcess),
+			Unit:  "percentage",
+			Metrics: []mp.Metrics{
+				{Name: "max_fd_usage", Label: "Maximum usage of open files limit", Diff: false},
+			},
+		},
 	}
 }
 
@@ -62,20 +78,55 @@ func normalizeForMetricName(process string) string {
 
 // Do the plugin
 func Do() {
-	optProcess := flag.String("process", "", "Process name")
+	optProcess := flag.String("process", "", "Process name (a part of the command line)")
+	optComm := flag.String("comm", "", "Exact command name (/proc/<pid>/comm)")
+	optCmdline := flag.String("cmdline-regexp", "", "Regexp of the command line")
+	optPidfile := flag.String("pidfile", "", "pidfile path")
+	optSystemdUnit := flag.String("systemd-unit", "", "systemd unit name which the processes belong to")
+	optCgroup := flag.String("cgroup", "", "cgroup path which the processes belong to")
+	optName := flag.String("name", "", "Name in metric keys (default: the value of the selecting option)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
 	flag.Parse()
 
-	if *optProcess == "" {
-		logger.Warningf("Process name is required")
+	matcher := ProcessMatcher{
+		Process:     *optProcess,
+		Comm:        *optComm,
+		Pidfile:     *optPidfile,
+		SystemdUnit: *optSystemdUnit,
+		Cgroup:      *optCgroup,
+	}
+	if *optCmdline != "" {
+		re, err := regexp.Compile(*optCmdline)
+		if err != nil {
+			logger.Warningf("Failed to compile cmdline-regexp: %s", err)
+			os.Exit(1)
+		}
+		matcher.Cmdline = re
+	}
+
+	var name string
+	for _, v := range []string{*optProcess, *optComm, *optCmdline, *optSystemdUnit, *optCgroup} {
+		if name == "" {
+			name = v
+		}
+	}
+	if name == "" && *optPidfile != "" {
+		name = strings.TrimSuffix(filepath.Base(*optPidfile), filepath.Ext(*optPidfile))
+	}
+	if name == "" {
+		logger.Warningf("One of process, comm, cmdline-regexp, pidfile, systemd-unit or cgroup is required")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
+	if *optName != "" {
+		name = *optName
+	}
 
 	var fd ProcfdPlugin
 	fd.Process = *optProcess
-	openFd = RealOpenFd{fd.Process}
-	fd.NormalizedProcess = normalizeForMetricName(*optProcess)
+	fd.MetricName = name
+	openFd = RealOpenFd{matcher: matcher, procPath: "/proc"}
+	fd.NormalizedProcess = normalizeForMetricName(name)
 
 	helper := mp.NewMackerelPlugin(fd)
 	helper.Tempfile = *optTempfile
@@ -1,23 +1,31 @@
 package mpprocfd
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+)
 
 func TestGraphDefinition(t *testing.T) {
 	var fd ProcfdPlugin
 
 	graph := fd.GraphDefinition()
-	if actual := len(graph); actual != 1 {
-		t.Errorf("GraphDefinition(): %d should be 1", actual)
+	if actual := len(graph); actual != 2 {
+		t.Errorf("GraphDefinition(): %d should be 2", actual)
 	}
 }
 
 type TestOpenFd struct{}
 
-func (o TestOpenFd) getNumOpenFileDesc() (map[string]uint64, error) {
-	return map[string]uint64{
-		"8273": 90,
-		"8274": 100,
-		"8275": 95,
+func (o TestOpenFd) getNumOpenFileDesc() (map[string]fdStat, error) {
+	return map[string]fdStat{
+		"8273": {open: 90, limit: 100},
+		"8274": {open: 100, limit: 1000},
+		"8275": {open: 95, limit: 0},
 	}, nil
 }
 
@@ -29,4 +37,114 @@ func TestFetchMetrics(t *testing.T) {
 	if actual := stat["max_fd"].(uint64); actual != 100 {
 		t.Errorf("FetchMetrics(): max_fd(%d) should be 100", actual)
 	}
+	if actual := stat["max_fd_usage"].(float64); actual != 90 {
+		t.Errorf("FetchMetrics(): max_fd_usage(%f) should be 90", actual)
+	}
+}
+
+type testProcess struct {
+	comm    string
+	cmdline string
+	cgroup  string
+	fds     int
+	limit   string
+}
+
+func createTestProc(t *testing.T, procs map[int]testProcess) string {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-proc-fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pid, p := range procs {
+		pdir := filepath.Join(dir, strconv.Itoa(pid))
+		if err := os.MkdirAll(filepath.Join(pdir, "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < p.fds; i++ {
+			ioutil.WriteFile(filepath.Join(pdir, "fd", strconv.Itoa(i)), nil, 0644)
+		}
+		ioutil.WriteFile(filepath.Join(pdir, "comm"), []byte(p.comm+"\n"), 0644)
+		ioutil.WriteFile(filepath.Join(pdir, "cmdline"), []byte(p.cmdline), 0644)
+		ioutil.WriteFile(filepath.Join(pdir, "cgroup"), []byte(p.cgroup), 0644)
+		ioutil.WriteFile(filepath.Join(pdir, "limits"), []byte(`Limit                     Soft Limit           Hard Limit           Units
+Max cpu time              unlimited            unlimited            seconds
+Max open files            `+p.limit+`                 4096                 files
+`), 0644)
+	}
+	// Not a process
+	ioutil.WriteFile(filepath.Join(dir, "uptime"), []byte("1.00 2.00\n"), 0644)
+	return dir
+}
+
+func TestRealOpenFd(t *testing.T) {
+	dir := createTestProc(t, map[int]testProcess{
+		100: {
+			comm:    "keepalived",
+			cmdline: "/usr/sbin/keepalived\x00-D\x00",
+			cgroup:  "1:name=systemd:/system.slice/keepalived.service\n0::/system.slice/keepalived.service\n",
+			fds:     10,
+			limit:   "1024",
+		},
+		101: {
+			comm:    "nginx",
+			cmdline: "nginx: worker process\x00",
+			cgroup:  "0::/system.slice/nginx.service\n",
+			fds:     20,
+			limit:   "unlimited",
+		},
+		102: {
+			comm:    "vim",
+			cmdline: "vim\x00keepalived.conf\x00",
+			cgroup:  "0::/user.slice/user-1000.slice/session-1.scope\n",
+			fds:     3,
+			limit:   "1024",
+		},
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		matcher  ProcessMatcher
+		expected []string
+	}{
+		{ProcessMatcher{Process: "keepalived"}, []string{"100", "102"}},
+		{ProcessMatcher{Comm: "keepalived"}, []string{"100"}},
+		{ProcessMatcher{Cmdline: regexp.MustCompile(`^nginx: `)}, []string{"101"}},
+		{ProcessMatcher{SystemdUnit: "keepalived"}, []string{"100"}},
+		{ProcessMatcher{Cgroup: "/system.slice"}, []string{"100", "101"}},
+		{ProcessMatcher{Cgroup: "/user.slice", Process: "keepalived"}, []string{"102"}},
+	}
+	for _, c := range cases {
+		o := RealOpenFd{matcher: c.matcher, procPath: dir}
+		pids, err := o.matchedPids()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(pids)
+		if len(pids) != len(c.expected) {
+			t.Errorf("matchedPids(%+v): %v should be %v", c.matcher, pids, c.expected)
+			continue
+		}
+		for i := range pids {
+			if pids[i] != c.expected[i] {
+				t.Errorf("matchedPids(%+v): %v should be %v", c.matcher, pids, c.expected)
+			}
+		}
+	}
+
+	o := RealOpenFd{matcher: ProcessMatcher{Cgroup: "/system.slice"}, procPath: dir}
+	fds, err := o.getNumOpenFileDesc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fds["100"].open != 10 || fds["100"].limit != 1024 {
+		t.Errorf("getNumOpenFileDesc(): unexpected %+v for 100", fds["100"])
+	}
+	if fds["101"].open != 20 || fds["101"].limit != 0 {
+		t.Errorf("getNumOpenFileDesc(): unexpected %+v for 101", fds["101"])
+	}
+
+	o = RealOpenFd{matcher: ProcessMatcher{Comm: "httpd"}, procPath: dir}
+	if _, err := o.getNumOpenFileDesc(); err == nil {
+		t.Errorf("getNumOpenFileDesc() should fail when no process matched")
+	}
 }
@@ -0,0 +1,91 @@
+package mprabbitmq
+
+import (
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/michaelklishin/rabbit-hole"
+)
+
+var nodeGraphdef = map[string]mp.Graphs{
+	"rabbitmq.node.health.#": {
+		Label: "RabbitMQ Node Health",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "running", Label: "Running", Diff: false},
+			{Name: "mem_alarm", Label: "Memory Alarm", Diff: false},
+			{Name: "disk_free_alarm", Label: "Disk Free Alarm", Diff: false},
+			{Name: "partitions", Label: "Partitions", Diff: false},
+		},
+	},
+	"rabbitmq.node.fd.#": {
+		Label: "RabbitMQ Node File Descriptors",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "fd_used", Label: "Used", Diff: false},
+			{Name: "fd_total", Label: "Total", Diff: false},
+		},
+	},
+	"rabbitmq.node.sockets.#": {
+		Label: "RabbitMQ Node Sockets",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "sockets_used", Label: "Used", Diff: false},
+			{Name: "sockets_total", Label: "Total", Diff: false},
+		},
+	},
+	"rabbitmq.node.memory.#": {
+		Label: "RabbitMQ Node Memory",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "mem_used", Label: "Used", Diff: false},
+			{Name: "mem_limit", Label: "Limit", Diff: false},
+		},
+	},
+	"rabbitmq.node.disk.#": {
+		Label: "RabbitMQ Node Disk Free",
+		Unit:  "bytes",
+		Metrics: []mp.Metrics{
+			{Name: "disk_free", Label: "Free", Diff: false},
+			{Name: "disk_free_limit", Label: "Limit", Diff: false},
+		},
+	},
+	"rabbitmq.node.run_queue.#": {
+		Label: "RabbitMQ Node Run Queue",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "run_queue", Label: "Run Queue", Diff: false},
+		},
+	},
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (r RabbitMQPlugin) parseNodes(nodes []rabbithole.NodeInfo) map[string]interface{} {
+	stat := make(map[string]interface{})
+	for _, n := range nodes {
+		// e.g. "rabbit@host01" is "rabbit_host01"
+		name := metricNameRe.ReplaceAllString(n.Name, "_")
+		stat["rabbitmq.node.health."+name+".running"] = boolToFloat(n.IsRunning)
+		stat["rabbitmq.node.health."+name+".mem_alarm"] = boolToFloat(n.MemAlarm)
+		stat["rabbitmq.node.health."+name+".disk_free_alarm"] = boolToFloat(n.DiskFreeAlarm)
+		stat["rabbitmq.node.health."+name+".partitions"] = float64(len(n.Partitions))
+		if !n.IsRunning {
+			// the stats of the stopped node are not reported
+			continue
+		}
+		stat["rabbitmq.node.fd."+name+".fd_used"] = float64(n.FdUsed)
+		stat["rabbitmq.node.fd."+name+".fd_total"] = float64(n.FdTotal)
+		stat["rabbitmq.node.sockets."+name+".sockets_used"] = float64(n.SocketsUsed)
+		stat["rabbitmq.node.sockets."+name+".sockets_total"] = float64(n.SocketsTotal)
+		stat["rabbitmq.node.memory."+name+".mem_used"] = float64(n.MemUsed)
+		stat["rabbitmq.node.memory."+name+".mem_limit"] = float64(n.MemLimit)
+		stat["rabbitmq.node.disk."+name+".disk_free"] = float64(n.DiskFree)
+		stat["rabbitmq.node.disk."+name+".disk_free_limit"] = float64(n.DiskFreeLimit)
+		stat["rabbitmq.node.run_queue."+name+".run_queue"] = float64(n.RunQueueLength)
+	}
+	return stat
+}
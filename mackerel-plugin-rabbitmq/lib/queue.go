@@ -0,0 +1,117 @@
+package mprabbitmq
+
+import (
+	"regexp"
+
+	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/michaelklishin/rabbit-hole"
+)
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// the graphs of the queues, which are posted per queue as "rabbitmq.queue.*.#" and per vhost as "rabbitmq.vhost.*.#"
+var queueGraphdef = map[string]mp.Graphs{
+	"messages": {
+		Label: "Messages",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "messages", Label: "Total", Diff: false},
+			{Name: "ready", Label: "Ready", Diff: false},
+			{Name: "unacknowledged", Label: "Unacknowledged", Diff: false},
+		},
+	},
+	"consumers": {
+		Label: "Consumers",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "consumers", Label: "Consumers", Diff: false},
+		},
+	},
+	"rate": {
+		Label: "Message Rate",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "publish", Label: "Publish", Diff: false},
+			{Name: "deliver_get", Label: "Deliver / Get", Diff: false},
+			{Name: "ack", Label: "Ack", Diff: false},
+			{Name: "redeliver", Label: "Redeliver", Diff: false},
+		},
+	},
+}
+
+// vhostName is the name of the vhost in the metric names, where the default vhost "/" is "default"
+func vhostName(vhost string) string {
+	if vhost == "/" {
+		return "default"
+	}
+	return metricNameRe.ReplaceAllString(vhost, "_")
+}
+
+// queueName is the name of the queue in the metric names like "<vhost>-<queue>"
+func queueName(q rabbithole.QueueInfo) string {
+	return vhostName(q.Vhost) + "-" + metricNameRe.ReplaceAllString(q.Name, "_")
+}
+
+// queueStats returns the metrics of the queue keyed by the names in queueGraphdef
+func queueStats(q rabbithole.QueueInfo) map[string]float64 {
+	return map[string]float64{
+		"messages":       float64(q.Messages),
+		"ready":          float64(q.MessagesReady),
+		"unacknowledged": float64(q.MessagesUnacknowledged),
+		"consumers":      float64(q.Consumers),
+		"publish":        float64(q.MessageStats.PublishDetails.Rate),
+		"deliver_get":    float64(q.MessageStats.DeliverGetDetails.Rate),
+		"ack":            float64(q.MessageStats.AckDetails.Rate),
+		"redeliver":      float64(q.MessageStats.RedeliverDetails.Rate),
+	}
+}
+
+// match returns true if the name of the queue matches to the include and does not match to the exclude
+func (r RabbitMQPlugin) match(q rabbithole.QueueInfo) bool {
+	if r.QueueInclude != nil && !r.QueueInclude.MatchString(q.Name) {
+		return false
+	}
+	if r.QueueExclude != nil && r.QueueExclude.MatchString(q.Name) {
+		return false
+	}
+	return true
+}
+
+func (r RabbitMQPlugin) parseQueues(queues []rabbithole.QueueInfo) map[string]interface{} {
+	graphKeys := make(map[string]string)
+	for key, g := range queueGraphdef {
+		for _, m := range g.Metrics {
+			graphKeys[m.Name] = key
+		}
+	}
+
+	stat := make(map[string]interface{})
+	vhosts := make(map[string]map[string]float64)
+	for _, q := range queues {
+		if !r.match(q) {
+			continue
+		}
+		qs := queueStats(q)
+		if r.PerQueue {
+			name := queueName(q)
+			for k, v := range qs {
+				stat["rabbitmq.queue."+graphKeys[k]+"."+name+"."+k] = v
+			}
+		}
+		if r.PerVhost {
+			vhost := vhostName(q.Vhost)
+			if vhosts[vhost] == nil {
+				vhosts[vhost] = make(map[string]float64)
+			}
+			for k, v := range qs {
+				vhosts[vhost][k] += v
+			}
+		}
+	}
+	for vhost, vs := range vhosts {
+		for k, v := range vs {
+			stat["rabbitmq.vhost."+graphKeys[k]+"."+vhost+"."+k] = v
+		}
+	}
+	return stat
+}
@@ -2,6 +2,9 @@ package mprabbitmq
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"regexp"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/michaelklishin/rabbit-hole"
@@ -24,6 +27,17 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "publish", Label: "Publish", Diff: false},
 		},
 	},
+	"rabbitmq.objects": {
+		Label: "RabbitMQ Objects",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "connections", Label: "Connections", Diff: false},
+			{Name: "channels", Label: "Channels", Diff: false},
+			{Name: "consumers", Label: "Consumers", Diff: false},
+			{Name: "queues", Label: "Queues", Diff: false},
+			{Name: "exchanges", Label: "Exchanges", Diff: false},
+		},
+	},
 }
 
 // RabbitMQPlugin metrics
@@ -32,6 +46,12 @@ type RabbitMQPlugin struct {
 	User     string
 	Password string
 	TempFile string
+
+	PerQueue     bool
+	PerVhost     bool
+	Node         bool
+	QueueInclude *regexp.Regexp
+	QueueExclude *regexp.Regexp
 }
 
 // FetchMetrics interface for mackerelplugin
@@ -45,7 +65,30 @@ func (r RabbitMQPlugin) FetchMetrics() (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	return r.parseStats(*res)
+	stat, err := r.parseStats(*res)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.PerQueue || r.PerVhost {
+		queues, err := rmqc.ListQueues()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range r.parseQueues(queues) {
+			stat[k] = v
+		}
+	}
+	if r.Node {
+		nodes, err := rmqc.ListNodes()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range r.parseNodes(nodes) {
+			stat[k] = v
+		}
+	}
+	return stat, nil
 }
 
 func (r RabbitMQPlugin) parseStats(res rabbithole.Overview) (map[string]interface{}, error) {
@@ -55,6 +98,11 @@ func (r RabbitMQPlugin) parseStats(res rabbithole.Overview) (map[string]interfac
 	stat["ready"] = float64(res.QueueTotals.MessagesReady)
 	stat["unacknowledged"] = float64(res.QueueTotals.MessagesUnacknowledged)
 	stat["publish"] = float64(res.MessageStats.PublishDetails.Rate)
+	stat["connections"] = float64(res.ObjectTotals.Connections)
+	stat["channels"] = float64(res.ObjectTotals.Channels)
+	stat["consumers"] = float64(res.ObjectTotals.Consumers)
+	stat["queues"] = float64(res.ObjectTotals.Queues)
+	stat["exchanges"] = float64(res.ObjectTotals.Exchanges)
 
 	return stat, nil
 
@@ -62,7 +110,26 @@ func (r RabbitMQPlugin) parseStats(res rabbithole.Overview) (map[string]interfac
 
 // GraphDefinition interface for mackerel plugin
 func (r RabbitMQPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	graphs := make(map[string]mp.Graphs)
+	for key, g := range graphdef {
+		graphs[key] = g
+	}
+	for key, g := range queueGraphdef {
+		if r.PerQueue {
+			g.Label = "RabbitMQ Queue " + queueGraphdef[key].Label
+			graphs["rabbitmq.queue."+key+".#"] = g
+		}
+		if r.PerVhost {
+			g.Label = "RabbitMQ Vhost " + queueGraphdef[key].Label
+			graphs["rabbitmq.vhost."+key+".#"] = g
+		}
+	}
+	if r.Node {
+		for key, g := range nodeGraphdef {
+			graphs[key] = g
+		}
+	}
+	return graphs
 }
 
 // Do the plugin
@@ -70,6 +137,11 @@ func Do() {
 	optURI := flag.String("uri", "http://localhost:15672", "URI")
 	optUser := flag.String("user", "guest", "User")
 	optPass := flag.String("password", "guest", "Password")
+	optPerQueue := flag.Bool("per-queue", false, "Post the metrics of each queue")
+	optPerVhost := flag.Bool("per-vhost", false, "Post the metrics of the queues grouped by vhost")
+	optQueueInclude := flag.String("queue-include", "", "Regexp of the queue names to be included")
+	optQueueExclude := flag.String("queue-exclude", "", "Regexp of the queue names to be excluded")
+	optNode := flag.Bool("node", false, "Post the health metrics of each node")
 	flag.Parse()
 
 	var rabbitmq RabbitMQPlugin
@@ -77,6 +149,25 @@ func Do() {
 	rabbitmq.URI = *optURI
 	rabbitmq.User = *optUser
 	rabbitmq.Password = *optPass
+	rabbitmq.PerQueue = *optPerQueue
+	rabbitmq.PerVhost = *optPerVhost
+	rabbitmq.Node = *optNode
+	if *optQueueInclude != "" {
+		re, err := regexp.Compile(*optQueueInclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-rabbitmq: invalid queue-include: %s\n", err)
+			os.Exit(1)
+		}
+		rabbitmq.QueueInclude = re
+	}
+	if *optQueueExclude != "" {
+		re, err := regexp.Compile(*optQueueExclude)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-rabbitmq: invalid queue-exclude: %s\n", err)
+			os.Exit(1)
+		}
+		rabbitmq.QueueExclude = re
+	}
 
 	helper := mp.NewMackerelPlugin(rabbitmq)
 
@@ -2,6 +2,7 @@ package mprabbitmq
 
 import (
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/michaelklishin/rabbit-hole"
@@ -12,8 +13,18 @@ func TestGraphDefinition(t *testing.T) {
 	var rabbitmq RabbitMQPlugin
 
 	graphdef := rabbitmq.GraphDefinition()
-	if len(graphdef) != 2 {
-		t.Errorf("GetTempfilename: %d should be 2", len(graphdef))
+	if len(graphdef) != 3 {
+		t.Errorf("GetTempfilename: %d should be 3", len(graphdef))
+	}
+
+	rabbitmq.PerQueue = true
+	rabbitmq.PerVhost = true
+	rabbitmq.Node = true
+	graphdef = rabbitmq.GraphDefinition()
+	for _, key := range []string{"rabbitmq.queue.messages.#", "rabbitmq.vhost.rate.#", "rabbitmq.node.memory.#"} {
+		if _, ok := graphdef[key]; !ok {
+			t.Errorf("graph %s should be defined", key)
+		}
 	}
 }
 
@@ -25,6 +36,8 @@ func TestParse(t *testing.T) {
 	stub.QueueTotals.MessagesReady = 2
 	stub.QueueTotals.MessagesUnacknowledged = 3
 	stub.MessageStats.PublishDetails.Rate = 4
+	stub.ObjectTotals.Connections = 5
+	stub.ObjectTotals.Channels = 6
 
 	stat, err := rabbitmq.parseStats(stub)
 
@@ -33,4 +46,72 @@ func TestParse(t *testing.T) {
 	assert.EqualValues(t, stat["messages"], 1)
 	assert.EqualValues(t, reflect.TypeOf(stat["publish"]).String(), "float64")
 	assert.EqualValues(t, stat["publish"], 4)
+	assert.EqualValues(t, stat["connections"], 5)
+	assert.EqualValues(t, stat["channels"], 6)
+}
+
+func TestParseQueues(t *testing.T) {
+	rabbitmq := RabbitMQPlugin{
+		PerQueue:     true,
+		PerVhost:     true,
+		QueueExclude: regexp.MustCompile(`^amq\.gen-`),
+	}
+
+	stub := []rabbithole.QueueInfo{
+		{Name: "jobs", Vhost: "/", Messages: 3, MessagesReady: 2, MessagesUnacknowledged: 1, Consumers: 2},
+		{Name: "mails.high", Vhost: "/", Messages: 5, Consumers: 1},
+		{Name: "amq.gen-abc", Vhost: "/", Messages: 100},
+		{Name: "jobs", Vhost: "app", Messages: 7},
+	}
+	stub[0].MessageStats.AckDetails.Rate = 1.5
+
+	stat := rabbitmq.parseQueues(stub)
+
+	assert.EqualValues(t, 3, stat["rabbitmq.queue.messages.default-jobs.messages"])
+	assert.EqualValues(t, 1, stat["rabbitmq.queue.messages.default-jobs.unacknowledged"])
+	assert.EqualValues(t, 2, stat["rabbitmq.queue.consumers.default-jobs.consumers"])
+	assert.EqualValues(t, 1.5, stat["rabbitmq.queue.rate.default-jobs.ack"])
+	assert.EqualValues(t, 5, stat["rabbitmq.queue.messages.default-mails_high.messages"])
+	assert.EqualValues(t, 7, stat["rabbitmq.queue.messages.app-jobs.messages"])
+	assert.Nil(t, stat["rabbitmq.queue.messages.default-amq_gen-abc.messages"])
+
+	assert.EqualValues(t, 8, stat["rabbitmq.vhost.messages.default.messages"])
+	assert.EqualValues(t, 3, stat["rabbitmq.vhost.consumers.default.consumers"])
+	assert.EqualValues(t, 7, stat["rabbitmq.vhost.messages.app.messages"])
+
+	rabbitmq.PerVhost = false
+	rabbitmq.QueueInclude = regexp.MustCompile(`^mails\.`)
+	stat = rabbitmq.parseQueues(stub)
+	assert.Len(t, stat, 8)
+	assert.EqualValues(t, 5, stat["rabbitmq.queue.messages.default-mails_high.messages"])
+}
+
+func TestParseNodes(t *testing.T) {
+	var rabbitmq RabbitMQPlugin
+
+	stub := []rabbithole.NodeInfo{
+		{
+			Name:           "rabbit@host01",
+			IsRunning:      true,
+			FdUsed:         30,
+			FdTotal:        1024,
+			MemUsed:        100000,
+			MemLimit:       400000,
+			DiskFree:       5000000,
+			DiskFreeLimit:  50000,
+			RunQueueLength: 2,
+			Partitions:     []string{"rabbit@host02"},
+		},
+		{Name: "rabbit@host02"},
+	}
+
+	stat := rabbitmq.parseNodes(stub)
+
+	assert.EqualValues(t, 1, stat["rabbitmq.node.health.rabbit_host01.running"])
+	assert.EqualValues(t, 1, stat["rabbitmq.node.health.rabbit_host01.partitions"])
+	assert.EqualValues(t, 30, stat["rabbitmq.node.fd.rabbit_host01.fd_used"])
+	assert.EqualValues(t, 400000, stat["rabbitmq.node.memory.rabbit_host01.mem_limit"])
+	assert.EqualValues(t, 2, stat["rabbitmq.node.run_queue.rabbit_host01.run_queue"])
+	assert.EqualValues(t, 0, stat["rabbitmq.node.health.rabbit_host02.running"])
+	assert.Nil(t, stat["rabbitmq.node.fd.rabbit_host02.fd_used"])
 }
@@ -0,0 +1,131 @@
+package mpredis
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// parseInfo parses the output of INFO into the fields
+func parseInfo(str string) map[string]string {
+	info := make(map[string]string)
+	for _, line := range strings.Split(str, "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		record := strings.SplitN(line, ":", 2)
+		if len(record) < 2 {
+			continue
+		}
+		info[record[0]] = record[1]
+	}
+	return info
+}
+
+// parseValues parses the values like "keys=1,expires=0,avg_ttl=0" of INFO
+func parseValues(value string) map[string]string {
+	values := make(map[string]string)
+	for _, kv := range strings.Split(value, ",") {
+		record := strings.SplitN(kv, "=", 2)
+		if len(record) < 2 {
+			continue
+		}
+		values[record[0]] = record[1]
+	}
+	return values
+}
+
+func parseKeyspace(db string, value string, stat map[string]interface{}) {
+	values := parseValues(value)
+	for _, name := range []string{"keys", "expires"} {
+		v, err := strconv.ParseFloat(values[name], 64)
+		if err != nil {
+			continue
+		}
+		stat["db."+db+"."+name] = v
+	}
+}
+
+var replicaKeyRe = regexp.MustCompile(`^slave\d+$`)
+
+// parseReplication calculates the lag in bytes of each replica connected to the master
+func parseReplication(info map[string]string, stat map[string]interface{}) {
+	masterOffset, err := strconv.ParseFloat(info["master_repl_offset"], 64)
+	if err != nil {
+		return
+	}
+	for key, value := range info {
+		if !replicaKeyRe.MatchString(key) {
+			continue
+		}
+		// e.g. "ip=10.0.0.2,port=6379,state=online,offset=1234,lag=0"
+		values := parseValues(value)
+		offset, err := strconv.ParseFloat(values["offset"], 64)
+		if err != nil {
+			continue
+		}
+		name := metricNameRe.ReplaceAllString(values["ip"]+"_"+values["port"], "_")
+		stat["replication_lag."+name+".bytes"] = masterOffset - offset
+	}
+}
+
+var sentinelMasterKeyRe = regexp.MustCompile(`^master\d+$`)
+
+// parseSentinelMasters parses the masters monitored by the sentinel in INFO sentinel
+func parseSentinelMasters(info map[string]string, stat map[string]interface{}) {
+	for key, value := range info {
+		if !sentinelMasterKeyRe.MatchString(key) {
+			continue
+		}
+		// e.g. "name=mymaster,status=ok,address=10.0.0.1:6379,slaves=2,sentinels=3"
+		values := parseValues(value)
+		name := metricNameRe.ReplaceAllString(values["name"], "_")
+		if values["status"] == "ok" {
+			stat["sentinel_master."+name+".status"] = 1.0
+		} else {
+			stat["sentinel_master."+name+".status"] = 0.0
+		}
+		for _, n := range []string{"slaves", "sentinels"} {
+			if v, err := strconv.ParseFloat(values[n], 64); err == nil {
+				stat["sentinel_master."+name+"."+n] = v
+			}
+		}
+	}
+}
+
+// parseClusterInfo parses the output of CLUSTER INFO
+func parseClusterInfo(str string, stat map[string]interface{}) {
+	for key, value := range parseInfo(str) {
+		switch key {
+		case "cluster_state":
+			if value == "ok" {
+				stat[key] = 1.0
+			} else {
+				stat[key] = 0.0
+			}
+		case "cluster_slots_assigned", "cluster_slots_ok", "cluster_slots_pfail", "cluster_slots_fail", "cluster_known_nodes", "cluster_size":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				stat[key] = v
+			}
+		}
+	}
+}
+
+// parseCommandStats parses the output of INFO commandstats like "cmdstat_get:calls=2,usec=15,usec_per_call=7.50"
+func parseCommandStats(str string, stat map[string]interface{}) {
+	for key, value := range parseInfo(str) {
+		if !strings.HasPrefix(key, "cmdstat_") {
+			continue
+		}
+		name := metricNameRe.ReplaceAllString(strings.TrimPrefix(key, "cmdstat_"), "_")
+		values := parseValues(value)
+		if v, err := strconv.ParseFloat(values["calls"], 64); err == nil {
+			stat["command_calls."+name+".calls"] = v
+		}
+		if v, err := strconv.ParseFloat(values["usec_per_call"], 64); err == nil {
+			stat["command_usec_per_call."+name+".usec_per_call"] = v
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package mpredis
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,6 +13,7 @@ import (
 	"github.com/fzzy/radix/redis"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
 	"github.com/mackerelio/golib/logging"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/redisopt"
 )
 
 var logger = logging.GetLogger("metrics.plugin.redis")
@@ -19,15 +22,25 @@ var logger = logging.GetLogger("metrics.plugin.redis")
 type RedisPlugin struct {
 	Host     string
 	Port     string
+	Username string
 	Password string
 	Socket   string
 	Prefix   string
 	Timeout  int
 	Tempfile string
+
+	CommandStats bool
+	// connecting over TLS if not nil
+	TLSConfig *tls.Config
 }
 
-func authenticateByPassword(c *redis.Client, password string) error {
-	if r := c.Cmd("AUTH", password); r.Err != nil {
+func authenticateByPassword(c *redis.Client, username, password string) error {
+	args := []interface{}{password}
+	if username != "" {
+		// the ACL user of Redis 6
+		args = []interface{}{username, password}
+	}
+	if r := c.Cmd("AUTH", args...); r.Err != nil {
 		logger.Errorf("Faild to authenticate. %s", r.Err)
 		return r.Err
 	}
@@ -96,6 +109,62 @@ func calculateCapacity(c *redis.Client, stat map[string]interface{}) error {
 	return nil
 }
 
+func fetchClusterInfo(c *redis.Client, stat map[string]interface{}) error {
+	r := c.Cmd("CLUSTER", "INFO")
+	if r.Err != nil {
+		return r.Err
+	}
+	str, err := r.Str()
+	if err != nil {
+		return err
+	}
+	parseClusterInfo(str, stat)
+	return nil
+}
+
+func fetchCommandStats(c *redis.Client, stat map[string]interface{}) error {
+	r := c.Cmd("INFO", "commandstats")
+	if r.Err != nil {
+		return r.Err
+	}
+	str, err := r.Str()
+	if err != nil {
+		return err
+	}
+	parseCommandStats(str, stat)
+	return nil
+}
+
+// fetchLatency fetches the latest and the max latency in milliseconds of the events recorded by the latency monitor
+func fetchLatency(c *redis.Client, stat map[string]interface{}) error {
+	r := c.Cmd("LATENCY", "LATEST")
+	if r.Err != nil {
+		return r.Err
+	}
+	for _, e := range r.Elems {
+		// event name, timestamp, latest and max latency
+		if len(e.Elems) < 4 {
+			continue
+		}
+		event, err := e.Elems[0].Str()
+		if err != nil {
+			return err
+		}
+		latest, err := e.Elems[2].Int64()
+		if err != nil {
+			return err
+		}
+		max, err := e.Elems[3].Int64()
+		if err != nil {
+			return err
+		}
+		name := metricNameRe.ReplaceAllString(event, "_")
+		stat["latency."+name+".latest"] = float64(latest)
+		stat["latency."+name+".max"] = float64(max)
+	}
+	return nil
+}
+
 // MetricKeyPrefix interface for PluginWithPrefix
 func (m RedisPlugin) MetricKeyPrefix() string {
 	if m.Prefix == "" {
@@ -112,7 +181,13 @@ func (m RedisPlugin) FetchMetrics() (map[string]interface{}, error) {
 		target = m.Socket
 		network = "unix"
 	}
-	c, err := redis.DialTimeout(network, target, time.Duration(m.Timeout)*time.Second)
+	var c *redis.Client
+	var err error
+	if m.TLSConfig != nil && network == "tcp" {
+		c, err = dialTLS(target, m.TLSConfig, time.Duration(m.Timeout)*time.Second)
+	} else {
+		c, err = redis.DialTimeout(network, target, time.Duration(m.Timeout)*time.Second)
+	}
 	if err != nil {
 		logger.Errorf("Failed to connect redis. %s", err)
 		return nil, err
@@ -120,7 +195,7 @@ func (m RedisPlugin) FetchMetrics() (map[string]interface{}, error) {
 	defer c.Close()
 
 	if m.Password != "" {
-		if err = authenticateByPassword(c, m.Password); err != nil {
+		if err = authenticateByPassword(c, m.Username, m.Password); err != nil {
 			return nil, err
 		}
 	}
@@ -137,24 +212,12 @@ func (m RedisPlugin) FetchMetrics() (map[string]interface{}, error) {
 	}
 
 	stat := make(map[string]interface{})
+	info := parseInfo(str)
 
 	keysStat := 0.0
 	expiredStat := 0.0
 
-	for _, line := range strings.Split(str, "\r\n") {
-		if line == "" {
-			continue
-		}
-		if re, _ := regexp.MatchString("^#", line); re {
-			continue
-		}
-
-		record := strings.SplitN(line, ":", 2)
-		if len(record) < 2 {
-			continue
-		}
-		key, value := record[0], record[1]
-
+	for key, value := range info {
 		if re, _ := regexp.MatchString("^db", key); re {
 			kv := strings.SplitN(value, ",", 3)
 			keys, expired := kv[0], kv[1]
@@ -173,6 +236,7 @@ func (m RedisPlugin) FetchMetrics() (map[string]interface{}, error) {
 			}
 			expiredStat += expiredFv
 
+			parseKeyspace(key, value, stat)
 			continue
 		}
 
@@ -192,10 +256,32 @@ func (m RedisPlugin) FetchMetrics() (map[string]interface{}, error) {
 		stat["expired"] = 0
 	}
 
+	parseReplication(info, stat)
+
+	if info["redis_mode"] == "sentinel" {
+		// the sentinel has neither keyspace nor CONFIG command
+		parseSentinelMasters(info, stat)
+		return stat, nil
+	}
+
 	if err := calculateCapacity(c, stat); err != nil {
 		logger.Infof("Failed to calculate capacity. (The cause may be that AWS Elasticache Redis has no `CONFIG` command.) Skip these metrics. %s", err)
 	}
 
+	if info["cluster_enabled"] == "1" {
+		if err := fetchClusterInfo(c, stat); err != nil {
+			logger.Infof("Failed to fetch cluster info. Skip these metrics. %s", err)
+		}
+	}
+	if m.CommandStats {
+		if err := fetchCommandStats(c, stat); err != nil {
+			logger.Infof("Failed to fetch commandstats. Skip these metrics. %s", err)
+		}
+	}
+	if err := fetchLatency(c, stat); err != nil {
+		logger.Infof("Failed to fetch latency. (The cause may be that the command is disabled.) Skip these metrics. %s", err)
+	}
+
 	return stat, nil
 }
 
@@ -262,6 +348,80 @@ func (m RedisPlugin) GraphDefinition() map[string]mp.Graphs {
 				{Name: "percentage_of_clients", Label: "Percentage of clients", Diff: false},
 			},
 		},
+		"db.#": {
+			Label: (labelPrefix + " Keys per DB"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "keys", Label: "Keys", Diff: false},
+				{Name: "expires", Label: "Keys with Expiration", Diff: false},
+			},
+		},
+		"replication_lag.#": {
+			Label: (labelPrefix + " Replication Lag"),
+			Unit:  "bytes",
+			Metrics: []mp.Metrics{
+				{Name: "bytes", Label: "Lag", Diff: false},
+			},
+		},
+		"cluster_state": {
+			Label: (labelPrefix + " Cluster State"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_state", Label: "OK", Diff: false},
+			},
+		},
+		"cluster_slots": {
+			Label: (labelPrefix + " Cluster Slots"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_slots_assigned", Label: "Assigned", Diff: false},
+				{Name: "cluster_slots_ok", Label: "OK", Diff: false},
+				{Name: "cluster_slots_pfail", Label: "Possibly Failed", Diff: false},
+				{Name: "cluster_slots_fail", Label: "Failed", Diff: false},
+			},
+		},
+		"cluster_nodes": {
+			Label: (labelPrefix + " Cluster Nodes"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "cluster_known_nodes", Label: "Known Nodes", Diff: false},
+				{Name: "cluster_size", Label: "Masters Serving Slots", Diff: false},
+			},
+		},
+		"sentinel_master.#": {
+			Label: (labelPrefix + " Sentinel Master"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "status", Label: "OK", Diff: false},
+				{Name: "slaves", Label: "Slaves", Diff: false},
+				{Name: "sentinels", Label: "Sentinels", Diff: false},
+			},
+		},
+		"latency.#": {
+			Label: (labelPrefix + " Latency (ms)"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "latest", Label: "Latest", Diff: false},
+				{Name: "max", Label: "Max", Diff: false},
+			},
+		},
+	}
+
+	if m.CommandStats {
+		graphdef["command_calls.#"] = mp.Graphs{
+			Label: (labelPrefix + " Command Calls"),
+			Unit:  "integer",
+			Metrics: []mp.Metrics{
+				{Name: "calls", Label: "Calls", Diff: true},
+			},
+		}
+		graphdef["command_usec_per_call.#"] = mp.Graphs{
+			Label: (labelPrefix + " Command Usec per Call"),
+			Unit:  "float",
+			Metrics: []mp.Metrics{
+				{Name: "usec_per_call", Label: "Usec per Call", Diff: false},
+			},
+		}
 	}
 
 	return graphdef
@@ -271,23 +431,42 @@ func (m RedisPlugin) GraphDefinition() map[string]mp.Graphs {
 func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "6379", "Port")
-	optPassowrd := flag.String("password", "", "Password")
+	var opts redisopt.Options
+	opts.AddFlags(flag.CommandLine)
 	optSocket := flag.String("socket", "", "Server socket (overrides host and port)")
 	optPrefix := flag.String("metric-key-prefix", "redis", "Metric key prefix")
 	optTimeout := flag.Int("timeout", 5, "Timeout")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optCommandStats := flag.Bool("commandstats", false, "Post the calls and the usec per call of each command")
 	flag.Parse()
 
 	redis := RedisPlugin{
-		Timeout: *optTimeout,
-		Prefix:  *optPrefix,
+		Timeout:      *optTimeout,
+		Prefix:       *optPrefix,
+		CommandStats: *optCommandStats,
+	}
+	if *optSocket != "" && opts.TLS {
+		fmt.Fprintln(os.Stderr, "failed to exec mackerel-plugin-redis: -tls cannot be used with -socket")
+		os.Exit(1)
+	}
+	password, err := opts.ResolvePassword()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-redis: %s\n", err)
+		os.Exit(1)
 	}
+	redis.Username = opts.Username
+	redis.Password = password
 	if *optSocket != "" {
 		redis.Socket = *optSocket
 	} else {
 		redis.Host = *optHost
 		redis.Port = *optPort
-		redis.Password = *optPassowrd
+		config, err := opts.TLSConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-redis: %s\n", err)
+			os.Exit(1)
+		}
+		redis.TLSConfig = config
 	}
 	helper := mp.NewMackerelPlugin(redis)
 	helper.Tempfile = *optTempfile
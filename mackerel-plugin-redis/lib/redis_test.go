@@ -144,3 +144,97 @@ func TestFetchMetrics(t *testing.T) {
 		}
 	}
 }
+
+func TestParseInfo(t *testing.T) {
+	str := "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=10.0.0.2,port=6379,state=online,offset=1000,lag=0\r\n" +
+		"slave1:ip=10.0.0.3,port=6380,state=online,offset=400,lag=1\r\n" +
+		"master_repl_offset:1200\r\n\r\n" +
+		"# Keyspace\r\ndb0:keys=10,expires=2,avg_ttl=1000\r\ndb3:keys=5,expires=0,avg_ttl=0\r\n"
+	info := parseInfo(str)
+	if info["role"] != "master" {
+		t.Errorf("role should be master, but %v", info["role"])
+	}
+
+	stat := make(map[string]interface{})
+	parseKeyspace("db0", info["db0"], stat)
+	parseKeyspace("db3", info["db3"], stat)
+	parseReplication(info, stat)
+
+	expected := map[string]float64{
+		"db.db0.keys":                         10,
+		"db.db0.expires":                      2,
+		"db.db3.keys":                         5,
+		"replication_lag.10_0_0_2_6379.bytes": 200,
+		"replication_lag.10_0_0_3_6380.bytes": 800,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("metric of %s should be %v, but %v", k, v, stat[k])
+		}
+	}
+}
+
+func TestParseSentinelMasters(t *testing.T) {
+	str := "# Sentinel\r\nsentinel_masters:2\r\n" +
+		"master0:name=mymaster,status=ok,address=10.0.0.1:6379,slaves=2,sentinels=3\r\n" +
+		"master1:name=cache.main,status=odown,address=10.0.0.5:6379,slaves=1,sentinels=3\r\n"
+	stat := make(map[string]interface{})
+	parseSentinelMasters(parseInfo(str), stat)
+
+	expected := map[string]float64{
+		"sentinel_master.mymaster.status":    1,
+		"sentinel_master.mymaster.slaves":    2,
+		"sentinel_master.mymaster.sentinels": 3,
+		"sentinel_master.cache_main.status":  0,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("metric of %s should be %v, but %v", k, v, stat[k])
+		}
+	}
+}
+
+func TestParseClusterInfo(t *testing.T) {
+	str := "cluster_state:fail\r\ncluster_slots_assigned:16384\r\ncluster_slots_ok:16000\r\n" +
+		"cluster_slots_pfail:0\r\ncluster_slots_fail:384\r\ncluster_known_nodes:6\r\ncluster_size:3\r\n" +
+		"cluster_current_epoch:6\r\n"
+	stat := make(map[string]interface{})
+	parseClusterInfo(str, stat)
+
+	expected := map[string]float64{
+		"cluster_state":          0,
+		"cluster_slots_assigned": 16384,
+		"cluster_slots_fail":     384,
+		"cluster_known_nodes":    6,
+		"cluster_size":           3,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("metric of %s should be %v, but %v", k, v, stat[k])
+		}
+	}
+	if _, ok := stat["cluster_current_epoch"]; ok {
+		t.Errorf("metric of cluster_current_epoch should not be posted")
+	}
+}
+
+func TestParseCommandStats(t *testing.T) {
+	str := "# Commandstats\r\n" +
+		"cmdstat_get:calls=20,usec=150,usec_per_call=7.50\r\n" +
+		"cmdstat_client|list:calls=1,usec=30,usec_per_call=30.00,rejected_calls=0,failed_calls=0\r\n"
+	stat := make(map[string]interface{})
+	parseCommandStats(str, stat)
+
+	expected := map[string]float64{
+		"command_calls.get.calls":                         20,
+		"command_usec_per_call.get.usec_per_call":         7.5,
+		"command_calls.client_list.calls":                 1,
+		"command_usec_per_call.client_list.usec_per_call": 30,
+	}
+	for k, v := range expected {
+		if stat[k] != v {
+			t.Errorf("metric of %s should be %v, but %v", k, v, stat[k])
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package mpredis
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// dialTLS connects to the server over TLS, where the timeout is applied to the whole of the connection
+func dialTLS(address string, config *tls.Config, timeout time.Duration) (*redis.Client, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	return redis.NewClient(conn)
+}
@@ -0,0 +1,39 @@
+package mpsidekiq
+
+import (
+	"crypto/tls"
+
+	r "github.com/go-redis/redis"
+)
+
+// newOptions returns the options of the client, which authenticates as the ACL user of Redis 6 if the username is given
+func newOptions(addr, username, password string, db int, tlsConfig *tls.Config) *r.Options {
+	opts := &r.Options{
+		Addr:      addr,
+		Password:  password,
+		DB:        db,
+		TLSConfig: tlsConfig,
+	}
+	if username != "" {
+		// the client sends AUTH with the password only, and would send SELECT before AUTH
+		opts.Password = ""
+		opts.DB = 0
+		opts.OnConnect = func(cn *r.Conn) error {
+			return authenticate(func(args ...interface{}) error {
+				return cn.Do(args...).Err()
+			}, username, password, db)
+		}
+	}
+	return opts
+}
+
+// authenticate sends AUTH as the ACL user, and SELECT after the authentication
+func authenticate(do func(args ...interface{}) error, username, password string, db int) error {
+	if err := do("AUTH", username, password); err != nil {
+		return err
+	}
+	if db == 0 {
+		return nil
+	}
+	return do("SELECT", db)
+}
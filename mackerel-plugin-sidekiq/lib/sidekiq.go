@@ -1,20 +1,28 @@
 package mpsidekiq
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"regexp"
 	"strconv"
+	"time"
 
 	r "github.com/go-redis/redis"
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
+	"github.com/mackerelio/mackerel-agent-plugins/internal/redisopt"
 )
 
 // SidekiqPlugin for fetching metrics
 type SidekiqPlugin struct {
-	Client *r.Client
-	Prefix string
+	Client    *r.Client
+	Prefix    string
+	Namespace string
 }
 
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
 var graphdef = map[string]mp.Graphs{
 	"ProcessedANDFailed": mp.Graphs{
 		Label: "Sidekiq processed and failed count",
@@ -35,6 +43,28 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "dead", Label: "Dead", Type: "uint64"},
 		},
 	},
+	"queue_size.#": mp.Graphs{
+		Label: "Sidekiq queue size",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "size", Label: "Size", Type: "uint64"},
+		},
+	},
+	"queue_latency.#": mp.Graphs{
+		Label: "Sidekiq queue latency",
+		Unit:  "float",
+		Metrics: []mp.Metrics{
+			{Name: "latency", Label: "Latency (sec)", Type: "float64"},
+		},
+	},
+	"process_busy.#": mp.Graphs{
+		Label: "Sidekiq process busy",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "busy", Label: "Busy", Type: "uint64"},
+			{Name: "concurrency", Label: "Concurrency", Type: "uint64"},
+		},
+	},
 }
 
 // GraphDefinition Graph definition
@@ -42,8 +72,16 @@ func (sp SidekiqPlugin) GraphDefinition() map[string]mp.Graphs {
 	return graphdef
 }
 
+// key returns the key prefixed with the namespace of redis-namespace
+func (sp SidekiqPlugin) key(key string) string {
+	if sp.Namespace == "" {
+		return key
+	}
+	return sp.Namespace + ":" + key
+}
+
 func (sp SidekiqPlugin) get(key string) uint64 {
-	val, err := sp.Client.Get(key).Result()
+	val, err := sp.Client.Get(sp.key(key)).Result()
 	if err == r.Nil {
 		return 0
 	}
@@ -53,7 +91,7 @@ func (sp SidekiqPlugin) get(key string) uint64 {
 }
 
 func (sp SidekiqPlugin) zCard(key string) uint64 {
-	val, err := sp.Client.ZCard(key).Result()
+	val, err := sp.Client.ZCard(sp.key(key)).Result()
 	if err == r.Nil {
 		return 0
 	}
@@ -62,7 +100,7 @@ func (sp SidekiqPlugin) zCard(key string) uint64 {
 }
 
 func (sp SidekiqPlugin) sMembers(key string) []string {
-	val, err := sp.Client.SMembers(key).Result()
+	val, err := sp.Client.SMembers(sp.key(key)).Result()
 	if err == r.Nil {
 		return make([]string, 0)
 	}
@@ -71,7 +109,7 @@ func (sp SidekiqPlugin) sMembers(key string) []string {
 }
 
 func (sp SidekiqPlugin) hGet(key string, field string) uint64 {
-	val, err := sp.Client.HGet(key, field).Result()
+	val, err := sp.Client.HGet(sp.key(key), field).Result()
 	if err == r.Nil {
 		return 0
 	}
@@ -81,7 +119,7 @@ func (sp SidekiqPlugin) hGet(key string, field string) uint64 {
 }
 
 func (sp SidekiqPlugin) lLen(key string) uint64 {
-	val, err := sp.Client.LLen(key).Result()
+	val, err := sp.Client.LLen(sp.key(key)).Result()
 	if err == r.Nil {
 		return 0
 	}
@@ -89,6 +127,24 @@ func (sp SidekiqPlugin) lLen(key string) uint64 {
 	return uint64(val)
 }
 
+func (sp SidekiqPlugin) hGetString(key string, field string) string {
+	val, err := sp.Client.HGet(sp.key(key), field).Result()
+	if err != nil {
+		return ""
+	}
+
+	return val
+}
+
+func (sp SidekiqPlugin) lIndex(key string, index int64) string {
+	val, err := sp.Client.LIndex(sp.key(key), index).Result()
+	if err != nil {
+		return ""
+	}
+
+	return val
+}
+
 func (sp SidekiqPlugin) getProcessed() uint64 {
 	return sp.get("stat:processed")
 }
@@ -138,6 +194,62 @@ func (sp SidekiqPlugin) getDead() uint64 {
 	return sp.zCard("dead")
 }
 
+// queueLatency returns the seconds since the job was enqueued like Sidekiq::Queue#latency
+func queueLatency(job string, now time.Time) float64 {
+	var j struct {
+		EnqueuedAt float64 `json:"enqueued_at"`
+	}
+	if err := json.Unmarshal([]byte(job), &j); err != nil || j.EnqueuedAt == 0 {
+		return 0
+	}
+	enqueuedAt := j.EnqueuedAt
+	// Sidekiq 8 stores the milliseconds instead of the seconds
+	if enqueuedAt > 1e11 {
+		enqueuedAt /= 1000
+	}
+	latency := float64(now.UnixNano())/float64(time.Second) - enqueuedAt
+	if latency < 0 {
+		return 0
+	}
+	return latency
+}
+
+// processConcurrency returns the concurrency in the info of the process
+func processConcurrency(info string) uint64 {
+	var i struct {
+		Concurrency uint64 `json:"concurrency"`
+	}
+	if err := json.Unmarshal([]byte(info), &i); err != nil {
+		return 0
+	}
+	return i.Concurrency
+}
+
+func (sp SidekiqPlugin) getQueueStats() map[string]interface{} {
+	stats := make(map[string]interface{})
+	now := time.Now()
+	for _, e := range sp.sMembers("queues") {
+		name := metricNameRe.ReplaceAllString(e, "_")
+		stats["queue_size."+name+".size"] = sp.lLen("queue:" + e)
+		// the oldest job is at the tail of the queue
+		stats["queue_latency."+name+".latency"] = queueLatency(sp.lIndex("queue:"+e, -1), now)
+	}
+
+	return stats
+}
+
+func (sp SidekiqPlugin) getProcessStats() map[string]interface{} {
+	stats := make(map[string]interface{})
+	// the identities of the processes like "hostname:pid:nonce"
+	for _, e := range sp.sMembers("processes") {
+		name := metricNameRe.ReplaceAllString(e, "_")
+		stats["process_busy."+name+".busy"] = sp.hGet(e, "busy")
+		stats["process_busy."+name+".concurrency"] = processConcurrency(sp.hGetString(e, "info"))
+	}
+
+	return stats
+}
+
 func (sp SidekiqPlugin) getProcessedFailed() map[string]interface{} {
 	data := make(map[string]interface{}, 20)
 
@@ -181,6 +293,12 @@ func (sp SidekiqPlugin) FetchMetrics() (map[string]interface{}, error) {
 
 		return map1
 	}(stats, pf)
+	for k, v := range sp.getQueueStats() {
+		m[k] = v
+	}
+	for k, v := range sp.getProcessStats() {
+		m[k] = v
+	}
 
 	return m, nil
 }
@@ -197,21 +315,31 @@ func (sp SidekiqPlugin) MetricKeyPrefix() string {
 func Do() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "6379", "Port")
-	optPassword := flag.String("password", "", "Password")
+	var opts redisopt.Options
+	opts.AddFlags(flag.CommandLine)
 	optDB := flag.Int("db", 0, "DB")
 	optPrefix := flag.String("metric-key-prefix", "sidekiq", "Metric key prefix")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optNamespace := flag.String("redis-namespace", "", "Namespace of redis-namespace")
 	flag.Parse()
 
-	client := r.NewClient(&r.Options{
-		Addr:     fmt.Sprintf("%s:%s", *optHost, *optPort),
-		Password: *optPassword,
-		DB:       *optDB,
-	})
+	password, err := opts.ResolvePassword()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-sidekiq: %s\n", err)
+		os.Exit(1)
+	}
+	tlsConfig, err := opts.TLSConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-sidekiq: %s\n", err)
+		os.Exit(1)
+	}
+
+	client := r.NewClient(newOptions(fmt.Sprintf("%s:%s", *optHost, *optPort), opts.Username, password, *optDB, tlsConfig))
 
 	sp := SidekiqPlugin{
-		Client: client,
-		Prefix: *optPrefix,
+		Client:    client,
+		Prefix:    *optPrefix,
+		Namespace: *optNamespace,
 	}
 	helper := mp.NewMackerelPlugin(sp)
 	helper.Tempfile = *optTempfile
@@ -1,7 +1,11 @@
 package mpsidekiq
 
 import (
+	"crypto/tls"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGraphDefinition(t *testing.T) {
@@ -9,9 +13,86 @@ func TestGraphDefinition(t *testing.T) {
 
 	graphdef := sp.GraphDefinition()
 
-	expect := 2
+	expect := 5
 
 	if len(graphdef) != expect {
 		t.Errorf("GraphDefinition(): %d should be %d", len(graphdef), expect)
 	}
 }
+
+func TestKey(t *testing.T) {
+	sp := SidekiqPlugin{}
+	if k := sp.key("stat:processed"); k != "stat:processed" {
+		t.Errorf("key(): %s should be stat:processed", k)
+	}
+	sp.Namespace = "myapp"
+	if k := sp.key("stat:processed"); k != "myapp:stat:processed" {
+		t.Errorf("key(): %s should be myapp:stat:processed", k)
+	}
+}
+
+func TestQueueLatency(t *testing.T) {
+	now := time.Unix(1500000010, 500000000)
+	tests := []struct {
+		job    string
+		expect float64
+	}{
+		{`{"class":"HardWorker","enqueued_at":1500000000.5}`, 10},
+		{`{"class":"HardWorker","enqueued_at":1500000000500}`, 10},
+		{`{"class":"HardWorker","enqueued_at":1500000020}`, 0},
+		{``, 0},
+	}
+	for _, tc := range tests {
+		if l := queueLatency(tc.job, now); l != tc.expect {
+			t.Errorf("queueLatency(%q): %f should be %f", tc.job, l, tc.expect)
+		}
+	}
+}
+
+func TestProcessConcurrency(t *testing.T) {
+	info := `{"hostname":"worker01","started_at":1500000000.0,"pid":1234,"tag":"app","concurrency":25,"queues":["default"],"labels":[],"identity":"worker01:1234:0123456789ab"}`
+	if c := processConcurrency(info); c != 25 {
+		t.Errorf("processConcurrency(): %d should be 25", c)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	opts := newOptions("localhost:6379", "", "secret", 1, nil)
+	if opts.Password != "secret" || opts.OnConnect != nil {
+		t.Errorf("newOptions(): password should be sent by the client, but %+v", opts)
+	}
+
+	opts = newOptions("localhost:6379", "sidekiq", "secret", 1, &tls.Config{})
+	if opts.Password != "" || opts.OnConnect == nil {
+		t.Errorf("newOptions(): password should be sent with the username on connect, but %+v", opts)
+	}
+	if opts.DB != 0 {
+		t.Errorf("newOptions(): DB should be selected after AUTH, but %d", opts.DB)
+	}
+	if opts.TLSConfig == nil {
+		t.Errorf("newOptions(): TLS config should be set")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	var cmds []string
+	do := func(args ...interface{}) error {
+		cmds = append(cmds, fmt.Sprintf("%v", args))
+		return nil
+	}
+	if err := authenticate(do, "sidekiq", "secret", 1); err != nil {
+		t.Errorf("authenticate(): %s", err)
+	}
+	expect := []string{"[AUTH sidekiq secret]", "[SELECT 1]"}
+	if !reflect.DeepEqual(cmds, expect) {
+		t.Errorf("authenticate(): %q should be %q", cmds, expect)
+	}
+
+	cmds = nil
+	if err := authenticate(do, "sidekiq", "secret", 0); err != nil {
+		t.Errorf("authenticate(): %s", err)
+	}
+	if len(cmds) != 1 {
+		t.Errorf("authenticate(): SELECT should not be sent for DB 0, but %q", cmds)
+	}
+}
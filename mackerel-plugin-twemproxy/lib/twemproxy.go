@@ -3,6 +3,7 @@ package mptwemproxy
 import (
 	"flag"
 	"fmt"
+	"os"
 	"regexp"
 	"strings"
 
@@ -14,6 +15,9 @@ type TwemproxyPlugin struct {
 	Address string
 	Prefix  string
 	Timeout uint
+	// the pools and servers matched are posted, while the totals are of all pools and servers
+	PoolFilter   *regexp.Regexp
+	ServerFilter *regexp.Regexp
 }
 
 // MetricKeyPrefix interface for PluginWithPrefix
@@ -138,9 +142,21 @@ func (p TwemproxyPlugin) FetchMetrics() (map[string]interface{}, error) {
 	totalPoolForwardErr := uint64(0)
 	totalServerTimeout := uint64(0)
 	totalServerErr := uint64(0)
+	poolFilter, serverFilter := p.PoolFilter, p.ServerFilter
 	// NOTE: Each custom metric name contains a wildcard.
 	for pName, p := range stats.Pools {
 		// A normalized pool name corresponds a wildcard
+		totalPoolClientErr += *p.ClientErr
+		totalPoolServerEjects += *p.ServerEjects
+		totalPoolForwardErr += *p.ForwardError
+		for _, s := range p.Servers {
+			totalServerTimeout += *s.ServerTimedout
+			totalServerErr += *s.ServerErr
+		}
+		if !matchFilter(poolFilter, pName) {
+			continue
+		}
+
 		np := normalizeMetricName(pName)
 		wp := "." + np + "."
 		metrics["pool_error"+wp+"client_err"] = *p.ClientErr
@@ -148,11 +164,11 @@ func (p TwemproxyPlugin) FetchMetrics() (map[string]interface{}, error) {
 		metrics["pool_error"+wp+"forward_error"] = *p.ForwardError
 		metrics["pool_client_connections"+wp+"client_eof"] = *p.ClientEOF
 		metrics["pool_client_connections"+wp+"client_connections"] = *p.ClientConnections
-		totalPoolClientErr += *p.ClientErr
-		totalPoolServerEjects += *p.ServerEjects
-		totalPoolForwardErr += *p.ForwardError
 
 		for sName, s := range p.Servers {
+			if !matchFilter(serverFilter, sName) {
+				continue
+			}
 			// A concat of normalized pool and server names corresponds a wildcard
 			ns := normalizeMetricName(sName)
 			ws := "." + np + "_" + ns + "."
@@ -168,8 +184,6 @@ func (p TwemproxyPlugin) FetchMetrics() (map[string]interface{}, error) {
 			metrics["server_communications"+ws+"responses"] = *s.Responses
 			metrics["server_communication_bytes"+ws+"request_bytes"] = *s.RequestBytes
 			metrics["server_communication_bytes"+ws+"response_bytes"] = *s.ResponseBytes
-			totalServerTimeout += *s.ServerTimedout
-			totalServerErr += *s.ServerErr
 		}
 	}
 	metrics["total_pool_client_error"] = totalPoolClientErr
@@ -180,6 +194,10 @@ func (p TwemproxyPlugin) FetchMetrics() (map[string]interface{}, error) {
 	return metrics, nil
 }
 
+func matchFilter(filter *regexp.Regexp, name string) bool {
+	return filter == nil || filter.MatchString(name)
+}
+
 var normalizeMetricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
 
 func normalizeMetricName(name string) string {
@@ -192,6 +210,8 @@ func Do() {
 	optPrefix := flag.String("metric-key-prefix", "twemproxy", "Metric key prefix")
 	optTimeout := flag.Uint("timeout", 5, "Timeout")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optPoolRegexp := flag.String("pool-regexp", "", "Regexp of the pool names to be posted")
+	optServerRegexp := flag.String("server-regexp", "", "Regexp of the server names to be posted")
 	flag.Parse()
 
 	p := TwemproxyPlugin{
@@ -199,6 +219,22 @@ func Do() {
 		Prefix:  *optPrefix,
 		Timeout: *optTimeout,
 	}
+	if *optPoolRegexp != "" {
+		re, err := regexp.Compile(*optPoolRegexp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-twemproxy: invalid pool-regexp: %s\n", err)
+			os.Exit(1)
+		}
+		p.PoolFilter = re
+	}
+	if *optServerRegexp != "" {
+		re, err := regexp.Compile(*optServerRegexp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to exec mackerel-plugin-twemproxy: invalid server-regexp: %s\n", err)
+			os.Exit(1)
+		}
+		p.ServerFilter = re
+	}
 
 	helper := mp.NewMackerelPlugin(p)
 	helper.Tempfile = *optTempfile
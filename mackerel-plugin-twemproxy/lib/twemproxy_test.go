@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -223,6 +224,45 @@ func TestFetchMetrics(t *testing.T) {
 	}
 }
 
+func TestFetchMetricsWithFilter(t *testing.T) {
+	stats = jsonStr
+
+	p := TwemproxyPlugin{
+		Address:      "localhost:" + strconv.Itoa(statsServer.Port()),
+		Prefix:       "twemproxy",
+		Timeout:      5,
+		PoolFilter:   regexp.MustCompile(`^redis/`),
+		ServerFilter: regexp.MustCompile(`^budget2\.`),
+	}
+	metrics, err := p.FetchMetrics()
+	if err != nil {
+		t.Errorf("Failed to FetchMetrics: %s", err)
+		return
+	}
+
+	for _, k := range []string{
+		"pool_error.redis_budget.client_err",
+		"server_communications.redis_budget_budget2_cache_6379.requests",
+	} {
+		if _, ok := metrics[k]; !ok {
+			t.Errorf("metric of %s should be fetched", k)
+		}
+	}
+	for _, k := range []string{
+		"pool_error.redis-index.client_err",
+		"server_communications.redis-index_index1_cache_6379.requests",
+		"server_communications.redis_budget_budget1_cache_6379.requests",
+	} {
+		if _, ok := metrics[k]; ok {
+			t.Errorf("metric of %s should be filtered", k)
+		}
+	}
+	// the totals are of all pools and servers
+	if v := metrics["total_server_error"]; v != uint64(13) {
+		t.Errorf("metric of total_server_error should be 13, but %v", v)
+	}
+}
+
 func TestFetchMetricsFail(t *testing.T) {
 	assertPanic := func(t *testing.T, f func() (map[string]interface{}, error)) {
 		defer func() {
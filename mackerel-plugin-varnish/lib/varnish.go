@@ -1,11 +1,12 @@
 package mpvarnish
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os/exec"
+	"path"
 	"regexp"
-	"strconv"
 	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin-helper"
@@ -68,6 +69,60 @@ var graphdef = map[string]mp.Graphs{
 			{Name: "available", Label: "Available", Diff: false},
 		},
 	},
+	"varnish.smf.g_alloc.#": {
+		Label: "Varnish SMF Allocations",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "g_alloc", Label: "num", Diff: false},
+		},
+	},
+	"varnish.smf.memory.#": {
+		Label: "Varnish SMF Memory",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "allocated", Label: "Allocated", Diff: false},
+			{Name: "available", Label: "Available", Diff: false},
+		},
+	},
+	"varnish.vbe.requests.#": {
+		Label: "Varnish Backend Requests",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "req", Label: "Requests", Diff: true},
+		},
+	},
+	"varnish.vbe.concurrent.#": {
+		Label: "Varnish Backend Concurrent Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "conn", Label: "Concurrent connections", Diff: false},
+		},
+	},
+	"varnish.vbe.connections.#": {
+		Label: "Varnish Backend Connections",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "fail", Label: "Conn fail", Diff: true},
+		},
+	},
+}
+
+// the graphs of the counters specified by -include
+var includeGraphdef = map[string]mp.Graphs{
+	"varnish.counter.#": {
+		Label: "Varnish Counters",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "value", Label: "Value", Diff: true},
+		},
+	},
+	"varnish.gauge.#": {
+		Label: "Varnish Gauges",
+		Unit:  "integer",
+		Metrics: []mp.Metrics{
+			{Name: "value", Label: "Value", Diff: false},
+		},
+	},
 }
 
 // VarnishPlugin mackerel plugin for varnish
@@ -75,42 +130,73 @@ type VarnishPlugin struct {
 	VarnishStatPath string
 	VarnishName     string
 	Tempfile        string
+	// globs of the names of the counters posted as they are, such as "VMOD.*"
+	Includes []string
+}
+
+// counter is a counter of varnishstat -j
+type counter struct {
+	Flag  string  `json:"flag"`
+	Value float64 `json:"value"`
+}
+
+var metricNameRe = regexp.MustCompile(`[^-a-zA-Z0-9_]`)
+
+// parseVarnishStat parses the output of varnishstat -j, where the counters are in "counters" since Varnish 6.5
+func parseVarnishStat(out []byte) (map[string]counter, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(out, &root); err != nil {
+		return nil, err
+	}
+	if c, ok := root["counters"]; ok {
+		root = nil
+		if err := json.Unmarshal(c, &root); err != nil {
+			return nil, err
+		}
+	}
+
+	counters := make(map[string]counter, len(root))
+	for name, raw := range root {
+		// skipping "timestamp" and "version"
+		var c counter
+		if err := json.Unmarshal(raw, &c); err != nil {
+			continue
+		}
+		counters[name] = c
+	}
+	return counters, nil
 }
 
 // FetchMetrics interface for mackerelplugin
 func (m VarnishPlugin) FetchMetrics() (map[string]interface{}, error) {
-	var out []byte
-	var err error
-
-	if m.VarnishName == "" {
-		out, err = exec.Command(m.VarnishStatPath, "-1").CombinedOutput()
-	} else {
-		out, err = exec.Command(m.VarnishStatPath, "-1", "-n", m.VarnishName).CombinedOutput()
+	args := []string{"-j"}
+	if m.VarnishName != "" {
+		args = append(args, "-n", m.VarnishName)
 	}
+	out, err := exec.Command(m.VarnishStatPath, args...).Output()
 	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, out)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s: %s", err, exitErr.Stderr)
+		}
+		return nil, err
 	}
 
-	lineexp := regexp.MustCompile("^([^ ]+) +(\\d+)")
-	smaexp := regexp.MustCompile("^SMA\\.([^\\.]+)\\.(.+)$")
+	counters, err := parseVarnishStat(out)
+	if err != nil {
+		return nil, err
+	}
+	return m.parseCounters(counters), nil
+}
 
+func (m VarnishPlugin) parseCounters(counters map[string]counter) map[string]interface{} {
 	stat := map[string]interface{}{
 		"requests": float64(0),
 	}
+	backends := make(map[string]map[string]float64)
 
-	var tmpv float64
-	for _, line := range strings.Split(string(out), "\n") {
-		match := lineexp.FindStringSubmatch(line)
-		if match == nil {
-			continue
-		}
-
-		tmpv, err = strconv.ParseFloat(match[2], 64)
-		if err != nil {
-			continue
-		}
-
-		switch match[1] {
+	for name, c := range counters {
+		tmpv := c.Value
+		switch name {
 		case "cache_hit", "MAIN.cache_hit":
 			stat["cache_hits"] = tmpv
 			stat["requests"] = stat["requests"].(float64) + tmpv
@@ -137,27 +223,96 @@ func (m VarnishPlugin) FetchMetrics() (map[string]interface{}, error) {
 		case "MAIN.busy_wakeup":
 			stat["busy_wakeup"] = tmpv
 		default:
-			smamatch := smaexp.FindStringSubmatch(match[1])
-			if smamatch == nil || smamatch[1] == "Transient" {
-				continue
+			if match := storageExp.FindStringSubmatch(name); match != nil {
+				// SMA.Transient is the storage for the short-lived objects
+				if match[2] == "Transient" {
+					break
+				}
+				storage := strings.ToLower(match[1])
+				ident := metricNameRe.ReplaceAllString(match[2], "_")
+				switch match[3] {
+				case "g_alloc":
+					stat["varnish."+storage+".g_alloc."+ident+".g_alloc"] = tmpv
+				case "g_bytes":
+					stat["varnish."+storage+".memory."+ident+".allocated"] = tmpv
+				case "g_space":
+					stat["varnish."+storage+".memory."+ident+".available"] = tmpv
+				}
+			} else if match := backendExp.FindStringSubmatch(name); match != nil && (match[2] == "req" || match[2] == "conn" || match[2] == "fail") {
+				ident := backendName(match[1])
+				if backends[ident] == nil {
+					backends[ident] = make(map[string]float64)
+				}
+				// the same backend is counted in each VCL loaded
+				backends[ident][match[2]] += tmpv
+			}
+		}
+
+		if m.include(name) {
+			ident := metricNameRe.ReplaceAllString(name, "_")
+			if c.Flag == "c" {
+				stat["varnish.counter."+ident+".value"] = tmpv
+			} else {
+				stat["varnish.gauge."+ident+".value"] = tmpv
 			}
-			if smamatch[2] == "g_alloc" {
-				fmt.Printf("%+v\n", smamatch)
-				stat["varnish.sma.g_alloc."+smamatch[1]+".g_alloc"] = tmpv
-			} else if smamatch[2] == "g_bytes" {
-				stat["varnish.sma.memory."+smamatch[1]+".allocated"] = tmpv
-			} else if smamatch[2] == "g_space" {
-				stat["varnish.sma.memory."+smamatch[1]+".available"] = tmpv
+		}
+	}
+
+	for ident, b := range backends {
+		for name, v := range b {
+			switch name {
+			case "req":
+				stat["varnish.vbe.requests."+ident+".req"] = v
+			case "conn":
+				stat["varnish.vbe.concurrent."+ident+".conn"] = v
+			case "fail":
+				stat["varnish.vbe.connections."+ident+".fail"] = v
 			}
 		}
 	}
 
-	return stat, err
+	return stat
+}
+
+var (
+	// e.g. "SMA.s0.g_bytes" and "SMF.s0.g_space"
+	storageExp = regexp.MustCompile(`^(SMA|SMF)\.(.+)\.([^.]+)$`)
+	// e.g. "VBE.boot.default.req" and "VBE.default(127.0.0.1,,8080).req" of Varnish 4.0
+	backendExp = regexp.MustCompile(`^VBE\.(.+)\.([^.]+)$`)
+)
+
+// backendName returns the name of the backend without the name of VCL like "boot.default" and the address of Varnish 4.0
+func backendName(ident string) string {
+	if i := strings.Index(ident, "("); i >= 0 {
+		ident = ident[:i]
+	} else if i := strings.Index(ident, "."); i >= 0 {
+		ident = ident[i+1:]
+	}
+	return metricNameRe.ReplaceAllString(ident, "_")
+}
+
+func (m VarnishPlugin) include(name string) bool {
+	for _, glob := range m.Includes {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
 }
 
 // GraphDefinition interface for mackerelplugin
 func (m VarnishPlugin) GraphDefinition() map[string]mp.Graphs {
-	return graphdef
+	if len(m.Includes) == 0 {
+		return graphdef
+	}
+	graphs := make(map[string]mp.Graphs, len(graphdef)+len(includeGraphdef))
+	for key, g := range graphdef {
+		graphs[key] = g
+	}
+	for key, g := range includeGraphdef {
+		graphs[key] = g
+	}
+	return graphs
 }
 
 // Do the plugin
@@ -165,11 +320,17 @@ func Do() {
 	optVarnishStatPath := flag.String("varnishstat", "/usr/bin/varnishstat", "Path of varnishstat")
 	optVarnishName := flag.String("varnish-name", "", "Varnish name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	optInclude := flag.String("include", "", "Comma separated globs of the counters to be posted, such as \"VMOD.*\"")
 	flag.Parse()
 
 	var varnish VarnishPlugin
 	varnish.VarnishStatPath = *optVarnishStatPath
 	varnish.VarnishName = *optVarnishName
+	if *optInclude != "" {
+		for _, glob := range strings.Split(*optInclude, ",") {
+			varnish.Includes = append(varnish.Includes, strings.TrimSpace(glob))
+		}
+	}
 	helper := mp.NewMackerelPlugin(varnish)
 
 	if *optTempfile != "" {
@@ -0,0 +1,71 @@
+package mpvarnish
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readCounters(t *testing.T, file string) map[string]counter {
+	out, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := parseVarnishStat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counters
+}
+
+func TestParseVarnish5(t *testing.T) {
+	var varnish VarnishPlugin
+	counters := readCounters(t, "testdata/varnishstat-5.json")
+	assert.Len(t, counters, 14)
+
+	stat := varnish.parseCounters(counters)
+	assert.EqualValues(t, 125, stat["requests"])
+	assert.EqualValues(t, 100, stat["cache_hits"])
+	assert.EqualValues(t, 25, stat["backend_req"])
+	assert.EqualValues(t, 42, stat["n_object"])
+	assert.EqualValues(t, 42, stat["varnish.sma.g_alloc.s0.g_alloc"])
+	assert.EqualValues(t, 1048576, stat["varnish.sma.memory.s0.allocated"])
+	assert.EqualValues(t, 267386880, stat["varnish.sma.memory.s0.available"])
+	assert.Nil(t, stat["varnish.sma.memory.Transient.allocated"])
+	assert.EqualValues(t, 25, stat["varnish.vbe.requests.default.req"])
+	assert.EqualValues(t, 2, stat["varnish.vbe.concurrent.default.conn"])
+	assert.EqualValues(t, 7, stat["varnish.vbe.requests.api.req"])
+}
+
+func TestParseVarnish6(t *testing.T) {
+	varnish := VarnishPlugin{Includes: []string{"VMOD.*"}}
+	counters := readCounters(t, "testdata/varnishstat-6.json")
+	assert.Len(t, counters, 8)
+
+	stat := varnish.parseCounters(counters)
+	assert.EqualValues(t, 120, stat["requests"])
+	assert.EqualValues(t, 3, stat["backend_fail"])
+	assert.EqualValues(t, 10, stat["varnish.smf.g_alloc.s0.g_alloc"])
+	assert.EqualValues(t, 1073741824, stat["varnish.smf.memory.s0.available"])
+	assert.EqualValues(t, 3, stat["varnish.vbe.connections.default.fail"])
+	assert.EqualValues(t, 12, stat["varnish.counter.VMOD_goto_default_dns_lookups.value"])
+	assert.EqualValues(t, 4, stat["varnish.gauge.VMOD_goto_default_backends.value"])
+	assert.Nil(t, stat["varnish.counter.MAIN_cache_hit.value"])
+}
+
+func TestBackendName(t *testing.T) {
+	assert.Equal(t, "default", backendName("boot.default"))
+	assert.Equal(t, "default", backendName("default(127.0.0.1,,8080)"))
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var varnish VarnishPlugin
+	// the concurrent connections of VBE are a gauge
+	assert.False(t, varnish.GraphDefinition()["varnish.vbe.concurrent.#"].Metrics[0].Diff)
+
+	assert.Len(t, varnish.GraphDefinition(), 12)
+
+	varnish.Includes = []string{"VMOD.*"}
+	assert.Len(t, varnish.GraphDefinition(), 14)
+}